@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// APIConfig represents settings for the HTTP JSON API
+type APIConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Port    int    `yaml:"port"`
+	Token   string `yaml:"token"` // Required in "Authorization: Bearer <token>" header
+}
+
+// apiCategory represents a category in API responses
+type apiCategory struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// apiFile represents a stored file in API responses
+type apiFile struct {
+	Name       string            `json:"name"`        // Original filename when the bot saved the file, else the stored name
+	StoredName string            `json:"stored_name"` // Slash-separated name under the category, used in /api/files/{category}/{name}
+	Category   string            `json:"category"`
+	Path       string            `json:"path"`
+	Size       int64             `json:"size"`
+	Modified   time.Time         `json:"modified"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Source     string            `json:"source,omitempty"` // Type of chat the file was sent in
+}
+
+// Start HTTP JSON API server, blocks until the server stops
+func startAPIServer() {
+	if config.API.Token == "" {
+		log.Printf("API is enabled but no token is configured, not starting API server")
+		return
+	}
+
+	port := config.API.Port
+	if port == 0 {
+		port = 8080
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/categories", handleAPICategories)
+	mux.HandleFunc("GET /api/files", handleAPIFiles)
+	mux.HandleFunc("GET /api/files/{category}/{name...}", handleAPIFile)
+	mux.HandleFunc("GET /api/trends", handleAPITrends)
+	mux.HandleFunc("GET /api/metadata.csv", handleAPIMetadataCSV)
+
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("Starting API server on %s", addr)
+	if err := http.ListenAndServe(addr, requireAPIToken(mux)); err != nil {
+		log.Printf("API server stopped: %v", err)
+	}
+}
+
+// Reject requests without a valid API token
+func requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.API.Token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List configured categories
+func handleAPICategories(w http.ResponseWriter, r *http.Request) {
+	configured := getCategories()
+	categories := make([]apiCategory, 0, len(configured))
+	for _, cat := range configured {
+		categories = append(categories, apiCategory{Name: cat.Name, Path: cat.Path})
+	}
+	writeAPIJSON(w, categories)
+}
+
+// List files, optionally filtered by category and name substring
+func handleAPIFiles(w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+	query := strings.ToLower(r.URL.Query().Get("q"))
+
+	if category != "" {
+		if _, exists := categoryPath(category); !exists {
+			writeAPIError(w, http.StatusNotFound, "unknown category")
+			return
+		}
+	}
+
+	// Metadata of saved files by path, deduplicated uploads share a path
+	recordsByPath := make(map[string][]FileRecord)
+	for _, record := range metadata.Records() {
+		recordsByPath[record.Path] = append(recordsByPath[record.Path], record)
+	}
+
+	files := []apiFile{}
+	for _, cat := range getCategories() {
+		if category != "" && cat.Name != category {
+			continue
+		}
+
+		root := categoryRoot(cat.Name)
+		catFiles, err := listCategoryFiles(cat.Name, root)
+		if err != nil {
+			log.Printf("Error listing files in %s: %v", root, err)
+			continue
+		}
+		for _, file := range catFiles {
+			for _, entry := range apiFileEntries(file, recordsByPath[file.Path]) {
+				if query == "" || matchesAPIQuery(entry, query) {
+					files = append(files, entry)
+				}
+			}
+		}
+	}
+	writeAPIJSON(w, files)
+}
+
+// Describe stored file once per upload recorded for it, named as uploaded
+func apiFileEntries(file apiFile, records []FileRecord) []apiFile {
+	file.StoredName = file.Name
+	if len(records) == 0 {
+		return []apiFile{file}
+	}
+
+	entries := make([]apiFile, 0, len(records))
+	for _, record := range records {
+		entry := file
+		if record.Name != "" {
+			entry.Name = record.Name
+		}
+		entry.Tags = record.Tags
+		entry.Source = record.ChatType
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Check if lowercase query occurs in file name or metadata values
+func matchesAPIQuery(file apiFile, query string) bool {
+	if strings.Contains(strings.ToLower(file.Name), query) {
+		return true
+	}
+	for _, value := range file.Tags {
+		if strings.Contains(strings.ToLower(value), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get metadata of a single file
+func handleAPIFile(w http.ResponseWriter, r *http.Request) {
+	category := r.PathValue("category")
+	if _, exists := categoryPath(category); !exists {
+		writeAPIError(w, http.StatusNotFound, "unknown category")
+		return
+	}
+
+	path, err := resolveCategoryFile(category, r.PathValue("name"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid file name")
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		writeAPIError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	storedName := filepath.ToSlash(filepath.Clean(r.PathValue("name")))
+	record := metadata.FindByPath(path)
+	name := storedName
+	if record.Name != "" {
+		name = record.Name
+	}
+	writeAPIJSON(w, apiFile{
+		Name:       name,
+		StoredName: storedName,
+		Category:   category,
+		Path:       path,
+		Size:       info.Size(),
+		Modified:   info.ModTime(),
+		Tags:       record.Tags,
+		Source:     record.ChatType,
+	})
+}
+
+// List daily usage snapshots
+func handleAPITrends(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, getUsageSnapshots())
+}
+
+// Resolve slash-separated file name inside category path, rejecting names escaping it
+func resolveCategoryFile(category, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) || filepath.IsAbs(cleaned) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	// Folders of other categories may share the root
+	root, folderPrefix := categoryLocation(category)
+	if folderPrefix != "" {
+		folder, _, nested := strings.Cut(cleaned, string(filepath.Separator))
+		if !nested || !strings.HasPrefix(folder, folderPrefix) {
+			return "", fmt.Errorf("file %q is not in category %s", name, category)
+		}
+	}
+	return filepath.Join(root, cleaned), nil
+}
+
+// List regular files stored under category path
+func listCategoryFiles(category, root string) ([]apiFile, error) {
+	var files []apiFile
+	_, folderPrefix := categoryLocation(category)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip hidden entries such as temporary downloads and thumbnail cache, and other categories' folders
+		if path != root && (strings.HasPrefix(d.Name(), ".") || filepath.Dir(path) == filepath.Clean(root) && !ownsRootEntry(folderPrefix, d)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, apiFile{
+			Name:     filepath.ToSlash(rel),
+			Category: category,
+			Path:     path,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// Write value as JSON response
+func writeAPIJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error writing API response: %v", err)
+	}
+}
+
+// Write JSON error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
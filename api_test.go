@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHandleAPIFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string // Names listed, in order
+	}{
+		{name: "all files", wantNames: []string{"ab/abc123", "holiday.jpg", "trip.jpg"}},
+		{name: "query matches original name", query: "holiday", wantNames: []string{"holiday.jpg"}},
+		{name: "query matches every upload of shared content", query: ".jpg", wantNames: []string{"holiday.jpg", "trip.jpg"}},
+		{name: "query matches tags", query: "alice", wantNames: []string{"trip.jpg"}},
+		{name: "query matches stored name without record", query: "abc123", wantNames: []string{"ab/abc123"}},
+		{name: "query does not match hash of saved file", query: "def456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.StorageLayout = storageLayoutContent
+			blob := filepath.Join(dir, "docs", "de", "def456")
+			touchFiles(t, dir, "docs/de/def456", "docs/ab/abc123")
+			metadata.Add(FileRecord{Path: blob, Name: "holiday.jpg", Category: "docs", UserID: 1, ChatType: "private"})
+			metadata.Add(FileRecord{Path: blob, Name: "trip.jpg", Category: "docs", UserID: 2, Tags: map[string]string{"author": "alice"}})
+
+			recorder := httptest.NewRecorder()
+			handleAPIFiles(recorder, httptest.NewRequest("GET", "/api/files?q="+tt.query, nil))
+
+			var files []apiFile
+			if err := json.NewDecoder(recorder.Body).Decode(&files); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name)
+				if want := filepath.ToSlash(mustRel(t, filepath.Join(dir, "docs"), file.Path)); file.StoredName != want {
+					t.Errorf("%s stored name = %q, want %q", file.Name, file.StoredName, want)
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.wantNames) {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestHandleAPIFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   bool // File was saved by the bot
+		wantName string
+	}{
+		{name: "saved by the bot", record: true, wantName: "holiday.jpg"},
+		{name: "added outside the bot", wantName: "ab/abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			touchFiles(t, dir, "docs/ab/abc123")
+			if tt.record {
+				metadata.Add(FileRecord{Path: filepath.Join(dir, "docs", "ab", "abc123"), Name: "holiday.jpg", Category: "docs"})
+			}
+
+			request := httptest.NewRequest("GET", "/api/files/docs/ab/abc123", nil)
+			request.SetPathValue("category", "docs")
+			request.SetPathValue("name", "ab/abc123")
+			recorder := httptest.NewRecorder()
+			handleAPIFile(recorder, request)
+
+			var file apiFile
+			if err := json.NewDecoder(recorder.Body).Decode(&file); err != nil {
+				t.Fatal(err)
+			}
+			if file.Name != tt.wantName || file.StoredName != "ab/abc123" {
+				t.Errorf("name = %q, stored name = %q, want %q, ab/abc123", file.Name, file.StoredName, tt.wantName)
+			}
+		})
+	}
+}
+
+// Get path relative to base, failing the test when it is not inside
+func mustRel(t *testing.T, base, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultBanlistPath = "./files/banned.json" // Path to persisted banned user IDs
+
+var (
+	banlistPath = defaultBanlistPath
+	bannedUsers = make(map[int64]bool) // Set of banned user IDs
+)
+
+// Load banned users from file, missing file means nobody is banned
+func loadBanlist(path string) error {
+	banlistPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		bannedUsers[id] = true
+	}
+	log.Printf("Loaded %d banned users", len(ids))
+	return nil
+}
+
+// Persist banned users to file
+func saveBanlist() error {
+	ids := make([]int64, 0, len(bannedUsers))
+	for id := range bannedUsers {
+		ids = append(ids, id)
+	}
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(banlistPath, data)
+}
+
+// Check if user is banned
+func isBanned(userID int64) bool {
+	return bannedUsers[userID]
+}
+
+// Handle ban command
+func handleBanCommand(bot Sender, message *tgbotapi.Message, args string) {
+	userID, ok := parseModerationTarget(bot, message, args, "ban")
+	if !ok {
+		return
+	}
+
+	if isAdmin(userID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Administrators cannot be banned.")
+		bot.Send(msg)
+		return
+	}
+
+	bannedUsers[userID] = true
+	if err := saveBanlist(); err != nil {
+		log.Printf("Error saving banlist: %v", err)
+	}
+	log.Printf("User %d banned by %d", userID, message.From.ID)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("User %d is banned.", userID))
+	bot.Send(msg)
+}
+
+// Handle unban command
+func handleUnbanCommand(bot Sender, message *tgbotapi.Message, args string) {
+	userID, ok := parseModerationTarget(bot, message, args, "unban")
+	if !ok {
+		return
+	}
+
+	if !bannedUsers[userID] {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("User %d is not banned.", userID))
+		bot.Send(msg)
+		return
+	}
+
+	delete(bannedUsers, userID)
+	if err := saveBanlist(); err != nil {
+		log.Printf("Error saving banlist: %v", err)
+	}
+	log.Printf("User %d unbanned by %d", userID, message.From.ID)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("User %d is unbanned.", userID))
+	bot.Send(msg)
+}
+
+// Check admin rights and parse user ID argument of a moderation command
+func parseModerationTarget(bot Sender, message *tgbotapi.Message, args, command string) (int64, bool) {
+	if !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Only bot administrators can use this command.")
+		bot.Send(msg)
+		return 0, false
+	}
+
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Please specify a numeric user ID. Usage: /%s [userID]", command))
+		bot.Send(msg)
+		return 0, false
+	}
+	return userID, true
+}
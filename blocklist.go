@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBlocklistRefresh = time.Hour // How often a blocklist URL is fetched again
+
+var errBlockedFile = errors.New("this file is on the blocklist")
+
+// BlocklistConfig represents the source of SHA-256 hashes of rejected files
+type BlocklistConfig struct {
+	Path    string `yaml:"path"`    // File with one hash per line, sha256sum output works too
+	URL     string `yaml:"url"`     // Fetched at startup and on every refresh
+	Refresh int    `yaml:"refresh"` // Seconds between URL fetches
+}
+
+var (
+	blockedHashesMu sync.RWMutex
+	blockedHashes   = make(map[string]bool) // Set of lowercase hex SHA-256 hashes
+)
+
+// Load hash blocklist from configured file or URL, refreshing URL periodically
+func startHashBlocklist() {
+	source := config.HashBlocklist
+	if source.Path == "" && source.URL == "" {
+		return
+	}
+
+	if err := loadHashBlocklist(); err != nil {
+		log.Printf("Error loading hash blocklist: %v", err)
+	}
+
+	if source.URL == "" {
+		return
+	}
+	interval := time.Duration(source.Refresh) * time.Second
+	if interval <= 0 {
+		interval = defaultBlocklistRefresh
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := loadHashBlocklist(); err != nil {
+				log.Printf("Error refreshing hash blocklist, keeping previous one: %v", err)
+			}
+		}
+	}()
+}
+
+// Read hashes from configured source and replace the current blocklist
+func loadHashBlocklist() error {
+	var r io.Reader
+	if config.HashBlocklist.URL != "" {
+		resp, err := fetchURL(config.HashBlocklist.URL, 0)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error fetching blocklist: %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(config.HashBlocklist.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	hashes, err := parseHashBlocklist(r)
+	if err != nil {
+		return err
+	}
+
+	blockedHashesMu.Lock()
+	blockedHashes = hashes
+	blockedHashesMu.Unlock()
+	log.Printf("Loaded %d blocked hashes", len(hashes))
+	return nil
+}
+
+// Parse first field of each line as a hash, skipping empty lines and comments
+func parseHashBlocklist(r io.Reader) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hash := strings.ToLower(fields[0])
+		if len(hash) != 64 {
+			log.Printf("Skipping invalid blocklist entry %q", fields[0])
+			continue
+		}
+		hashes[hash] = true
+	}
+	return hashes, scanner.Err()
+}
+
+// Check if content hash is on the blocklist
+func isBlockedHash(hash string) bool {
+	blockedHashesMu.RLock()
+	defer blockedHashesMu.RUnlock()
+	return blockedHashes[hash]
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHashBlocklist(t *testing.T) {
+	hashA := strings.Repeat("a", 64)
+	hashB := strings.Repeat("b", 64)
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]bool
+	}{
+		{name: "empty", input: "", want: map[string]bool{}},
+		{name: "one hash per line", input: hashA + "\n" + hashB + "\n", want: map[string]bool{hashA: true, hashB: true}},
+		{name: "sha256sum output", input: hashA + "  bad.exe\n" + hashB + " *other.bin\n", want: map[string]bool{hashA: true, hashB: true}},
+		{name: "uppercase lowered", input: strings.ToUpper(hashA) + "\n", want: map[string]bool{hashA: true}},
+		{name: "comments and blank lines", input: "# known malware\n\n   \n" + hashA + "\n", want: map[string]bool{hashA: true}},
+		{name: "indented hash", input: "\t" + hashA + "\n", want: map[string]bool{hashA: true}},
+		{name: "invalid entries skipped", input: "abc123\n" + hashA + "x\n" + hashB + "\n", want: map[string]bool{hashB: true}},
+		{name: "no trailing newline", input: hashA, want: map[string]bool{hashA: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHashBlocklist(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseHashBlocklist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadHashBlocklist(t *testing.T) {
+	hashA := strings.Repeat("a", 64)
+	previous := strings.Repeat("c", 64)
+	tests := []struct {
+		name    string
+		file    string // Blocklist file content, empty for no file
+		status  int    // Status of the blocklist URL, 0 to use the file
+		body    string
+		wantErr bool
+		want    string // Hash expected on the blocklist afterwards
+	}{
+		{name: "file", file: hashA + "\n", want: hashA},
+		{name: "missing file keeps previous", wantErr: true, want: previous},
+		{name: "URL", status: http.StatusOK, body: hashA + "\n", want: hashA},
+		{name: "URL error keeps previous", status: http.StatusInternalServerError, wantErr: true, want: previous},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t)
+			blockedHashes = map[string]bool{previous: true}
+			config.HashBlocklist.Path = filepath.Join(dir, "blocklist.txt")
+			if tt.file != "" {
+				os.WriteFile(config.HashBlocklist.Path, []byte(tt.file), 0644)
+			}
+			if tt.status != 0 {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+				}))
+				t.Cleanup(server.Close)
+				config.HashBlocklist.URL = server.URL
+			}
+
+			err := loadHashBlocklist()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadHashBlocklist() = %v, want error %v", err, tt.wantErr)
+			}
+			if !isBlockedHash(tt.want) {
+				t.Errorf("%s not on the blocklist", tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageBlockedHash(t *testing.T) {
+	content := []byte("data")
+	sum := sha256.Sum256(content)
+	tests := []struct {
+		name      string
+		blocked   bool
+		wantSaved bool
+	}{
+		{name: "blocked content", blocked: true},
+		{name: "other content", wantSaved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			if tt.blocked {
+				blockedHashes[hex.EncodeToString(sum[:])] = true
+			}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", content)
+
+			handleFileMessage(fks, documentMessage("file-1", "new.txt", "/docs", len(content)))
+
+			if got := fileExists(filepath.Join(dir, "docs", "new.txt")); got != tt.wantSaved {
+				t.Errorf("saved = %v, want %v, replies %q", got, tt.wantSaved, fks.texts())
+			}
+			if got := fks.sentContaining("blocklist"); got == tt.wantSaved {
+				t.Errorf("blocklist reply = %v, replies %q", got, fks.texts())
+			}
+			if tt.blocked && len(metadata.Records()) > 0 {
+				t.Errorf("blocked file recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data prefixes of inline keyboard buttons
+const (
+	callbackCommand    = "cmd:"        // Run command without arguments
+	callbackConfirm    = "confirm:"    // Answer save confirmation
+	callbackSetDefault = "setdefault:" // Set default category
+	callbackSettings   = "settings:"   // Change a preference from the /settings message
+)
+
+const maxCallbackDataLength = 64 // Telegram limit for button callback data
+
+// Build welcome keyboard with quick actions
+func welcomeKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Categories", callbackCommand+"categories"),
+			tgbotapi.NewInlineKeyboardButtonData("Stats", callbackCommand+"trends"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Set default category", callbackCommand+"setdefault"),
+		),
+	)
+}
+
+// Build keyboard with a button per category setting it as default
+func setDefaultKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, cat := range getCategories() {
+		data := callbackSetDefault + cat.Name
+		if len(data) > maxCallbackDataLength {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(categoryCommand(cat.Name), data)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// Handle inline keyboard button presses
+func handleCallbackQuery(bot Sender, query *tgbotapi.CallbackQuery) {
+	// Stop the loading indicator on the button
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		log.Printf("Error answering callback query: %v", err)
+	}
+
+	if query.Message == nil {
+		return
+	}
+
+	// Run handlers as if the user sent the command in the same chat
+	message := *query.Message
+	message.From = query.From
+
+	if strings.HasPrefix(query.Data, callbackCommand) && !checkCommandCooldown(bot, &message, strings.TrimPrefix(query.Data, callbackCommand)) {
+		return
+	}
+
+	switch {
+	case query.Data == callbackCommand+"categories":
+		sendCategoriesMessage(bot, &message)
+	case query.Data == callbackCommand+"trends":
+		sendTrendsMessage(bot, &message)
+	case query.Data == callbackCommand+"setdefault":
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Choose your default category:")
+		msg.ReplyMarkup = setDefaultKeyboard()
+		bot.Send(msg)
+	case strings.HasPrefix(query.Data, callbackSettings):
+		handleSettingsCallback(bot, &message, strings.TrimPrefix(query.Data, callbackSettings))
+	case strings.HasPrefix(query.Data, callbackConfirm):
+		handleConfirmCallback(bot, query, strings.TrimPrefix(query.Data, callbackConfirm))
+	case strings.HasPrefix(query.Data, callbackSetDefault):
+		handleSetDefaultCommand(bot, &message, strings.TrimPrefix(query.Data, callbackSetDefault))
+	default:
+		log.Printf("Unknown callback data %q from user %d", query.Data, query.From.ID)
+	}
+}
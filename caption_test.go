@@ -0,0 +1,195 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCaptionMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		wantText string
+		wantTags map[string]string
+	}{
+		{name: "empty", caption: "", wantText: ""},
+		{name: "text only", caption: "/docs report", wantText: "/docs report"},
+		{name: "tags after text", caption: "/docs report year=2024 author=alice", wantText: "/docs report", wantTags: map[string]string{"year": "2024", "author": "alice"}},
+		{name: "tags between words", caption: "/docs year=2024 report", wantText: "/docs report", wantTags: map[string]string{"year": "2024"}},
+		{name: "quoted value", caption: `/docs report title="Annual report 2024"`, wantText: "/docs report", wantTags: map[string]string{"title": "Annual report 2024"}},
+		{name: "unclosed quote", caption: `/docs title="Annual report`, wantText: "/docs", wantTags: map[string]string{"title": "Annual report"}},
+		{name: "key lowercased", caption: "Year=2024", wantText: "", wantTags: map[string]string{"year": "2024"}},
+		{name: "empty value", caption: "year= report", wantText: "report", wantTags: map[string]string{"year": ""}},
+		{name: "invalid key stays text", caption: "1x=2 a+b=c", wantText: "1x=2 a+b=c"},
+		{name: "equals inside word", caption: "=value", wantText: "=value"},
+		{name: "extra spaces", caption: "  /docs   report   year=2024 ", wantText: "/docs report", wantTags: map[string]string{"year": "2024"}},
+		{name: "later tag wins", caption: "year=2023 year=2024", wantText: "", wantTags: map[string]string{"year": "2024"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, tags := parseCaptionMetadata(tt.caption)
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if !maps.Equal(tags, tt.wantTags) {
+				t.Errorf("tags = %v, want %v", tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageStoresCaptionTags(t *testing.T) {
+	setupTestBot(t, "docs")
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	handleFileMessage(fks, documentMessage("file-1", "draft.pdf", `/docs report year=2024 title="Annual report"`, 4))
+
+	records := metadata.Records()
+	if len(records) != 1 {
+		t.Fatalf("records = %+v, replies %q", records, fks.texts())
+	}
+	if records[0].Name != "report.pdf" {
+		t.Errorf("name = %q, want report.pdf", records[0].Name)
+	}
+	if want := map[string]string{"year": "2024", "title": "Annual report"}; !maps.Equal(records[0].Tags, want) {
+		t.Errorf("tags = %v, want %v", records[0].Tags, want)
+	}
+}
+
+func TestStripBotMention(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		caption  string
+		want     string
+	}{
+		{name: "unknown bot name", username: "", caption: "@filebot image a.jpg", want: "@filebot image a.jpg"},
+		{name: "no mention", username: "filebot", caption: "/image a.jpg", want: "/image a.jpg"},
+		{name: "mention before category", username: "filebot", caption: "@filebot image a.jpg", want: "/image a.jpg"},
+		{name: "mention before command", username: "filebot", caption: "@filebot /image a.jpg", want: "/image a.jpg"},
+		{name: "mention case insensitive", username: "FileBot", caption: "@filebot image", want: "/image"},
+		{name: "mention only", username: "filebot", caption: "@filebot", want: ""},
+		{name: "extra spaces after mention", username: "filebot", caption: "@filebot   image", want: "/image"},
+		{name: "command with bot name", username: "filebot", caption: "/image@filebot a.jpg", want: "/image a.jpg"},
+		{name: "command with bot name only", username: "filebot", caption: "/image@filebot", want: "/image"},
+		{name: "command for another bot", username: "filebot", caption: "/image@otherbot a.jpg", want: "/image@otherbot a.jpg"},
+		{name: "mention of another bot", username: "filebot", caption: "@otherbot image", want: "@otherbot image"},
+		{name: "mention later in caption", username: "filebot", caption: "/image @filebot", want: "/image @filebot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			botUsername = tt.username
+			t.Cleanup(func() { botUsername = "" })
+
+			if got := stripBotMention(tt.caption); got != tt.want {
+				t.Errorf("stripBotMention(%q) = %q, want %q", tt.caption, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtraCaptionCategories(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		caption    string
+		wantPaths  []string // Files expected afterwards, relative to the test directory
+		wantReject bool
+	}{
+		{name: "default keeps tokens in name", caption: "/docs /images trip", wantPaths: []string{"docs/_images trip.jpg"}},
+		{name: "keep", mode: extraCategoriesKeep, caption: "/docs /images trip", wantPaths: []string{"docs/_images trip.jpg"}},
+		{name: "strip", mode: extraCategoriesStrip, caption: "/docs /images trip", wantPaths: []string{"docs/trip.jpg"}},
+		{name: "strip by index", mode: extraCategoriesStrip, caption: "/docs /2 trip", wantPaths: []string{"docs/trip.jpg"}},
+		{name: "strip leaves unknown slash token", mode: extraCategoriesStrip, caption: "/docs /music trip", wantPaths: []string{"docs/_music trip.jpg"}},
+		{name: "reject", mode: extraCategoriesReject, caption: "/docs /images trip", wantReject: true},
+		{name: "reject single category", mode: extraCategoriesReject, caption: "/docs trip", wantPaths: []string{"docs/trip.jpg"}},
+		{name: "copy", mode: extraCategoriesCopy, caption: "/docs /images trip", wantPaths: []string{"docs/trip.jpg", "images/trip.jpg"}},
+		{name: "copy ignores first category repeated", mode: extraCategoriesCopy, caption: "/docs /docs trip", wantPaths: []string{"docs/trip.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "images")
+			config.ExtraCaptionCategories = tt.mode
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "photo.jpg", tt.caption, 4))
+
+			for _, path := range tt.wantPaths {
+				if !fileExists(filepath.Join(dir, path)) {
+					t.Errorf("%s missing, replies %q", path, fks.texts())
+				}
+			}
+			if got := fks.sentContaining("Please use a single category"); got != tt.wantReject {
+				t.Errorf("rejected = %v, want %v, replies %q", got, tt.wantReject, fks.texts())
+			}
+			if tt.wantReject && len(metadata.Records()) > 0 {
+				t.Errorf("rejected file was saved")
+			}
+		})
+	}
+}
+
+func TestCapCaptionWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxWords int
+		parts    []string
+		wantName string
+		wantRest string
+	}{
+		{name: "unlimited", parts: []string{"quarterly", "report", "draft"}, wantName: "quarterly report draft"},
+		{name: "under limit", maxWords: 5, parts: []string{"quarterly", "report"}, wantName: "quarterly report"},
+		{name: "at limit", maxWords: 2, parts: []string{"quarterly", "report"}, wantName: "quarterly report"},
+		{name: "over limit", maxWords: 2, parts: []string{"quarterly", "report", "final", "draft"}, wantName: "quarterly report", wantRest: "final draft"},
+		{name: "empty parts not counted", maxWords: 2, parts: []string{"quarterly", "", "report", "draft"}, wantName: "quarterly report", wantRest: "draft"},
+		{name: "empty parts kept under limit", maxWords: 2, parts: []string{"quarterly", "", "report"}, wantName: "quarterly  report"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.CaptionMaxWords = tt.maxWords
+
+			name, rest := capCaptionWords(tt.parts)
+			if name != tt.wantName || rest != tt.wantRest {
+				t.Errorf("capCaptionWords() = %q, %q, want %q, %q", name, rest, tt.wantName, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageCaptionMaxWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		note     bool // caption_note
+		caption  string
+		wantName string
+		wantNote string
+	}{
+		{name: "rest dropped", caption: "/docs quarterly report final draft", wantName: "quarterly report.txt"},
+		{name: "rest kept as note", note: true, caption: "/docs quarterly report final draft", wantName: "quarterly report.txt", wantNote: "final draft"},
+		{name: "note tag wins", note: true, caption: "/docs quarterly report final draft note=mine", wantName: "quarterly report.txt", wantNote: "mine"},
+		{name: "short caption", note: true, caption: "/docs report", wantName: "report.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.CaptionMaxWords = 2
+			config.CaptionNote = tt.note
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "upload.txt", tt.caption, 4))
+
+			record := metadata.FindByPath(filepath.Join(dir, "docs", tt.wantName))
+			if record.Path == "" {
+				t.Fatalf("%s not saved, replies %q", tt.wantName, fks.texts())
+			}
+			if got := record.Tags["note"]; got != tt.wantNote {
+				t.Errorf("note = %q, want %q", got, tt.wantNote)
+			}
+		})
+	}
+}
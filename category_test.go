@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestCategoryLocation(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantRoot   string
+		wantPrefix string
+	}{
+		{path: "./files/docs", wantRoot: "./files/docs"},
+		{path: "./files/photos/{year}/{month}", wantRoot: "files/photos"},
+		{path: "./files/{category}/{year}", wantRoot: "files/shots"},
+		{path: "./files/img_{year}", wantRoot: "files", wantPrefix: "img_"},
+		{path: "./files/{year}-{month}", wantRoot: "files"},
+		{path: "{year}", wantRoot: "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			setupTestBot(t)
+			categoryMap["shots"] = tt.path
+
+			root, prefix := categoryLocation("shots")
+			if root != tt.wantRoot || prefix != tt.wantPrefix {
+				t.Errorf("categoryLocation = %q, %q, want %q, %q", root, prefix, tt.wantRoot, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+// Create empty files at slash-separated paths under dir
+func touchFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCategoryFilesWithPlaceholderInFolderName(t *testing.T) {
+	dir := setupTestBot(t)
+	categoryMap["images"] = filepath.Join(dir, "img_{year}")
+	categoryMap["docs"] = filepath.Join(dir, "docs")
+	touchFiles(t, dir, "img_2025/a.jpg", "img_2026/b.jpg", "docs/report.pdf", "notes.txt")
+
+	names := categoryFilenames("images")
+	if len(names) != 2 || !names["a.jpg"] || !names["b.jpg"] {
+		t.Errorf("categoryFilenames = %v, want a.jpg and b.jpg", names)
+	}
+
+	files, err := listCategoryFiles("images", categoryRoot("images"))
+	if err != nil {
+		t.Fatalf("listCategoryFiles: %v", err)
+	}
+	var listed []string
+	for _, file := range files {
+		listed = append(listed, file.Name)
+	}
+	if len(listed) != 2 || listed[0] != "img_2025/a.jpg" || listed[1] != "img_2026/b.jpg" {
+		t.Errorf("listCategoryFiles = %v", listed)
+	}
+
+	for name, wantErr := range map[string]bool{"img_2025/a.jpg": false, "docs/report.pdf": true, "notes.txt": true} {
+		if _, err := resolveCategoryFile("images", name); (err != nil) != wantErr {
+			t.Errorf("resolveCategoryFile(%q) error = %v, want error %v", name, err, wantErr)
+		}
+	}
+}
+
+func TestSendCategoriesMessageOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories []string
+		want       []string // Listed lines in order
+	}{
+		{name: "config order, not alphabetical", categories: []string{"video", "audio", "books"}, want: []string{"1. /video", "2. /audio", "3. /books"}},
+		{name: "single category", categories: []string{"docs"}, want: []string{"1. /docs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, tt.categories...)
+			fks := newFakeSender(t)
+
+			sendCategoriesMessage(fks, commandMessage("/categories"))
+
+			text, last := fks.lastText(), -1
+			for _, line := range tt.want {
+				at := strings.Index(text, line)
+				if at < 0 || at < last {
+					t.Errorf("%q missing or out of order in %q", line, text)
+				}
+				last = at
+			}
+		})
+	}
+}
+
+func TestCategoryOrderAfterImport(t *testing.T) {
+	dir := setupTestBot(t, "video", "audio")
+	config.Admins = []int64{1}
+	fks := newFakeSender(t)
+	fks.addFile("import", []byte(fmt.Sprintf("categories:\n  - name: books\n    path: %s\n  - name: audio\n    path: %s\n",
+		filepath.Join(dir, "books"), filepath.Join(dir, "audio2"))))
+
+	importMessage := commandMessage("/importcategories")
+	importMessage.ReplyToMessage = &tgbotapi.Message{Document: &tgbotapi.Document{FileID: "import", FileName: "categories.yml"}}
+	handleImportCategoriesCommand(fks, importMessage)
+
+	// Updated categories keep their place, new ones are added at the end
+	if got, want := categoryNames(), "video, audio, books"; got != want {
+		t.Errorf("categories = %q, want %q", got, want)
+	}
+}
+
+func TestResolveCategorySelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		selector string
+		want     string
+		wantOK   bool
+	}{
+		{name: "name", selector: "audio", want: "audio", wantOK: true},
+		{name: "first index", selector: "1", want: "video", wantOK: true},
+		{name: "last index", selector: "3", want: "cat_books", wantOK: true},
+		{name: "index zero", selector: "0"},
+		{name: "index past the end", selector: "4"},
+		{name: "negative index", selector: "-1"},
+		{name: "unknown name", selector: "music"},
+		{name: "empty", selector: ""},
+		{name: "full name with prefix", prefix: "cat_", selector: "cat_books", want: "cat_books", wantOK: true},
+		{name: "name without prefix", prefix: "cat_", selector: "books", want: "cat_books", wantOK: true},
+		{name: "prefix not configured", selector: "books"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "video", "audio", "cat_books")
+			config.CommandPrefix = tt.prefix
+
+			got, ok := resolveCategorySelector(tt.selector)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("resolveCategorySelector(%q) = %q, %v, want %q, %v", tt.selector, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageCategoryIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		wantPath string // Empty when the file is rejected
+	}{
+		{name: "index as category", caption: "/2", wantPath: "audio/song.mp3"},
+		{name: "index with name", caption: "/2 tune", wantPath: "audio/tune.mp3"},
+		{name: "bare index", caption: "2", wantPath: "audio/song.mp3"},
+		{name: "index out of range", caption: "/9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "video", "audio")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "song.mp3", tt.caption, 4))
+
+			if tt.wantPath == "" {
+				if len(metadata.Records()) != 0 {
+					t.Errorf("file saved, replies %q", fks.texts())
+				}
+				return
+			}
+			if !fileExists(filepath.Join(dir, tt.wantPath)) {
+				t.Errorf("%s not saved, replies %q", tt.wantPath, fks.texts())
+			}
+		})
+	}
+}
+
+func TestDetermineCategory(t *testing.T) {
+	document := func(name, mimeType string) *tgbotapi.Message {
+		return &tgbotapi.Message{Document: &tgbotapi.Document{FileName: name, MimeType: mimeType}}
+	}
+	tests := []struct {
+		name         string
+		routeMedia   bool
+		extOverrides bool
+		message      *tgbotapi.Message
+		want         string
+	}{
+		{name: "photo", message: &tgbotapi.Message{Photo: []tgbotapi.PhotoSize{{FileID: "p"}}}, want: "image"},
+		{name: "video", message: &tgbotapi.Message{Video: &tgbotapi.Video{}}, want: "video"},
+		{name: "audio", message: &tgbotapi.Message{Audio: &tgbotapi.Audio{}}, want: "audio"},
+		{name: "voice note", message: &tgbotapi.Message{Voice: &tgbotapi.Voice{}}, want: "audio"},
+		{name: "document", message: document("report.pdf", "application/pdf"), want: "document"},
+		{name: "image document not routed by default", message: document("scan.png", "image/png"), want: "document"},
+		{name: "image document by MIME type", routeMedia: true, message: document("scan.png", "image/png"), want: "image"},
+		{name: "video document by MIME type", routeMedia: true, message: document("clip", "video/mp4"), want: "video"},
+		{name: "audio document by MIME type", routeMedia: true, message: document("song", "audio/mpeg"), want: "audio"},
+		{name: "other document with MIME routing", routeMedia: true, message: document("report.pdf", "application/pdf"), want: "document"},
+		{name: "mislabeled video by extension", extOverrides: true, message: document("clip.MP4", "application/octet-stream"), want: "video"},
+		{name: "extension wins over MIME type", routeMedia: true, extOverrides: true, message: document("song.mp3", "video/mp4"), want: "audio"},
+		{name: "unknown extension falls back to MIME type", routeMedia: true, extOverrides: true, message: document("scan.xyz", "image/png"), want: "image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.RouteMediaDocuments = tt.routeMedia
+			config.ExtensionOverridesType = tt.extOverrides
+
+			if got := determineCategory(tt.message); got != tt.want {
+				t.Errorf("determineCategory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		action func(fks *fakeSender)
+		check  func(t *testing.T, fks *fakeSender, dir string)
+	}{
+		{
+			name:   "caption without prefix",
+			prefix: "cat_",
+			action: func(fks *fakeSender) {
+				handleFileMessage(fks, documentMessage("file-1", "new.txt", "/books", 4))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if !fileExists(filepath.Join(dir, "cat_books", "new.txt")) {
+					t.Errorf("file not saved to cat_books, replies %q", fks.texts())
+				}
+			},
+		},
+		{
+			name:   "set default without prefix",
+			prefix: "cat_",
+			action: func(fks *fakeSender) {
+				handleCommand(fks, commandMessage("/setdefault books"))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if got := userDefaults[1]; got != "cat_books" {
+					t.Errorf("user default = %q, want cat_books", got)
+				}
+			},
+		},
+		{
+			name:   "set chat default without prefix",
+			prefix: "cat_",
+			action: func(fks *fakeSender) {
+				handleCommand(fks, commandMessage("/setchatdefault books"))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if got := chatDefaults[1]; got != "cat_books" {
+					t.Errorf("chat default = %q, want cat_books", got)
+				}
+			},
+		},
+		{
+			name:   "listing hides prefix",
+			prefix: "cat_",
+			action: func(fks *fakeSender) {
+				handleCommand(fks, commandMessage("/categories"))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if !fks.sentContaining("2. /books - ") || fks.sentContaining("/cat_books -") {
+					t.Errorf("listing = %q, want names without prefix", fks.lastText())
+				}
+			},
+		},
+		{
+			name:   "unknown category lists names without prefix",
+			prefix: "cat_",
+			action: func(fks *fakeSender) {
+				handleCommand(fks, commandMessage("/setdefault music"))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if !fks.sentContaining("docs, books") {
+					t.Errorf("reply = %q, want names without prefix", fks.lastText())
+				}
+			},
+		},
+		{
+			name: "prefix not configured",
+			action: func(fks *fakeSender) {
+				handleFileMessage(fks, documentMessage("file-1", "new.txt", "/books", 4))
+			},
+			check: func(t *testing.T, fks *fakeSender, dir string) {
+				if fileExists(filepath.Join(dir, "cat_books", "new.txt")) {
+					t.Errorf("file saved to cat_books without a prefix configured")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "cat_books")
+			config.CommandPrefix = tt.prefix
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			tt.action(fks)
+			tt.check(t, fks, dir)
+		})
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr string // Empty when the path is valid
+	}{
+		{path: "./files/docs"},
+		{path: "./files/{category}"},
+		{path: "./files/{category}/{year}/{month}"},
+		{path: "./files/{year}/{month}"},
+		{path: "./files/img_{year}"},
+		{path: "{category}/{year}"},
+		{path: "./files/{year}/{category}", wantErr: "{category} must come before"},
+		{path: "./files/{category}_{day}/{month}/{category}"},
+		{path: "./files/{month}-{category}", wantErr: "{category} must come before"},
+		{path: "./files/{week}", wantErr: "unknown placeholder {week}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := validatePathTemplate(tt.path)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validatePathTemplate() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validatePathTemplate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCategoryTemplateSharedRootRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		load func(dir string) error
+	}{
+		{
+			name: "config file",
+			load: func(dir string) error {
+				path := filepath.Join(dir, "config.yml")
+				os.WriteFile(path, []byte("categories:\n  - name: docs\n    path: "+dir+"/{year}/{category}\n"), 0644)
+				return loadConfig(path)
+			},
+		},
+		{
+			name: "CATEGORIES variable",
+			load: func(dir string) error {
+				_, err := parseCategoriesEnv("docs=" + dir + "/{year}/{category}")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t)
+			if err := tt.load(dir); err == nil || !strings.Contains(err.Error(), "{category} must come before") {
+				t.Errorf("error = %v, want template rejected", err)
+			}
+		})
+	}
+}
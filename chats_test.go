@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHandleUpdateAllowedChats(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []int64
+		leave     bool
+		chat      tgbotapi.Chat
+		wantReply bool
+		wantLeave bool
+	}{
+		{name: "no allowlist", chat: tgbotapi.Chat{ID: 5, Type: "private"}, wantReply: true},
+		{name: "allowed private chat", allowed: []int64{5}, chat: tgbotapi.Chat{ID: 5, Type: "private"}, wantReply: true},
+		{name: "allowed group", allowed: []int64{-100}, chat: tgbotapi.Chat{ID: -100, Type: "supergroup"}, wantReply: true},
+		{name: "other private chat", allowed: []int64{-100}, chat: tgbotapi.Chat{ID: 5, Type: "private"}},
+		{name: "other group stays", allowed: []int64{-100}, chat: tgbotapi.Chat{ID: -200, Type: "group"}},
+		{name: "other group is left", allowed: []int64{-100}, leave: true, chat: tgbotapi.Chat{ID: -200, Type: "group"}, wantLeave: true},
+		{name: "private chat is never left", allowed: []int64{-100}, leave: true, chat: tgbotapi.Chat{ID: 5, Type: "private"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.AllowedChats = tt.allowed
+			config.LeaveOtherChats = tt.leave
+			fks := newFakeSender(t)
+
+			message := commandMessage("/categories")
+			message.Chat = &tt.chat
+			handleUpdate(fks, tgbotapi.Update{Message: message})
+
+			if got := len(fks.texts()) > 0; got != tt.wantReply {
+				t.Errorf("replied = %v, want %v", got, tt.wantReply)
+			}
+			fks.mu.Lock()
+			defer fks.mu.Unlock()
+			var left bool
+			for _, request := range fks.requests {
+				if leave, ok := request.(tgbotapi.LeaveChatConfig); ok && leave.ChatID == tt.chat.ID {
+					left = true
+				}
+			}
+			if left != tt.wantLeave {
+				t.Errorf("left chat = %v, want %v", left, tt.wantLeave)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateAllowedChatsCallback(t *testing.T) {
+	setupTestBot(t, "docs")
+	config.AllowedChats = []int64{-100}
+	fks := newFakeSender(t)
+
+	handleUpdate(fks, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "q1",
+		From:    &tgbotapi.User{ID: 1},
+		Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: 5, Type: "private"}},
+		Data:    callbackConfirm + "yes:5:1",
+	}})
+
+	if texts := fks.texts(); len(texts) != 0 {
+		t.Errorf("callback from another chat answered with %q", texts)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBotToken(t *testing.T) {
+	secret := strings.Repeat("A", 30) + "b1_-Z"
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // Empty when the token is valid
+	}{
+		{name: "valid", token: "123456:" + secret},
+		{name: "empty", token: "", wantErr: "no colon"},
+		{name: "trailing newline", token: "123456:" + secret + "\n", wantErr: "whitespace"},
+		{name: "leading space", token: " 123456:" + secret, wantErr: "whitespace"},
+		{name: "no colon", token: "123456" + secret, wantErr: "no colon"},
+		{name: "missing bot ID", token: ":" + secret, wantErr: "must be numeric"},
+		{name: "letters in bot ID", token: "12a456:" + secret, wantErr: "must be numeric"},
+		{name: "short secret", token: "123456:" + secret[:20], wantErr: "has 20 characters"},
+		{name: "long secret", token: "123456:" + secret + "x", wantErr: "has 36 characters"},
+		{name: "invalid secret character", token: "123456:" + secret[:34] + "!", wantErr: "may only contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBotToken(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBotToken() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateBotToken() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if strings.Contains(err.Error(), secret[:20]) {
+				t.Errorf("error %q reveals the token", err)
+			}
+		})
+	}
+}
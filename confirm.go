@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// pendingSave represents a file awaiting the sender's confirmation before it is saved
+type pendingSave struct {
+	Message   *tgbotapi.Message
+	FileID    string
+	Category  string
+	Filename  string
+	Generated bool // Filename is the name Telegram gave the file, the picked category may rename it
+	Tags      map[string]string
+	Copies    []string // Further categories receiving the file
+	PromptID  int      // Message with the Yes/No buttons
+}
+
+var pendingSaves = newPendingStore[string, pendingSave]() // Map of confirmation key to file awaiting an answer
+
+// Get key identifying the confirmation of a file message
+func pendingSaveKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// Ask sender whether to save file to category, saving only after Yes
+func askSaveConfirmation(bot Sender, message *tgbotapi.Message, fileID, category, filename string, tags map[string]string, copies []string) {
+	expirePendingSaves(bot, time.Now())
+
+	key := pendingSaveKey(message.Chat.ID, message.MessageID)
+	question := fmt.Sprintf("Save '%s' to category '%s'?", filename, category)
+	if len(copies) > 0 {
+		question = fmt.Sprintf("Save '%s' to categories '%s', %s?", filename, category, strings.Join(copies, ", "))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, question)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Yes", callbackConfirm+"yes:"+key),
+			tgbotapi.NewInlineKeyboardButtonData("No", callbackConfirm+"no:"+key),
+		),
+	)
+	prompt, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("Error asking save confirmation: %v", err)
+		return
+	}
+
+	pendingSaves.Put(key, pendingSave{
+		Message:  message,
+		FileID:   fileID,
+		Category: category,
+		Filename: filename,
+		Tags:     tags,
+		Copies:   copies,
+		PromptID: prompt.MessageID,
+	}, pendingTimeout(config.ConfirmTimeout))
+}
+
+// Ask sender which category to save file to, offering a button per writable category
+func askSaveCategory(bot Sender, message *tgbotapi.Message, fileID, filename string, generated bool, tags map[string]string) {
+	expirePendingSaves(bot, time.Now())
+
+	// Buttons carry the category index to stay within the callback data limit
+	key := pendingSaveKey(message.Chat.ID, message.MessageID)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, cat := range getCategories() {
+		if cat.ReadOnly {
+			continue
+		}
+		data := callbackConfirm + "c" + strconv.Itoa(i) + ":" + key
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(categoryCommand(cat.Name), data)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Don't save", callbackConfirm+"no:"+key)))
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Which category should '%s' be saved to?", filename))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	prompt, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("Error asking for category: %v", err)
+		return
+	}
+
+	pendingSaves.Put(key, pendingSave{
+		Message:   message,
+		FileID:    fileID,
+		Filename:  filename,
+		Generated: generated,
+		Tags:      tags,
+		PromptID:  prompt.MessageID,
+	}, pendingTimeout(config.ConfirmTimeout))
+}
+
+// Handle answer to a save confirmation, data is "<yes|no|c<category index>>:<chat ID>:<message ID>"
+func handleConfirmCallback(bot Sender, query *tgbotapi.CallbackQuery, data string) {
+	answer, key, _ := strings.Cut(data, ":")
+
+	pending, ok := pendingSaves.Peek(key)
+	if !ok {
+		pendingSaves.Delete(key)
+		if query.Message != nil {
+			edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, "This confirmation has expired, file not saved.")
+			bot.Send(edit)
+		}
+		return
+	}
+
+	// Only the sender of the file decides
+	if pending.Message.From == nil || pending.Message.From.ID != query.From.ID {
+		log.Printf("Ignoring confirmation of %s by user %d", key, query.From.ID)
+		return
+	}
+	pendingSaves.Delete(key)
+
+	// Category picked for a file saved without one
+	if index, ok := strings.CutPrefix(answer, "c"); ok {
+		categories := getCategories()
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(categories) {
+			log.Printf("Invalid category choice %q for %s", answer, key)
+			return
+		}
+		pending.Category = categories[i].Name
+
+		// Name the file as if it had been sent with the picked category
+		if pending.Generated {
+			_, originalFilename := getFileInfo(pending.Message)
+			pending.Filename = chooseFilename(pending.Message, pending.Category, "", originalFilename)
+			if filepath.Ext(pending.Filename) == "" && config.NoExtension != noExtensionKeep && config.NoExtension != noExtensionRoute {
+				pending.Filename += extensionForMimeType(getFileMimeType(pending.Message))
+			}
+		}
+		if err := validateFilename(pending.Filename, pending.Category); err != nil {
+			log.Printf("Rejected filename %q from user %d: %v", pending.Filename, pending.Message.From.ID, err)
+			edit := tgbotapi.NewEditMessageText(pending.Message.Chat.ID, pending.PromptID, fmt.Sprintf("Invalid filename: %v. Please send the file again with another name.", err))
+			bot.Send(edit)
+			noteRejection(bot, pending.Message)
+			return
+		}
+
+		// Categories may have changed since the buttons were sent, files offered a choice are never named
+		if !checkCategoryAccepts(bot, pending.Message, pending.Category, false) {
+			edit := tgbotapi.NewEditMessageText(pending.Message.Chat.ID, pending.PromptID, fmt.Sprintf("'%s' was not saved to '%s'.", pending.Filename, pending.Category))
+			bot.Send(edit)
+			noteRejection(bot, pending.Message)
+			return
+		}
+		answer = "yes"
+	}
+
+	if answer != "yes" {
+		edit := tgbotapi.NewEditMessageText(pending.Message.Chat.ID, pending.PromptID, fmt.Sprintf("Cancelled, '%s' was not saved.", pending.Filename))
+		bot.Send(edit)
+		return
+	}
+
+	// Save reports progress with its own status message
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(pending.Message.Chat.ID, pending.PromptID)); err != nil {
+		log.Printf("Error deleting confirmation message: %v", err)
+	}
+	submitSave(bot, pending.Message, pending.FileID, pending.Category, pending.Filename, pending.Tags, pending.Copies)
+}
+
+// Drop confirmations nobody answered in time, telling the sender the file was not saved
+func expirePendingSaves(bot Sender, now time.Time) {
+	for _, pending := range pendingSaves.Sweep(now) {
+		edit := tgbotapi.NewEditMessageText(pending.Message.Chat.ID, pending.PromptID, fmt.Sprintf("No answer, '%s' was not saved.", pending.Filename))
+		bot.Send(edit)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Add saved file to further categories, returning a note for the success message
+func saveCategoryCopies(bot Sender, message *tgbotapi.Message, upload FileRecord, filename string, saved savedFile, copies []string) string {
+	// Content layout already keeps a single file per content
+	if config.StorageLayout == storageLayoutContent {
+		return fmt.Sprintf("\n\nNote: not added to %s, content storage keeps one copy of each file.", strings.Join(copies, ", "))
+	}
+
+	var added, failed []string
+	for _, category := range copies {
+		path, err := linkOrCopyFile(saved.Path, resolveStoragePath(category, message), filepath.Base(saved.Path), category)
+		if err != nil {
+			log.Printf("Error adding %s to category %s: %v", saved.Path, category, err)
+			failed = append(failed, category)
+			continue
+		}
+
+		record := upload
+		record.Category = category
+		recordSavedFile(record, filename, savedFile{Path: path, Size: saved.Size, SHA256: saved.SHA256})
+		runCategoryHook(bot, message, category, path)
+		added = append(added, category)
+		log.Printf("File %s also saved to %s", saved.Path, path)
+	}
+
+	note := ""
+	if len(added) > 0 {
+		note += fmt.Sprintf("\n\nAlso saved to: %s", strings.Join(added, ", "))
+	}
+	if len(failed) > 0 {
+		note += fmt.Sprintf("\n\nCould not save to: %s", strings.Join(failed, ", "))
+	}
+	return note
+}
+
+// Hard link file into storagePath under a unique name, copying it when linking is not possible
+func linkOrCopyFile(src, storagePath, filename, category string) (string, error) {
+	if err := os.MkdirAll(storagePath, 0755); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+
+	unlockNames := lockStorageNames()
+	defer unlockNames()
+	dst := buildFilePath(storagePath, filename, category)
+	if err := os.Link(src, dst); err == nil {
+		return dst, nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return "", fmt.Errorf("error copying file: %w", err)
+	}
+	return dst, nil
+}
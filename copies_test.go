@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveCategoryCopies(t *testing.T) {
+	tests := []struct {
+		name        string
+		caption     string
+		readOnly    string                    // Category made read-only
+		backup      func(cat *CategoryConfig) // Changes settings of the backup category
+		layout      string
+		setup       func(t *testing.T, dir string)
+		wantPaths   []string // Files expected afterwards, relative to the test directory
+		wantRecords int      // Records of the upload
+		wantReply   string
+	}{
+		{name: "one extra category", caption: "/docs /backup trip", wantPaths: []string{"docs/trip.jpg", "backup/trip.jpg"}, wantRecords: 2, wantReply: "Also saved to: backup"},
+		{name: "two extra categories", caption: "/docs /backup /images trip", wantPaths: []string{"docs/trip.jpg", "backup/trip.jpg", "images/trip.jpg"}, wantRecords: 3, wantReply: "Also saved to: backup, images"},
+		{name: "extra category named twice", caption: "/docs /backup /backup trip", wantPaths: []string{"docs/trip.jpg", "backup/trip.jpg"}, wantRecords: 2, wantReply: "Also saved to: backup"},
+		{
+			name:    "name taken in extra category",
+			caption: "/docs /backup trip",
+			setup: func(t *testing.T, dir string) {
+				addSavedFile(t, filepath.Join(dir, "backup", "trip.jpg"), "backup", 2, 20)
+			},
+			wantPaths:   []string{"docs/trip.jpg", "backup/trip_1.jpg"},
+			wantRecords: 2,
+			wantReply:   "Also saved to: backup",
+		},
+		{name: "read-only extra category", caption: "/docs /backup trip", readOnly: "backup", wantReply: "is read-only"},
+		{name: "extra category size limit", caption: "/docs /backup trip", backup: func(cat *CategoryConfig) { cat.MaxFileSizeBytes = 2 }, wantReply: "File is too large"},
+		{name: "extra category requires caption", caption: "/docs /backup", backup: func(cat *CategoryConfig) { cat.RequireCaption = true }, wantReply: "need a name"},
+		{name: "content layout", caption: "/docs /backup trip", layout: storageLayoutContent, wantRecords: 1, wantReply: "Note: not added to backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "backup", "images")
+			config.ExtraCaptionCategories = extraCategoriesCopy
+			config.StorageLayout = tt.layout
+			for i := range config.Categories {
+				config.Categories[i].ReadOnly = config.Categories[i].Name == tt.readOnly
+				if config.Categories[i].Name == "backup" && tt.backup != nil {
+					tt.backup(&config.Categories[i])
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "photo.jpg", tt.caption, 4))
+
+			for _, path := range tt.wantPaths {
+				record := metadata.FindByPath(filepath.Join(dir, path))
+				if record.Path == "" || record.Category != filepath.Dir(path) {
+					t.Errorf("%s not recorded in its category: %+v", path, record)
+				}
+			}
+			if len(tt.wantPaths) > 1 {
+				first, _ := os.Stat(filepath.Join(dir, tt.wantPaths[0]))
+				for _, path := range tt.wantPaths[1:] {
+					if copied, err := os.Stat(filepath.Join(dir, path)); err != nil || !os.SameFile(first, copied) {
+						t.Errorf("%s is not a link to %s: %v", path, tt.wantPaths[0], err)
+					}
+				}
+			}
+			var uploaded int
+			for _, record := range metadata.Records() {
+				if record.UserID == 1 {
+					uploaded++
+				}
+			}
+			if uploaded != tt.wantRecords {
+				t.Errorf("%d records of the upload, want %d", uploaded, tt.wantRecords)
+			}
+			if !fks.sentContaining(tt.wantReply) {
+				t.Errorf("replies %q, want %q", fks.texts(), tt.wantReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Build a /delete command replying to the bot's confirmation messageID
+func deleteReply(messageID int) *tgbotapi.Message {
+	message := commandMessage("/delete")
+	message.ReplyToMessage = &tgbotapi.Message{MessageID: messageID, Chat: message.Chat}
+	return message
+}
+
+func TestHandleDeleteCommand(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	fks := newFakeSender(t)
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+
+	handleDeleteCommand(fks, deleteReply(10))
+
+	if fileExists(record.Path) {
+		t.Error("file still exists")
+	}
+	if len(metadata.Records()) != 0 {
+		t.Errorf("records = %+v, want none", metadata.Records())
+	}
+	if !fks.sentContaining("Deleted: report.txt") {
+		t.Errorf("replies %q", fks.texts())
+	}
+}
+
+func TestHandleDeleteCommandSharedBlob(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.StorageLayout = storageLayoutContent
+	fks := newFakeSender(t)
+
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	addSavedFile(t, blob, "docs", 1, 10)
+	theirs := addSavedFile(t, blob, "docs", 2, 11)
+
+	handleDeleteCommand(fks, deleteReply(10))
+
+	if !fileExists(blob) {
+		t.Error("blob used by another upload was removed")
+	}
+	records := metadata.Records()
+	if len(records) != 1 || !sameRecord(records[0], theirs) {
+		t.Errorf("records = %+v, want only the other user's", records)
+	}
+}
+
+func TestHandleDeleteCommandOtherUser(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	fks := newFakeSender(t)
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 2, 10)
+
+	handleDeleteCommand(fks, deleteReply(10))
+
+	if !fileExists(record.Path) {
+		t.Error("file of another user was deleted")
+	}
+	if !fks.sentContaining("only delete files you saved yourself") {
+		t.Errorf("replies %q", fks.texts())
+	}
+}
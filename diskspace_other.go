@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Free space is only reported on Unix systems
+func diskSpace(path string) (device uint64, free, total int64, err error) {
+	return 0, 0, 0, errors.New("disk space is not supported on this platform")
+}
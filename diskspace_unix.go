@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// Get device ID and free and total bytes of the filesystem holding path
+func diskSpace(path string) (device uint64, free, total int64, err error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, 0, err
+	}
+	return uint64(stat.Dev), int64(fs.Bavail) * int64(fs.Bsize), int64(fs.Blocks) * int64(fs.Bsize), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a partial download file holding content
+func partialFile(t *testing.T, content string) *os.File {
+	t.Helper()
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), ".partial-test.part"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// Serve body, answering range requests when ranges is set
+func contentServer(t *testing.T, body string, ranges bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var offset int
+		if ranges && r.Header.Get("Range") != "" {
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(body)-1, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write([]byte(body[offset:]))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Answer every request with status
+func statusServer(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Read current content of file
+func fileContent(t *testing.T, file *os.File) string {
+	t.Helper()
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestResumeDownload(t *testing.T) {
+	const body = "hello world"
+	sum := sha256.Sum256([]byte(body))
+	wantHash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		partial string
+		ranges  bool
+	}{
+		{name: "fresh download", partial: "", ranges: true},
+		{name: "resumed with range", partial: "hello", ranges: true},
+		{name: "restarted when range is ignored", partial: "hello", ranges: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			file := partialFile(t, tt.partial)
+
+			saved, err := resumeDownload(file, contentServer(t, body, tt.ranges).URL, "docs")
+			if err != nil {
+				t.Fatalf("resumeDownload: %v", err)
+			}
+			if got := fileContent(t, file); got != body {
+				t.Errorf("content = %q, want %q", got, body)
+			}
+			if saved.Size != int64(len(body)) || saved.SHA256 != wantHash {
+				t.Errorf("saved = %+v, want size %d and hash %s", saved, len(body), wantHash)
+			}
+		})
+	}
+}
+
+func TestResumeDownloadErrorStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		partial   string
+		status    int
+		transient bool
+	}{
+		{name: "not found", partial: "", status: http.StatusNotFound},
+		{name: "not found keeps partial", partial: "hello", status: http.StatusNotFound},
+		{name: "unavailable keeps partial", partial: "hello", status: http.StatusServiceUnavailable, transient: true},
+		{name: "rate limited keeps partial", partial: "hello", status: http.StatusTooManyRequests, transient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			file := partialFile(t, tt.partial)
+
+			_, err := resumeDownload(file, statusServer(t, tt.status).URL, "docs")
+
+			var statusErr *httpStatusError
+			if !errors.As(err, &statusErr) || statusErr.Code != tt.status {
+				t.Fatalf("error = %v, want status %d", err, tt.status)
+			}
+			if isTransientDownloadError(err) != tt.transient {
+				t.Errorf("transient = %v, want %v", !tt.transient, tt.transient)
+			}
+			if got := fileContent(t, file); got != tt.partial {
+				t.Errorf("partial content = %q, want %q", got, tt.partial)
+			}
+		})
+	}
+}
+
+func TestDownloadAndSaveFileNotFound(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	fks := newFakeSender(t)
+	fks.urls["gone"] = statusServer(t, http.StatusNotFound).URL
+
+	_, err := downloadAndSaveFile(fks, "gone", "docs", filepath.Join(dir, "docs"), "report.txt", 0, 1)
+	if !errors.Is(err, errDownloadFailed) {
+		t.Fatalf("error = %v, want download failure", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "docs"))
+	if len(entries) != 0 {
+		t.Errorf("files left in category: %v", entries)
+	}
+}
+
+func TestDownloadAndSaveFileUnavailableKeepsPartial(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	fks := newFakeSender(t)
+	fks.urls["busy"] = statusServer(t, http.StatusServiceUnavailable).URL
+
+	partial := partialDownloadPath("docs", "busy")
+	os.MkdirAll(filepath.Dir(partial), 0755)
+	os.WriteFile(partial, []byte("hello"), 0644)
+
+	_, err := downloadAndSaveFile(fks, "busy", "docs", filepath.Join(dir, "docs"), "report.txt", 0, 1)
+	if !errors.Is(err, errDownloadFailed) {
+		t.Fatalf("error = %v, want download failure", err)
+	}
+	if data, err := os.ReadFile(partial); err != nil || string(data) != "hello" {
+		t.Errorf("partial file = %q, %v, want it kept", data, err)
+	}
+}
+
+func TestDownloadAndSaveFileSizeMismatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int64
+		wantErr     bool
+		wantPartial bool
+	}{
+		{name: "matching size", size: 11},
+		{name: "unknown size", size: 0},
+		{name: "shorter than reported", size: 20, wantErr: true, wantPartial: true},
+		{name: "longer than reported", size: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("hello world"))
+
+			_, err := downloadAndSaveFile(fks, "file-1", "docs", filepath.Join(dir, "docs"), "report.txt", tt.size, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(partialDownloadPath("docs", "file-1")); (statErr == nil) != tt.wantPartial {
+				t.Errorf("partial file kept = %v, want %v", statErr == nil, tt.wantPartial)
+			}
+			if !tt.wantErr && !fileExists(filepath.Join(dir, "docs", "report.txt")) {
+				t.Error("file not saved")
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a file and its metadata record as if user had saved it to category
+func addSavedFile(t *testing.T, path, category string, userID int64, messageID int) FileRecord {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("same content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	record := FileRecord{
+		Path:      path,
+		Name:      filepath.Base(path),
+		Category:  category,
+		SHA256:    "abc123",
+		UserID:    userID,
+		ChatID:    1,
+		MessageID: messageID,
+		SavedAt:   time.Now(),
+	}
+	if err := metadata.Add(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestApplyDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		ownerID     int64
+		readOnly    bool
+		wantErr     bool
+		wantOld     bool // Older copy still on disk and in metadata
+		wantNewFile bool
+	}{
+		{name: "keep-both", policy: duplicateKeepBoth, ownerID: 1, wantOld: true, wantNewFile: true},
+		{name: "keep-first", policy: duplicateKeepFirst, ownerID: 1, wantErr: true, wantOld: true},
+		{name: "keep-latest own copy", policy: duplicateKeepLatest, ownerID: 1, wantNewFile: true},
+		{name: "keep-latest other user's copy", policy: duplicateKeepLatest, ownerID: 2, wantOld: true, wantNewFile: true},
+		{name: "keep-latest read-only category", policy: duplicateKeepLatest, ownerID: 1, readOnly: true, wantOld: true, wantNewFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "archive")
+			config.DuplicatePolicy = tt.policy
+			config.Categories[1].ReadOnly = tt.readOnly
+
+			old := addSavedFile(t, filepath.Join(dir, "archive", "old.txt"), "archive", tt.ownerID, 10)
+			newPath := filepath.Join(dir, "docs", "new.txt")
+			os.MkdirAll(filepath.Dir(newPath), 0755)
+			os.WriteFile(newPath, []byte("same content"), 0644)
+
+			_, err := applyDuplicatePolicy(savedFile{Path: newPath, SHA256: "abc123"}, "docs", 1)
+
+			var dupErr *duplicateFileError
+			if gotErr := errors.As(err, &dupErr); gotErr != tt.wantErr {
+				t.Errorf("error = %v, want duplicate error %v", err, tt.wantErr)
+			}
+			if got := fileExists(old.Path); got != tt.wantOld {
+				t.Errorf("old file exists = %v, want %v", got, tt.wantOld)
+			}
+			if got := metadata.Referenced(old.Path); got != tt.wantOld {
+				t.Errorf("old record kept = %v, want %v", got, tt.wantOld)
+			}
+			if got := fileExists(newPath); got != tt.wantNewFile {
+				t.Errorf("new file exists = %v, want %v", got, tt.wantNewFile)
+			}
+		})
+	}
+}
+
+func TestApplyDuplicatePolicyKeepLatestSharedBlob(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.DuplicatePolicy = duplicateKeepLatest
+	config.StorageLayout = storageLayoutContent
+
+	// Two users saved the same content, the blob is shared
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	mine := addSavedFile(t, blob, "docs", 1, 10)
+	theirs := addSavedFile(t, blob, "docs", 2, 11)
+
+	if _, err := applyDuplicatePolicy(savedFile{Path: blob, SHA256: "abc123"}, "docs", 1); err != nil {
+		t.Fatalf("applyDuplicatePolicy: %v", err)
+	}
+
+	if !fileExists(blob) {
+		t.Error("shared blob was removed")
+	}
+	var gotMine, gotTheirs bool
+	for _, record := range metadata.Records() {
+		gotMine = gotMine || sameRecord(record, mine)
+		gotTheirs = gotTheirs || sameRecord(record, theirs)
+	}
+	if gotMine {
+		t.Error("uploader's older record was not replaced")
+	}
+	if !gotTheirs {
+		t.Error("other user's record was removed")
+	}
+}
+
+func TestApplyDuplicatePolicyScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		scope       string
+		oldCategory string
+		trashed     bool
+		fileGone    bool
+		wantDup     bool
+	}{
+		{name: "global scope, other category", scope: "", oldCategory: "archive", wantDup: true},
+		{name: "explicit global scope", scope: dedupScopeGlobal, oldCategory: "archive", wantDup: true},
+		{name: "category scope, other category", scope: dedupScopeCategory, oldCategory: "archive", wantDup: false},
+		{name: "category scope, same category", scope: dedupScopeCategory, oldCategory: "docs", wantDup: true},
+		{name: "trashed copy ignored", scope: dedupScopeGlobal, oldCategory: "archive", trashed: true, wantDup: false},
+		{name: "missing file ignored", scope: dedupScopeGlobal, oldCategory: "archive", fileGone: true, wantDup: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "archive")
+			config.DuplicatePolicy = duplicateKeepFirst
+			config.DedupScope = tt.scope
+
+			old := addSavedFile(t, filepath.Join(dir, tt.oldCategory, "old.txt"), tt.oldCategory, 1, 10)
+			if tt.trashed {
+				if err := metadata.Trash(old, old.Path, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.fileGone {
+				os.Remove(old.Path)
+			}
+			newPath := filepath.Join(dir, "docs", "new.txt")
+			os.MkdirAll(filepath.Dir(newPath), 0755)
+			os.WriteFile(newPath, []byte("same content"), 0644)
+
+			_, err := applyDuplicatePolicy(savedFile{Path: newPath, SHA256: "abc123"}, "docs", 1)
+
+			var dupErr *duplicateFileError
+			if got := errors.As(err, &dupErr); got != tt.wantDup {
+				t.Errorf("duplicate = %v (%v), want %v", got, err, tt.wantDup)
+			}
+			if got := fileExists(newPath); got == tt.wantDup {
+				t.Errorf("new file kept = %v, want %v", got, !tt.wantDup)
+			}
+		})
+	}
+}
+
+func TestWithinDuplicateGrace(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		grace int
+		ages  []time.Duration // How long ago each existing copy was saved
+		want  bool
+	}{
+		{name: "grace not configured", ages: []time.Duration{24 * time.Hour}, want: true},
+		{name: "within grace", grace: 60, ages: []time.Duration{30 * time.Second}, want: true},
+		{name: "at grace", grace: 60, ages: []time.Duration{time.Minute}, want: true},
+		{name: "past grace", grace: 60, ages: []time.Duration{2 * time.Minute}},
+		{name: "latest copy within grace", grace: 60, ages: []time.Duration{time.Hour, 10 * time.Second}, want: true},
+		{name: "all copies past grace", grace: 60, ages: []time.Duration{time.Hour, 2 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.DuplicateGrace = tt.grace
+			var existing []FileRecord
+			for _, age := range tt.ages {
+				existing = append(existing, FileRecord{SavedAt: now.Add(-age)})
+			}
+
+			if got := withinDuplicateGrace(existing, now); got != tt.want {
+				t.Errorf("withinDuplicateGrace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDuplicatePolicyGrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		grace   int
+		age     time.Duration // How long ago the existing copy was saved
+		wantErr bool          // Re-send rejected as a duplicate
+	}{
+		{name: "re-send within grace replaces", grace: 60, age: 10 * time.Second},
+		{name: "re-send past grace kept as duplicate", grace: 60, age: time.Hour, wantErr: true},
+		{name: "no grace always replaces", age: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.DuplicatePolicy = duplicateKeepLatest
+			config.DuplicateGrace = tt.grace
+
+			oldPath := filepath.Join(dir, "docs", "old.txt")
+			os.MkdirAll(filepath.Dir(oldPath), 0755)
+			os.WriteFile(oldPath, []byte("same content"), 0644)
+			metadata.Add(FileRecord{Path: oldPath, Name: "old.txt", Category: "docs", SHA256: "abc123", UserID: 1, SavedAt: time.Now().Add(-tt.age)})
+			newPath := filepath.Join(dir, "docs", "new.txt")
+			os.WriteFile(newPath, []byte("same content"), 0644)
+
+			_, err := applyDuplicatePolicy(savedFile{Path: newPath, SHA256: "abc123"}, "docs", 1)
+
+			var dupErr *duplicateFileError
+			if gotErr := errors.As(err, &dupErr); gotErr != tt.wantErr {
+				t.Errorf("error = %v, want duplicate error %v", err, tt.wantErr)
+			}
+			if fileExists(oldPath) != tt.wantErr || fileExists(newPath) == tt.wantErr {
+				t.Errorf("old exists %v, new exists %v", fileExists(oldPath), fileExists(newPath))
+			}
+		})
+	}
+}
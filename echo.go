@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Echo defaults
+const (
+	defaultEchoMaxSize = 10 << 20 // 10 MB
+	maxBotUploadSize   = 50 << 20 // Largest file the Bot API lets bots upload
+)
+
+// EchoConfig represents settings for sending small saved files back to the sender
+type EchoConfig struct {
+	Enabled      bool  `yaml:"enabled"`        // Default for users who did not choose in /settings
+	MaxSizeBytes int64 `yaml:"max_size_bytes"` // Larger files are not sent back
+}
+
+var (
+	userEchoMu sync.Mutex
+	userEcho   = make(map[int64]bool) // Map of user ID to their choice, overriding echo_files.enabled
+)
+
+// Check if saved files are sent back to user
+func echoEnabled(userID int64) bool {
+	userEchoMu.Lock()
+	defer userEchoMu.Unlock()
+
+	if enabled, ok := userEcho[userID]; ok {
+		return enabled
+	}
+	return config.EchoFiles.Enabled
+}
+
+// Switch sending saved files back on or off for user
+func toggleEcho(userID int64) bool {
+	enabled := !echoEnabled(userID)
+
+	userEchoMu.Lock()
+	userEcho[userID] = enabled
+	userEchoMu.Unlock()
+	return enabled
+}
+
+// Get copy of users' echo choices
+func userEchoChoices() map[int64]bool {
+	userEchoMu.Lock()
+	defer userEchoMu.Unlock()
+
+	choices := make(map[int64]bool, len(userEcho))
+	for userID, enabled := range userEcho {
+		choices[userID] = enabled
+	}
+	return choices
+}
+
+// Get largest file size sent back, never above the upload limit
+func echoMaxSize() int64 {
+	maxSize := config.EchoFiles.MaxSizeBytes
+	if maxSize <= 0 {
+		maxSize = defaultEchoMaxSize
+	}
+	return min(maxSize, maxBotUploadSize)
+}
+
+// Send saved file back to the chat as a document when small enough and wanted by the sender
+func echoSavedFile(bot Sender, chatID, userID int64, saved savedFile) {
+	if !echoEnabled(userID) || saved.Size > echoMaxSize() {
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(saved.Path))
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("Error sending back %s: %v", saved.Path, err)
+	}
+}
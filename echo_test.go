@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestEchoSavedFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   EchoConfig
+		userEcho map[int64]bool // Choices made in /settings
+		size     int
+		want     bool
+	}{
+		{name: "disabled", config: EchoConfig{}, size: 4},
+		{name: "enabled small file", config: EchoConfig{Enabled: true}, size: 4, want: true},
+		{name: "enabled file at max size", config: EchoConfig{Enabled: true, MaxSizeBytes: 4}, size: 4, want: true},
+		{name: "enabled file above max size", config: EchoConfig{Enabled: true, MaxSizeBytes: 3}, size: 4},
+		{name: "user turned it on", config: EchoConfig{}, userEcho: map[int64]bool{1: true}, size: 4, want: true},
+		{name: "user turned it off", config: EchoConfig{Enabled: true}, userEcho: map[int64]bool{1: false}, size: 4},
+		{name: "other user's choice", config: EchoConfig{}, userEcho: map[int64]bool{2: true}, size: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.EchoFiles = tt.config
+			for userID, enabled := range tt.userEcho {
+				userEcho[userID] = enabled
+			}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", make([]byte, tt.size))
+
+			handleFileMessage(fks, documentMessage("file-1", "small.txt", "/docs", tt.size))
+
+			var echoed bool
+			for _, c := range fks.sent {
+				if doc, ok := c.(tgbotapi.DocumentConfig); ok && doc.ChatID == 1 {
+					echoed = true
+				}
+			}
+			if echoed != tt.want {
+				t.Errorf("echoed = %v, want %v, replies %q", echoed, tt.want, fks.texts())
+			}
+		})
+	}
+}
+
+func TestEchoMaxSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		want    int64
+	}{
+		{name: "default", want: defaultEchoMaxSize},
+		{name: "negative uses default", maxSize: -1, want: defaultEchoMaxSize},
+		{name: "configured", maxSize: 1024, want: 1024},
+		{name: "capped at upload limit", maxSize: maxBotUploadSize + 1, want: maxBotUploadSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.EchoFiles.MaxSizeBytes = tt.maxSize
+			if got := echoMaxSize(); got != tt.want {
+				t.Errorf("echoMaxSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToggleEcho(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool // echo_files.enabled
+		toggles int
+		want    bool
+	}{
+		{name: "on from disabled default", toggles: 1, want: true},
+		{name: "off from enabled default", enabled: true, toggles: 1},
+		{name: "back to default", enabled: true, toggles: 2, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.EchoFiles.Enabled = tt.enabled
+			for i := 0; i < tt.toggles; i++ {
+				toggleEcho(1)
+			}
+			if got := echoEnabled(1); got != tt.want {
+				t.Errorf("echoEnabled = %v, want %v", got, tt.want)
+			}
+			if echoEnabled(2) != tt.enabled {
+				t.Errorf("toggle changed another user's setting")
+			}
+		})
+	}
+}
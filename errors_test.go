@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestSaveErrorText(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		message string // Configured storage unavailable message
+		want    string
+	}{
+		{name: "storage unavailable", err: storageError(syscall.EACCES), want: "storage for category 'docs' is unavailable"},
+		{name: "custom unavailable message", err: storageError(syscall.EROFS), message: "{category} is offline", want: "docs is offline"},
+		{name: "disk full", err: storageError(syscall.ENOSPC), want: "storage is full"},
+		{name: "download failed", err: downloadError(errors.New("connection reset")), want: "could not get it from Telegram, please send it again. (download failed: connection reset)"},
+		{name: "other failure", err: storageError(syscall.EIO), want: "Error saving file: cannot write to storage"},
+		{name: "too big", err: errFileTooBig, want: "Error saving file: file is too big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.StorageUnavailableMessage = tt.message
+
+			if got := saveErrorText(tt.err, "docs"); !strings.Contains(got, tt.want) {
+				t.Errorf("saveErrorText() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientAPIError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection refused"), want: true},
+		{name: "rate limited", err: &tgbotapi.Error{Code: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: &tgbotapi.Error{Code: http.StatusBadGateway}, want: true},
+		{name: "wrapped server error", err: fmt.Errorf("get file: %w", &tgbotapi.Error{Code: http.StatusInternalServerError}), want: true},
+		{name: "bad request", err: &tgbotapi.Error{Code: http.StatusBadRequest, Message: "invalid file_id"}, want: false},
+		{name: "forbidden", err: &tgbotapi.Error{Code: http.StatusForbidden}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientAPIError(tt.err); got != tt.want {
+				t.Errorf("isTransientAPIError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EXIF tags used to find the original capture date
+const (
+	exifIFDPointerTag   = 0x8769
+	dateTimeOriginalTag = 0x9003
+	exifDateLayout      = "2006:01:02 15:04:05"
+)
+
+var errNoExifDate = errors.New("no EXIF DateTimeOriginal found")
+
+// Read EXIF DateTimeOriginal from a JPEG file
+func readExifDate(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	exifData, err := findExifSegment(bufio.NewReader(file))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return parseExifDate(exifData)
+}
+
+// Find APP1 EXIF segment payload (TIFF header onwards) in JPEG stream
+func findExifSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+
+		// Start of scan or end of image, no EXIF before image data
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, errNoExifDate
+		}
+
+		var sizeBuf [2]byte
+		if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+			return nil, err
+		}
+		size := int(binary.BigEndian.Uint16(sizeBuf[:])) - 2
+		if size < 0 {
+			return nil, errors.New("invalid JPEG segment size")
+		}
+
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// Parse DateTimeOriginal from TIFF-structured EXIF data
+func parseExifDate(data []byte) (time.Time, error) {
+	if len(data) < 8 {
+		return time.Time{}, errNoExifDate
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errors.New("invalid EXIF byte order")
+	}
+
+	// IFD0 holds a pointer to the EXIF sub-IFD with the capture date
+	exifOffset, ok := findIFDEntry(data, order, order.Uint32(data[4:8]), exifIFDPointerTag)
+	if !ok {
+		return time.Time{}, errNoExifDate
+	}
+
+	valueOffset, ok := findIFDEntry(data, order, exifOffset, dateTimeOriginalTag)
+	if !ok || int(valueOffset)+len(exifDateLayout) > len(data) {
+		return time.Time{}, errNoExifDate
+	}
+
+	value := strings.TrimRight(string(data[valueOffset:int(valueOffset)+len(exifDateLayout)]), "\x00 ")
+	date, err := time.ParseInLocation(exifDateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid EXIF date %q: %w", value, err)
+	}
+	return date, nil
+}
+
+// Find tag in IFD at offset and return its value/offset field
+func findIFDEntry(data []byte, order binary.ByteOrder, offset uint32, tag uint16) (uint32, bool) {
+	if int(offset)+2 > len(data) {
+		return 0, false
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(data) {
+			return 0, false
+		}
+		if order.Uint16(data[entry:]) == tag {
+			return order.Uint32(data[entry+8:]), true
+		}
+	}
+	return 0, false
+}
+
+// Set file modification time to the EXIF capture date, falling back to the given date
+func applyCaptureDate(path string, fallback time.Time) {
+	date := fallback
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".jpg" || ext == ".jpeg" {
+		if exifDate, err := readExifDate(path); err == nil {
+			date = exifDate
+		} else {
+			log.Printf("Using message date for %s: %v", path, err)
+		}
+	}
+
+	if err := os.Chtimes(path, date, date); err != nil {
+		log.Printf("Error setting modification time for %s: %v", path, err)
+	}
+}
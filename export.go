@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Columns of the metadata CSV export
+var metadataCSVHeader = []string{"name", "path", "category", "size", "sha256", "user_id", "chat_id", "saved_at", "tags"}
+
+// Write records of stored files as CSV, one row at a time
+func writeMetadataCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(metadataCSVHeader); err != nil {
+		return err
+	}
+
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil {
+			continue
+		}
+		err := out.Write([]string{
+			record.Name,
+			record.Path,
+			record.Category,
+			strconv.FormatInt(record.Size, 10),
+			record.SHA256,
+			strconv.FormatInt(record.UserID, 10),
+			strconv.FormatInt(record.ChatID, 10),
+			record.SavedAt.Format(time.RFC3339),
+			formatCSVTags(record.Tags),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Format caption tags as key=value pairs sorted by key, separated by semicolons
+func formatCSVTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+// Send metadata of all stored files as a CSV document, admins only
+func handleExportMetadataCommand(bot Sender, message *tgbotapi.Message) {
+	if !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Only bot administrators can export metadata.")
+		bot.Send(msg)
+		return
+	}
+
+	// Stream rows to a temporary file instead of building the document in memory
+	tmpFile, err := os.CreateTemp("", "metadata-*.csv")
+	if err != nil {
+		log.Printf("Error creating metadata export: %v", err)
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = writeMetadataCSV(tmpFile)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error exporting metadata: %s", err.Error()))
+		bot.Send(msg)
+		return
+	}
+
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FilePath(tmpFile.Name()))
+	doc.Caption = fmt.Sprintf("Metadata export %s", time.Now().Format("2006-01-02 15:04"))
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("Error sending metadata export: %v", err)
+	}
+}
+
+// Stream metadata of all stored files as CSV
+func handleAPIMetadataCSV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="metadata.csv"`)
+	if err := writeMetadataCSV(w); err != nil {
+		log.Printf("Error writing metadata CSV: %v", err)
+	}
+}
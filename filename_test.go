@@ -0,0 +1,299 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		wantErr  bool
+	}{
+		{name: "plain name", filename: "report.pdf"},
+		{name: "dots inside name", filename: "v1..2.tar.gz"},
+		{name: "leading dots in name", filename: "..hidden"},
+		{name: "current directory", filename: ".", wantErr: true},
+		{name: "parent directory", filename: "..", wantErr: true},
+		{name: "dots and spaces", filename: ". . .", wantErr: true},
+		{name: "empty", filename: "", wantErr: true},
+		{name: "parent path", filename: "../etc/passwd"},
+		{name: "deep parent path", filename: "../../../../etc/passwd"},
+		{name: "absolute path", filename: "/etc/passwd"},
+		{name: "windows parent path", filename: `..\..\boot.ini`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			if err := validateFilename(tt.filename, "docs"); (err != nil) != tt.wantErr {
+				t.Errorf("validateFilename(%q) = %v, want error %v", tt.filename, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageTraversalNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		wantName string // Saved name inside the category, empty when rejected
+	}{
+		{name: "parent directory", caption: "/docs .."},
+		{name: "current directory", caption: "/docs ."},
+		{name: "parent path", caption: "/docs ../etc/passwd", wantName: ".._etc_passwd.txt"},
+		{name: "absolute path", caption: "/docs /etc/passwd", wantName: "_etc_passwd.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "notes.txt", tt.caption, 4))
+
+			if tt.wantName == "" {
+				if !fks.sentContaining("Invalid filename") {
+					t.Errorf("replies %q do not reject the name", fks.texts())
+				}
+				if entries, _ := os.ReadDir(dir); len(metadata.Records()) != 0 {
+					t.Errorf("file saved, directory holds %v", entries)
+				}
+				return
+			}
+			if !fileExists(filepath.Join(dir, "docs", tt.wantName)) {
+				t.Errorf("%s not saved inside the category, replies %q", tt.wantName, fks.texts())
+			}
+			if fileExists(filepath.Join(dir, "etc")) {
+				t.Error("file written outside the category")
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		rules    SanitizeConfig
+		want     string
+	}{
+		{name: "short name kept", filename: "report.pdf", want: "report.pdf"},
+		{name: "at the limit", filename: strings.Repeat("a", 236) + ".pdf", want: strings.Repeat("a", 236) + ".pdf"},
+		{name: "over the limit keeps extension", filename: strings.Repeat("a", 300) + ".pdf", want: strings.Repeat("a", 236) + ".pdf"},
+		{name: "configured limit", filename: "annual-report.pdf", rules: SanitizeConfig{MaxLength: 10}, want: "annual.pdf"},
+		{name: "configured limit above maximum", filename: strings.Repeat("a", 300), rules: SanitizeConfig{MaxLength: 500}, want: strings.Repeat("a", 240)},
+		{name: "extension longer than limit", filename: "a." + strings.Repeat("x", 20), rules: SanitizeConfig{MaxLength: 10}, want: "a." + strings.Repeat("x", 8)},
+		{name: "multibyte character not split", filename: "aaaaaaaaé.txt", rules: SanitizeConfig{MaxLength: 13}, want: "aaaaaaaa.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.filename, tt.rules); got != tt.want {
+				t.Errorf("sanitizeFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageShortenedNameNote(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		wantNote bool
+	}{
+		{name: "short name", caption: "/docs report", wantNote: false},
+		{name: "long name", caption: "/docs " + strings.Repeat("a", 300), wantNote: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "notes.txt", tt.caption, 4))
+
+			if got := fks.sentContaining("the filename was too long"); got != tt.wantNote {
+				t.Errorf("shortened note sent = %v, want %v, replies %q", got, tt.wantNote, fks.texts())
+			}
+		})
+	}
+}
+
+func TestUniqueFilename(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSuffix int
+		taken     []string
+		want      string // Empty when a timestamp and random suffix are expected
+	}{
+		{name: "free name", want: "/srv/report.pdf"},
+		{name: "first copy", taken: []string{"/srv/report.pdf"}, want: "/srv/report_1.pdf"},
+		{name: "next free copy", taken: []string{"/srv/report.pdf", "/srv/report_1.pdf", "/srv/report_2.pdf"}, want: "/srv/report_3.pdf"},
+		{name: "gap in copies", taken: []string{"/srv/report.pdf", "/srv/report_2.pdf"}, want: "/srv/report_1.pdf"},
+		{name: "limit reached", maxSuffix: 2, taken: []string{"/srv/report.pdf", "/srv/report_1.pdf", "/srv/report_2.pdf"}},
+		{name: "limit not reached", maxSuffix: 3, taken: []string{"/srv/report.pdf", "/srv/report_1.pdf", "/srv/report_2.pdf"}, want: "/srv/report_3.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.MaxUniqueSuffix = tt.maxSuffix
+			taken := func(path string) bool { return slices.Contains(tt.taken, path) }
+
+			got := uniqueFilename("/srv/report.pdf", taken)
+			if tt.want != "" {
+				if got != tt.want {
+					t.Errorf("uniqueFilename() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			if !regexp.MustCompile(`^/srv/report_\d+_[0-9a-f]{8}\.pdf$`).MatchString(got) {
+				t.Errorf("uniqueFilename() = %q, want timestamp and random suffix", got)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		rules    SanitizeConfig
+		want     string
+	}{
+		{name: "clean name", filename: "report.pdf", want: "report.pdf"},
+		{name: "invalid characters", filename: `a/b\c:d*e?f"g<h>i|j.txt`, want: "a_b_c_d_e_f_g_h_i_j.txt"},
+		{name: "custom replacement", filename: "a/b:c.txt", rules: SanitizeConfig{Replacement: "-"}, want: "a-b-c.txt"},
+		{name: "spaces kept by default", filename: "my report.pdf", want: "my report.pdf"},
+		{name: "replace spaces", filename: "my report\t2024.pdf", rules: SanitizeConfig{ReplaceSpaces: true}, want: "my_report_2024.pdf"},
+		{name: "strip characters", filename: "#report!.pdf", rules: SanitizeConfig{StripChars: "#!"}, want: "report.pdf"},
+		{name: "strip emoji", filename: "party🎉👍🏽.jpg", rules: SanitizeConfig{StripEmoji: true}, want: "party.jpg"},
+		{name: "emoji kept by default", filename: "party🎉.jpg", want: "party🎉.jpg"},
+		{name: "stripping leaves only extension", filename: "🎉.jpg", rules: SanitizeConfig{StripEmoji: true}, want: "file.jpg"},
+		{name: "dot names replaced", filename: "..", want: "__"},
+		{name: "hidden file kept", filename: ".env", want: ".env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.filename, tt.rules); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeRulesMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   SanitizeConfig
+		category SanitizeConfig
+		want     SanitizeConfig
+	}{
+		{name: "global only", global: SanitizeConfig{Replacement: "-", StripChars: "#"}, want: SanitizeConfig{Replacement: "-", StripChars: "#"}},
+		{name: "category only", category: SanitizeConfig{StripChars: "+,", MaxLength: 120}, want: SanitizeConfig{StripChars: "+,", MaxLength: 120}},
+		{name: "category replacement wins", global: SanitizeConfig{Replacement: "-"}, category: SanitizeConfig{Replacement: "."}, want: SanitizeConfig{Replacement: "."}},
+		{name: "strip characters added", global: SanitizeConfig{StripChars: "#!"}, category: SanitizeConfig{StripChars: "+"}, want: SanitizeConfig{StripChars: "#!+"}},
+		{name: "flags from either", global: SanitizeConfig{StripEmoji: true}, category: SanitizeConfig{ReplaceSpaces: true}, want: SanitizeConfig{StripEmoji: true, ReplaceSpaces: true}},
+		{name: "shorter category length", global: SanitizeConfig{MaxLength: 200}, category: SanitizeConfig{MaxLength: 120}, want: SanitizeConfig{MaxLength: 120}},
+		{name: "longer category length ignored", global: SanitizeConfig{MaxLength: 100}, category: SanitizeConfig{MaxLength: 120}, want: SanitizeConfig{MaxLength: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "fat32", "docs")
+			config.Sanitize = tt.global
+			config.Categories[0].Sanitize = tt.category
+
+			if got := sanitizeRules("fat32"); got != tt.want {
+				t.Errorf("sanitizeRules(fat32) = %+v, want %+v", got, tt.want)
+			}
+			if got := sanitizeRules("docs"); got != tt.global {
+				t.Errorf("sanitizeRules(docs) = %+v, want global rules %+v", got, tt.global)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageCategorySanitize(t *testing.T) {
+	dir := setupTestBot(t, "fat32", "docs")
+	config.Categories[0].Sanitize = SanitizeConfig{StripChars: "+,;=[]"}
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+	fks.addFile("file-2", []byte("data"))
+
+	handleFileMessage(fks, documentMessage("file-1", "a+b[1].txt", "/fat32", 4))
+	handleFileMessage(fks, documentMessage("file-2", "a+b[1].txt", "/docs", 4))
+
+	for _, path := range []string{"fat32/ab1.txt", "docs/a+b[1].txt"} {
+		if !fileExists(filepath.Join(dir, path)) {
+			t.Errorf("%s not saved, replies %q", path, fks.texts())
+		}
+	}
+}
+
+func TestBuildFilePathUniqueScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		taken []string // Existing files, relative to the category root
+		want  string   // Relative to the category root
+	}{
+		{name: "folder scope free name", scope: uniqueScopeFolder, want: "2024/report.pdf"},
+		{name: "folder scope name in same folder", scope: uniqueScopeFolder, taken: []string{"2024/report.pdf"}, want: "2024/report_1.pdf"},
+		{name: "folder scope name in other folder", scope: uniqueScopeFolder, taken: []string{"2023/report.pdf"}, want: "2024/report.pdf"},
+		{name: "default scope name in other folder", taken: []string{"2023/report.pdf"}, want: "2024/report.pdf"},
+		{name: "category scope name in other folder", scope: uniqueScopeCategory, taken: []string{"2023/report.pdf"}, want: "2024/report_1.pdf"},
+		{name: "category scope numbered names in other folders", scope: uniqueScopeCategory, taken: []string{"2022/report.pdf", "2023/report_1.pdf"}, want: "2024/report_2.pdf"},
+		{name: "category scope name in nested folder", scope: uniqueScopeCategory, taken: []string{"2023/06/report.pdf"}, want: "2024/report_1.pdf"},
+		{name: "category scope other name", scope: uniqueScopeCategory, taken: []string{"2023/summary.pdf"}, want: "2024/report.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.Categories[0].UniqueScope = tt.scope
+			root := filepath.Join(dir, "docs")
+			for _, name := range tt.taken {
+				path := filepath.Join(root, name)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				os.WriteFile(path, []byte("x"), 0644)
+			}
+
+			got := buildFilePath(filepath.Join(root, "2024"), "report.pdf", "docs")
+			if want := filepath.Join(root, tt.want); got != want {
+				t.Errorf("buildFilePath() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUniqueScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		wantErr bool
+	}{
+		{scope: ""},
+		{scope: uniqueScopeFolder},
+		{scope: uniqueScopeCategory},
+		{scope: "global", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			dir := setupTestBot(t)
+			path := filepath.Join(dir, "config.yml")
+			data := "categories:\n  - name: docs\n    path: " + filepath.Join(dir, "docs") + "\n    unique_scope: \"" + tt.scope + "\"\n"
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := loadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadConfig() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && err != nil && !strings.Contains(err.Error(), "unique_scope") {
+				t.Errorf("error %q does not name unique_scope", err)
+			}
+		})
+	}
+}
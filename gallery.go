@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/gif" // Register GIF decoder for thumbnails
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Gallery defaults
+const (
+	defaultGalleryPort          = 8081
+	defaultGalleryThumbnailSize = 200
+	defaultGalleryCacheDir      = "./files/.thumbnails"
+	defaultThumbnailQuality     = 80
+	maxThumbnailSourcePixels    = 50_000_000 // Larger images are not decoded, a small file may expand to gigabytes
+)
+
+// GalleryConfig represents settings for the web gallery
+type GalleryConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Port          int    `yaml:"port"`
+	Username      string `yaml:"username"` // Basic auth is required when set
+	Password      string `yaml:"password"`
+	ThumbnailSize int    `yaml:"thumbnail_size"` // Maximum thumbnail width and height in pixels
+	CacheDir      string `yaml:"cache_dir"`
+}
+
+// ThumbnailConfig represents thumbnail settings of a category
+type ThumbnailConfig struct {
+	MaxSize int    `yaml:"max_size"` // Maximum width and height in pixels, 0 uses gallery thumbnail_size, negative disables
+	Format  string `yaml:"format"`   // jpeg (default) or png
+	Quality int    `yaml:"quality"`  // JPEG quality 1-100, default 80
+}
+
+// Categories of non-image files, thumbnails are off unless they configure a size
+var nonImageCategories = map[string]bool{
+	"video":    true,
+	"audio":    true,
+	"document": true,
+	"other":    true,
+}
+
+// Image extensions shown in the gallery
+var galleryImageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Funcs(template.FuncMap{"pathEscape": escapeGalleryPath}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Category}}{{.Category}} - {{end}}Gallery</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+.grid { display: flex; flex-wrap: wrap; gap: 10px; }
+.grid a { display: block; text-align: center; width: {{.Size}}px; font-size: 12px; word-break: break-all; }
+.grid img { max-width: {{.Size}}px; max-height: {{.Size}}px; }
+</style>
+</head>
+<body>
+{{if .Category}}
+<p><a href="/">All categories</a></p>
+<h1>{{.Category}}</h1>
+<div class="grid">
+{{range .Files}}<a href="/file/{{pathEscape $.Category}}/{{pathEscape .}}"><img src="/thumb/{{pathEscape $.Category}}/{{pathEscape .}}" loading="lazy" alt=""><br>{{.}}</a>
+{{else}}<p>No images.</p>{{end}}
+</div>
+{{else}}
+<h1>Categories</h1>
+<ul>
+{{range .Categories}}<li><a href="/c/{{pathEscape .}}">{{.}}</a></li>
+{{end}}
+</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// galleryPage represents data rendered by the gallery template
+type galleryPage struct {
+	Categories []string
+	Category   string
+	Files      []string
+	Size       int
+}
+
+// Start web gallery server, blocks until the server stops
+func startGalleryServer() {
+	port := config.Gallery.Port
+	if port == 0 {
+		port = defaultGalleryPort
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleGalleryIndex)
+	mux.HandleFunc("GET /c/{category}", handleGalleryCategory)
+	mux.HandleFunc("GET /thumb/{category}/{name...}", handleGalleryThumbnail)
+	mux.HandleFunc("GET /file/{category}/{name...}", handleGalleryFile)
+
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("Starting gallery server on %s", addr)
+	if err := http.ListenAndServe(addr, requireGalleryAuth(mux)); err != nil {
+		log.Printf("Gallery server stopped: %v", err)
+	}
+}
+
+// Require basic auth when gallery credentials are configured
+func requireGalleryAuth(next http.Handler) http.Handler {
+	if config.Gallery.Username == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(config.Gallery.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(config.Gallery.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gallery"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List categories
+func handleGalleryIndex(w http.ResponseWriter, r *http.Request) {
+	page := galleryPage{Size: galleryThumbnailSize()}
+	for _, cat := range getCategories() {
+		if _, enabled := categoryThumbnailConfig(cat.Name); enabled {
+			page.Categories = append(page.Categories, cat.Name)
+		}
+	}
+	renderGalleryPage(w, page)
+}
+
+// Show image grid of a category
+func handleGalleryCategory(w http.ResponseWriter, r *http.Request) {
+	category := r.PathValue("category")
+	thumbnails, enabled := categoryThumbnailConfig(category)
+	if _, exists := categoryPath(category); !exists || !enabled {
+		http.NotFound(w, r)
+		return
+	}
+	root := categoryRoot(category)
+
+	files, err := listCategoryFiles(category, root)
+	if err != nil {
+		log.Printf("Error listing files in %s: %v", root, err)
+	}
+
+	page := galleryPage{Category: category, Size: thumbnails.MaxSize}
+	for _, file := range files {
+		if galleryImageExtensions[strings.ToLower(filepath.Ext(file.Name))] {
+			page.Files = append(page.Files, file.Name)
+		}
+	}
+	renderGalleryPage(w, page)
+}
+
+// Serve cached thumbnail, generating it on first request
+func handleGalleryThumbnail(w http.ResponseWriter, r *http.Request) {
+	path, ok := resolveGalleryImage(w, r)
+	if !ok {
+		return
+	}
+	thumbnails, enabled := categoryThumbnailConfig(r.PathValue("category"))
+	if !enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	thumbPath, err := galleryThumbnail(path, thumbnails)
+	if err != nil {
+		log.Printf("Error generating thumbnail for %s: %v", path, err)
+		http.Error(w, "Cannot generate thumbnail", http.StatusInternalServerError)
+		return
+	}
+	http.ServeFile(w, r, thumbPath)
+}
+
+// Serve original image
+func handleGalleryFile(w http.ResponseWriter, r *http.Request) {
+	if path, ok := resolveGalleryImage(w, r); ok {
+		http.ServeFile(w, r, path)
+	}
+}
+
+// Resolve image path from request, writing error response if invalid
+func resolveGalleryImage(w http.ResponseWriter, r *http.Request) (string, bool) {
+	category := r.PathValue("category")
+	if _, exists := categoryPath(category); !exists {
+		http.NotFound(w, r)
+		return "", false
+	}
+
+	path, err := resolveCategoryFile(category, r.PathValue("name"))
+	if err != nil || !galleryImageExtensions[strings.ToLower(filepath.Ext(path))] {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return "", false
+	}
+
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return "", false
+	}
+	return path, true
+}
+
+// Resolve thumbnail settings of category, false when it has no thumbnails
+func categoryThumbnailConfig(category string) (ThumbnailConfig, bool) {
+	thumbnails := getCategoryConfig(category).Thumbnails
+	if thumbnails.MaxSize < 0 || (thumbnails.MaxSize == 0 && nonImageCategories[category]) {
+		return ThumbnailConfig{}, false
+	}
+
+	if thumbnails.MaxSize == 0 {
+		thumbnails.MaxSize = galleryThumbnailSize()
+	}
+	if thumbnails.Format != "png" {
+		thumbnails.Format = "jpeg"
+	}
+	if thumbnails.Quality <= 0 || thumbnails.Quality > 100 {
+		thumbnails.Quality = defaultThumbnailQuality
+	}
+	return thumbnails, true
+}
+
+// Check thumbnail settings of a category
+func validateThumbnailConfig(thumbnails ThumbnailConfig) error {
+	if thumbnails.Format != "" && thumbnails.Format != "jpeg" && thumbnails.Format != "png" {
+		return fmt.Errorf("invalid thumbnail format %q, expected jpeg or png", thumbnails.Format)
+	}
+	if thumbnails.Quality < 0 || thumbnails.Quality > 100 {
+		return fmt.Errorf("invalid thumbnail quality %d, expected 1-100", thumbnails.Quality)
+	}
+	return nil
+}
+
+// Get thumbnail path for image, creating it in cache if missing
+func galleryThumbnail(path string, thumbnails ThumbnailConfig) (string, error) {
+	cacheDir := config.Gallery.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultGalleryCacheDir
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// Key by content so renamed or moved files reuse their thumbnails and changed files get new ones
+	hash, err := thumbnailSourceHash(path, file)
+	if err != nil {
+		return "", err
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%d", hash, thumbnails.MaxSize, thumbnails.Format, thumbnails.Quality)))
+	ext := ".jpg"
+	if thumbnails.Format == "png" {
+		ext = ".png"
+	}
+	thumbPath := filepath.Join(cacheDir, hex.EncodeToString(key[:])+ext)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	}
+
+	// Check dimensions before decoding, pixels are allocated up front
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	imgConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return "", err
+	}
+	if int64(imgConfig.Width)*int64(imgConfig.Height) > maxThumbnailSourcePixels {
+		return "", fmt.Errorf("image of %dx%d pixels is too large for a thumbnail", imgConfig.Width, imgConfig.Height)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmpFile, err := os.CreateTemp(cacheDir, ".thumb-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	thumb := resizeImage(img, thumbnails.MaxSize)
+	if thumbnails.Format == "png" {
+		err = png.Encode(tmpFile, thumb)
+	} else {
+		err = jpeg.Encode(tmpFile, thumb, &jpeg.Options{Quality: thumbnails.Quality})
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return thumbPath, os.Rename(tmpFile.Name(), thumbPath)
+}
+
+// Get SHA-256 of image content, taken from metadata when the file was saved by the bot
+func thumbnailSourceHash(path string, file io.Reader) (string, error) {
+	record := metadata.FindByPath(path)
+	if info, err := os.Stat(path); err == nil && record.SHA256 != "" && record.Size == info.Size() {
+		return record.SHA256, nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Scale image down to fit within maxSize, averaging source pixels
+func resizeImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return src
+	}
+
+	dstWidth, dstHeight := maxSize, maxSize
+	if width > height {
+		dstHeight = max(1, height*maxSize/width)
+	} else {
+		dstWidth = max(1, width*maxSize/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstHeight)
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n >> 8)
+			dst.Pix[offset+1] = uint8(g / n >> 8)
+			dst.Pix[offset+2] = uint8(b / n >> 8)
+			dst.Pix[offset+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// Get configured thumbnail size
+func galleryThumbnailSize() int {
+	if config.Gallery.ThumbnailSize > 0 {
+		return config.Gallery.ThumbnailSize
+	}
+	return defaultGalleryThumbnailSize
+}
+
+// Escape slash-separated path for a URL, keeping the slashes between segments
+func escapeGalleryPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Render gallery page
+func renderGalleryPage(w http.ResponseWriter, page galleryPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := galleryTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering gallery page: %v", err)
+	}
+}
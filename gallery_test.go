@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCategoryThumbnailConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		category   string
+		thumbnails ThumbnailConfig
+		gallery    int // gallery thumbnail_size
+		want       ThumbnailConfig
+		wantOK     bool
+	}{
+		{name: "defaults", category: "image", want: ThumbnailConfig{MaxSize: defaultGalleryThumbnailSize, Format: "jpeg", Quality: defaultThumbnailQuality}, wantOK: true},
+		{name: "gallery size", category: "image", gallery: 120, want: ThumbnailConfig{MaxSize: 120, Format: "jpeg", Quality: defaultThumbnailQuality}, wantOK: true},
+		{name: "category settings", category: "image", thumbnails: ThumbnailConfig{MaxSize: 64, Format: "png", Quality: 50}, want: ThumbnailConfig{MaxSize: 64, Format: "png", Quality: 50}, wantOK: true},
+		{name: "quality out of range", category: "image", thumbnails: ThumbnailConfig{Quality: 101}, want: ThumbnailConfig{MaxSize: defaultGalleryThumbnailSize, Format: "jpeg", Quality: defaultThumbnailQuality}, wantOK: true},
+		{name: "disabled", category: "image", thumbnails: ThumbnailConfig{MaxSize: -1}},
+		{name: "non-image category", category: "video"},
+		{name: "non-image category with size", category: "video", thumbnails: ThumbnailConfig{MaxSize: 64}, want: ThumbnailConfig{MaxSize: 64, Format: "jpeg", Quality: defaultThumbnailQuality}, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, tt.category)
+			config.Categories[0].Thumbnails = tt.thumbnails
+			config.Gallery.ThumbnailSize = tt.gallery
+
+			got, ok := categoryThumbnailConfig(tt.category)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("categoryThumbnailConfig() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateThumbnailConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		thumbnails ThumbnailConfig
+		wantErr    bool
+	}{
+		{name: "empty"},
+		{name: "jpeg", thumbnails: ThumbnailConfig{Format: "jpeg", Quality: 90}},
+		{name: "png", thumbnails: ThumbnailConfig{Format: "png"}},
+		{name: "unknown format", thumbnails: ThumbnailConfig{Format: "webp"}, wantErr: true},
+		{name: "quality too high", thumbnails: ThumbnailConfig{Quality: 101}, wantErr: true},
+		{name: "negative quality", thumbnails: ThumbnailConfig{Quality: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateThumbnailConfig(tt.thumbnails); (err != nil) != tt.wantErr {
+				t.Errorf("validateThumbnailConfig() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		maxSize       int
+		wantW, wantH  int
+	}{
+		{name: "smaller than max", width: 50, height: 40, maxSize: 100, wantW: 50, wantH: 40},
+		{name: "landscape", width: 400, height: 200, maxSize: 100, wantW: 100, wantH: 50},
+		{name: "portrait", width: 200, height: 400, maxSize: 100, wantW: 50, wantH: 100},
+		{name: "thin strip", width: 1000, height: 2, maxSize: 100, wantW: 100, wantH: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resizeImage(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.maxSize).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("size = %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestGalleryThumbnail(t *testing.T) {
+	tests := []struct {
+		name       string
+		thumbnails ThumbnailConfig
+		wantExt    string
+		wantFormat string
+		wantSize   int
+	}{
+		{name: "jpeg", thumbnails: ThumbnailConfig{MaxSize: 32, Format: "jpeg", Quality: 80}, wantExt: ".jpg", wantFormat: "jpeg", wantSize: 32},
+		{name: "png", thumbnails: ThumbnailConfig{MaxSize: 16, Format: "png"}, wantExt: ".png", wantFormat: "png", wantSize: 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "image")
+			config.Gallery.CacheDir = filepath.Join(dir, "cache")
+			source := writeTestImage(t, filepath.Join(dir, "image", "photo.png"), 64)
+
+			thumbPath, err := galleryThumbnail(source, tt.thumbnails)
+			if err != nil {
+				t.Fatalf("galleryThumbnail: %v", err)
+			}
+			if filepath.Ext(thumbPath) != tt.wantExt {
+				t.Errorf("thumbnail %s, want extension %s", thumbPath, tt.wantExt)
+			}
+			file, err := os.Open(thumbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			cfg, format, err := image.DecodeConfig(file)
+			if err != nil || format != tt.wantFormat || cfg.Width != tt.wantSize {
+				t.Errorf("thumbnail = %s %dpx, %v, want %s %dpx", format, cfg.Width, err, tt.wantFormat, tt.wantSize)
+			}
+
+			// Same content and settings reuse the cached thumbnail, other settings do not
+			if again, err := galleryThumbnail(source, tt.thumbnails); err != nil || again != thumbPath {
+				t.Errorf("second call = %s, %v, want cached %s", again, err, thumbPath)
+			}
+			other := tt.thumbnails
+			other.MaxSize = 8
+			if resized, err := galleryThumbnail(source, other); err != nil || resized == thumbPath {
+				t.Errorf("other size = %s, %v, want a new thumbnail", resized, err)
+			}
+		})
+	}
+}
+
+// Write square PNG of size pixels to path
+func writeTestImage(t *testing.T, path string, size int) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := range size {
+		img.Set(i, i, color.White)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGalleryThumbnailPixelLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint32
+		wantErr       bool
+	}{
+		{name: "small image", width: 64, height: 64},
+		{name: "above limit", width: 100000, height: 100000, wantErr: true},
+		{name: "long strip above limit", width: 1 << 30, height: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "image")
+			config.Gallery.CacheDir = filepath.Join(dir, "cache")
+			source := writeTestImage(t, filepath.Join(dir, "image", "photo.png"), 64)
+			setPNGSize(t, source, tt.width, tt.height)
+
+			_, err := galleryThumbnail(source, ThumbnailConfig{MaxSize: 32, Format: "jpeg", Quality: 80})
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "too large")) {
+				t.Errorf("galleryThumbnail() = %v, want too large error", err)
+			}
+			if !tt.wantErr && err != nil && strings.Contains(err.Error(), "too large") {
+				t.Errorf("galleryThumbnail() = %v, want image decoded", err)
+			}
+		})
+	}
+}
+
+func TestGalleryLinksEscaped(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		wantHref string
+	}{
+		{name: "plain name", file: "2024/photo.jpg", wantHref: `href="/file/image/2024/photo.jpg"`},
+		{name: "space and hash", file: "2024/a #1.jpg", wantHref: `href="/file/image/2024/a%20%231.jpg"`},
+		{name: "question mark", file: "what?.jpg", wantHref: `href="/file/image/what%3F.jpg"`},
+		{name: "percent", file: "100%.jpg", wantHref: `href="/file/image/100%25.jpg"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "image")
+			touchFiles(t, filepath.Join(dir, "image"), tt.file)
+
+			request := httptest.NewRequest("GET", "/c/image", nil)
+			request.SetPathValue("category", "image")
+			recorder := httptest.NewRecorder()
+			handleGalleryCategory(recorder, request)
+
+			if body := recorder.Body.String(); !strings.Contains(body, tt.wantHref) {
+				t.Errorf("page does not contain %s:\n%s", tt.wantHref, body)
+			}
+		})
+	}
+}
+
+// Rewrite the dimensions in the header of PNG file at path, leaving its pixel data as is
+func setPNGSize(t *testing.T, path string, width, height uint32) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signature (8), chunk length (4), "IHDR" (4), width (4), height (4), rest of IHDR (5), CRC (4)
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
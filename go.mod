@@ -4,4 +4,4 @@ go 1.23.7
 
 require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require gopkg.in/yaml.v2 v2.4.0
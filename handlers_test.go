@@ -0,0 +1,311 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHandleFileMessageSavesToCaptionCategory(t *testing.T) {
+	dir := setupTestBot(t, "books", "docs")
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("chapter one"))
+
+	handleFileMessage(fks, documentMessage("file-1", "draft.pdf", "/books novel.pdf", 11))
+
+	data, err := os.ReadFile(filepath.Join(dir, "books", "novel.pdf"))
+	if err != nil {
+		t.Fatalf("saved file: %v, replies %q", err, fks.texts())
+	}
+	if string(data) != "chapter one" {
+		t.Errorf("saved content = %q", data)
+	}
+	if records := metadata.Records(); len(records) != 1 || records[0].Category != "books" {
+		t.Errorf("metadata records = %+v", records)
+	}
+}
+
+func TestHandleFileMessageUnknownCategory(t *testing.T) {
+	dir := setupTestBot(t, "books")
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	handleFileMessage(fks, documentMessage("file-1", "draft.pdf", "/music song.pdf", 4))
+
+	if !fks.sentContaining("music") {
+		t.Errorf("replies %q do not mention the unknown category", fks.texts())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "books", "song.pdf")); err == nil {
+		t.Error("file saved despite unknown category")
+	}
+}
+
+func TestHandleFileMessageDownloadFailure(t *testing.T) {
+	setupTestBot(t, "books")
+	fks := newFakeSender(t)
+
+	handleFileMessage(fks, documentMessage("missing", "draft.pdf", "/books", 4))
+
+	if !fks.sentContaining("Error") {
+		t.Errorf("replies %q do not report the failure", fks.texts())
+	}
+	if records := metadata.Records(); len(records) != 0 {
+		t.Errorf("metadata records = %+v, want none", records)
+	}
+}
+
+func TestHandleFileMessageConfirmation(t *testing.T) {
+	dir := setupTestBot(t, "books")
+	config.Categories[0].Confirm = true
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	message := documentMessage("file-1", "novel.pdf", "/books", 4)
+	handleFileMessage(fks, message)
+
+	target := filepath.Join(dir, "books", "novel.pdf")
+	if _, err := os.Stat(target); err == nil {
+		t.Fatal("file saved before confirmation")
+	}
+	if !fks.sentContaining("Save 'novel.pdf' to category 'books'?") {
+		t.Fatalf("replies %q do not ask for confirmation", fks.texts())
+	}
+
+	handleCallbackQuery(fks, &tgbotapi.CallbackQuery{
+		ID:      "q1",
+		From:    message.From,
+		Message: &tgbotapi.Message{MessageID: 101, Chat: message.Chat},
+		Data:    callbackConfirm + "yes:" + pendingSaveKey(message.Chat.ID, message.MessageID),
+	})
+
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("file not saved after confirmation: %v, replies %q", err, fks.texts())
+	}
+}
+
+func TestHandleCommandCategories(t *testing.T) {
+	setupTestBot(t, "books", "docs")
+	fks := newFakeSender(t)
+
+	handleCommand(fks, commandMessage("/categories"))
+
+	for _, want := range []string{"/books", "/docs"} {
+		if !fks.sentContaining(want) {
+			t.Errorf("categories reply %q does not list %s", fks.lastText(), want)
+		}
+	}
+}
+
+func TestHandleCommandSelectsCategoryForNextFile(t *testing.T) {
+	dir := setupTestBot(t, "books", "docs")
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	handleCommand(fks, commandMessage("/docs"))
+	handleFileMessage(fks, documentMessage("file-1", "report.txt", "", 4))
+
+	if _, err := os.Stat(filepath.Join(dir, "docs", "report.txt")); err != nil {
+		t.Errorf("file not saved to selected category: %v, replies %q", err, fks.texts())
+	}
+}
+
+func TestHandleConfirmCallbackChecksChosenCategory(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cat *CategoryConfig)
+		wantSaved bool
+		wantName  string // Name the photo is saved under
+		wantReply string
+	}{
+		{name: "accepting category", configure: func(*CategoryConfig) {}, wantSaved: true},
+		{name: "default filename", configure: func(cat *CategoryConfig) { cat.DefaultFilename = "scan_{unix}" }, wantSaved: true, wantName: "scan_1717232707.jpg"},
+		{name: "invalid default filename", configure: func(cat *CategoryConfig) { cat.DefaultFilename = ".." }, wantReply: "Invalid filename"},
+		{name: "read-only", configure: func(cat *CategoryConfig) { cat.ReadOnly = true }, wantReply: "is read-only"},
+		{name: "requires caption", configure: func(cat *CategoryConfig) { cat.RequireCaption = true }, wantReply: "need a name"},
+		{name: "size limit", configure: func(cat *CategoryConfig) { cat.MaxFileSizeBytes = 2 }, wantReply: "File is too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "images")
+			config.CaptionlessPhotos = captionlessPhotoAsk
+			fks := newFakeSender(t)
+			fks.addFile("photo-1", []byte("jpeg"))
+
+			message := &tgbotapi.Message{
+				MessageID: 1,
+				From:      &tgbotapi.User{ID: 1, UserName: "alice"},
+				Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+				Photo:     []tgbotapi.PhotoSize{{FileID: "photo-1", FileUniqueID: "p1", FileSize: 4}},
+				Date:      1717232707,
+			}
+			handleFileMessage(fks, message)
+			if !fks.sentContaining("Which category should") {
+				t.Fatalf("replies %q do not ask for a category", fks.texts())
+			}
+
+			// Category changes while the buttons wait for an answer
+			tt.configure(&config.Categories[0])
+			handleCallbackQuery(fks, &tgbotapi.CallbackQuery{
+				ID:      "q1",
+				From:    message.From,
+				Message: &tgbotapi.Message{MessageID: 101, Chat: message.Chat},
+				Data:    callbackConfirm + "c0:" + pendingSaveKey(message.Chat.ID, message.MessageID),
+			})
+
+			entries, _ := os.ReadDir(filepath.Join(dir, "images"))
+			if saved := len(entries) > 0; saved != tt.wantSaved {
+				t.Errorf("saved = %v, want %v, replies %q", saved, tt.wantSaved, fks.texts())
+			}
+			if tt.wantName != "" && !fileExists(filepath.Join(dir, "images", tt.wantName)) {
+				t.Errorf("%s not saved, replies %q", tt.wantName, fks.texts())
+			}
+			if tt.wantReply != "" && !fks.sentContaining(tt.wantReply) {
+				t.Errorf("replies %q do not contain %q", fks.texts(), tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageNoExtensionRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		caption   string
+		configure func()
+		want      string
+	}{
+		{name: "no category chosen", configure: func() {}, want: noExtensionCategory},
+		{name: "caption category", caption: "/books", configure: func() {}, want: "books"},
+		{name: "pending category", configure: func() { pendingCategories.Put(1, "books", time.Minute) }, want: "books"},
+		{name: "user default", configure: func() { userDefaults[1] = "books" }, want: "books"},
+		{name: "chat default", configure: func() { chatDefaults[1] = "books" }, want: "books"},
+		{name: "filename rule", configure: func() {
+			categoryRules = []categoryRule{{Pattern: regexp.MustCompile(`^README$`), Category: "books"}}
+		}, want: "books"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "books", noExtensionCategory)
+			config.NoExtension = noExtensionRoute
+			tt.configure()
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("read me"))
+
+			handleFileMessage(fks, documentMessage("file-1", "README", tt.caption, 7))
+
+			if !fileExists(filepath.Join(dir, tt.want, "README")) {
+				t.Errorf("README not saved to %s, replies %q", tt.want, fks.texts())
+			}
+		})
+	}
+}
+
+func TestHandleUpdateCategoryCommandWithFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		caption  string
+		wantPath string
+	}{
+		{name: "category command", caption: "/books", wantPath: "books/novel.pdf"},
+		{name: "category command with name", caption: "/books story", wantPath: "books/story.pdf"},
+		{name: "command addressed to the bot", caption: "/books@filebot", wantPath: "books/novel.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "books", "docs")
+			botUsername = "filebot"
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			// Telegram marks the command in the caption, the message has no text
+			message := documentMessage("file-1", "novel.pdf", tt.caption, 4)
+			command := strings.SplitN(tt.caption, " ", 2)[0]
+			message.CaptionEntities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+			handleUpdate(fks, tgbotapi.Update{Message: message})
+
+			if !fileExists(filepath.Join(dir, tt.wantPath)) {
+				t.Errorf("%s not saved, replies %q", tt.wantPath, fks.texts())
+			}
+			if _, ok := pendingCategories.Peek(1); ok {
+				t.Error("command with a file selected a category for the next file")
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageRequireCaption(t *testing.T) {
+	tests := []struct {
+		name      string
+		require   bool
+		caption   string
+		pending   bool
+		wantSaved string // Empty when the file is rejected
+	}{
+		{name: "not required", caption: "/books", wantSaved: "draft.pdf"},
+		{name: "required with name", require: true, caption: "/books novel", wantSaved: "novel.pdf"},
+		{name: "required without name", require: true, caption: "/books"},
+		{name: "required with metadata only", require: true, caption: "/books year=2024"},
+		{name: "required with selected category", require: true, pending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "books")
+			config.Categories[0].RequireCaption = tt.require
+			if tt.pending {
+				pendingCategories.Put(1, "books", time.Minute)
+			}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "draft.pdf", tt.caption, 4))
+
+			if tt.wantSaved == "" {
+				if len(metadata.Records()) != 0 || !fks.sentContaining("need a name") {
+					t.Errorf("file not rejected, replies %q", fks.texts())
+				}
+				return
+			}
+			if !fileExists(filepath.Join(dir, "books", tt.wantSaved)) {
+				t.Errorf("%s not saved, replies %q", tt.wantSaved, fks.texts())
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageUnknownCaptionCategory(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		wantPath  string // Empty when the file is rejected
+		wantReply string
+	}{
+		{name: "reject by default", mode: "", wantReply: "Category 'music' does not exist, the file was not saved. Send it again with one of: books, document"},
+		{name: "reject", mode: unknownCategoryReject, wantReply: "the file was not saved"},
+		{name: "fallback by type", mode: unknownCategoryFallback, wantPath: "document/draft.pdf", wantReply: "choosing the category automatically"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "books", "document")
+			config.UnknownCaptionCategory = tt.mode
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "draft.pdf", "/music", 4))
+
+			if !fks.sentContaining(tt.wantReply) {
+				t.Errorf("replies %q do not contain %q", fks.texts(), tt.wantReply)
+			}
+			saved := len(metadata.Records()) > 0
+			if saved != (tt.wantPath != "") {
+				t.Errorf("saved = %v, want %v", saved, tt.wantPath != "")
+			}
+			if tt.wantPath != "" && !fileExists(filepath.Join(dir, tt.wantPath)) {
+				t.Errorf("%s not saved", tt.wantPath)
+			}
+		})
+	}
+}
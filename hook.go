@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultHookTimeout = time.Minute
+
+// Placeholders substituted in hook command arguments
+var hookPlaceholders = map[string]bool{
+	"{path}":     true,
+	"{category}": true,
+	"{filename}": true,
+	"{user}":     true,
+}
+
+// HookConfig represents a command run after a file is saved to a category
+type HookConfig struct {
+	Command      []string `yaml:"command"`       // Program and arguments, run without a shell
+	Timeout      int      `yaml:"timeout"`       // Seconds before the command is killed
+	NotifyAdmins bool     `yaml:"notify_admins"` // Post failures to the admin chat
+}
+
+// Check that hook command only uses known placeholders
+func validateHook(hook HookConfig) error {
+	for _, arg := range hook.Command {
+		for _, placeholder := range pathPlaceholderPattern.FindAllString(arg, -1) {
+			if !hookPlaceholders[placeholder] {
+				return fmt.Errorf("unknown placeholder %s in hook argument %s", placeholder, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// Render hook command arguments for a saved file
+func renderHookArgs(args []string, path, category string, user *tgbotapi.User) []string {
+	userName := "unknown"
+	if user != nil {
+		userName = fmt.Sprintf("%d", user.ID)
+		if user.UserName != "" {
+			userName = user.UserName
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{path}", path,
+		"{category}", category,
+		"{filename}", hookArgValue(filepath.Base(path)),
+		"{user}", hookArgValue(userName),
+	)
+	rendered := make([]string, len(args))
+	for i, arg := range args {
+		rendered[i] = replacer.Replace(arg)
+	}
+	return rendered
+}
+
+// Prefix sender-chosen value starting with a dash so the command does not read it as an option
+func hookArgValue(value string) string {
+	if strings.HasPrefix(value, "-") {
+		return "./" + value
+	}
+	return value
+}
+
+// Run category hook for saved file in background, logging its exit code and output
+func runCategoryHook(bot Sender, message *tgbotapi.Message, category, path string) {
+	hook := getCategoryConfig(category).Hook
+	if len(hook.Command) == 0 {
+		return
+	}
+	args := renderHookArgs(hook.Command, path, category, message.From)
+
+	timeout := defaultHookTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+
+		if err == nil {
+			log.Printf("Hook for %s in %s finished: %s", path, category, strings.TrimSpace(string(output)))
+			return
+		}
+		log.Printf("Hook for %s in %s failed with exit code %d: %v: %s", path, category, exitCode, err, strings.TrimSpace(string(output)))
+		if hook.NotifyAdmins {
+			notifyAdmin(bot, fmt.Sprintf("Hook failed: %v\nCommand: %s\nFile: %s\nCategory: %s", err, strings.Join(args, " "), path, category))
+		}
+	}()
+}
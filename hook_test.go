@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRenderHookArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		path string
+		user *tgbotapi.User
+		want []string
+	}{
+		{
+			name: "all placeholders",
+			args: []string{"notify", "{path}", "{category}", "{filename}", "{user}"},
+			path: "/srv/docs/report.pdf",
+			user: &tgbotapi.User{ID: 7, UserName: "alice"},
+			want: []string{"notify", "/srv/docs/report.pdf", "docs", "report.pdf", "alice"},
+		},
+		{
+			name: "user ID without username",
+			args: []string{"notify", "{user}"},
+			path: "/srv/docs/report.pdf",
+			user: &tgbotapi.User{ID: 7},
+			want: []string{"notify", "7"},
+		},
+		{
+			name: "unknown user",
+			args: []string{"notify", "{user}"},
+			path: "/srv/docs/report.pdf",
+			want: []string{"notify", "unknown"},
+		},
+		{
+			name: "placeholder inside argument",
+			args: []string{"convert", "{path}", "/srv/thumbs/{filename}"},
+			path: "/srv/docs/photo.jpg",
+			want: []string{"convert", "/srv/docs/photo.jpg", "/srv/thumbs/photo.jpg"},
+		},
+		{
+			name: "filename starting with dash",
+			args: []string{"rm", "{filename}"},
+			path: "/srv/docs/-rf",
+			want: []string{"rm", "./-rf"},
+		},
+		{
+			name: "option-like filename",
+			args: []string{"tar", "{filename}"},
+			path: "/srv/docs/--checkpoint-action=exec=sh",
+			want: []string{"tar", "./--checkpoint-action=exec=sh"},
+		},
+		{
+			name: "user starting with dash",
+			args: []string{"notify", "{user}"},
+			path: "/srv/docs/report.pdf",
+			user: &tgbotapi.User{ID: 7, UserName: "-v"},
+			want: []string{"notify", "./-v"},
+		},
+		{
+			name: "dash inside name",
+			args: []string{"notify", "{filename}"},
+			path: "/srv/docs/report-2024.pdf",
+			want: []string{"notify", "report-2024.pdf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderHookArgs(tt.args, tt.path, "docs", tt.user)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("renderHookArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
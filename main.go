@@ -1,15 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gopkg.in/yaml.v2"
@@ -17,55 +34,508 @@ import (
 
 // Configuration constants
 const (
-	configPath = "./config.yml" // Path to configuration file
+	defaultConfigPath = "./config.yml"       // Path to configuration file unless -config or CONFIG_PATH is set
+	defaultEnvFile    = ".env"               // Env file read unless ENV_FILES is set
+	defaultUserAgent  = "go-tg-file-bot/1.0" // User-Agent sent when downloading files
+
+	noExtensionCategory = "no-extension"         // Category for files without extension awaiting review
+	noExtensionPath     = "./files/no-extension" // Default path for no-extension category
+
+	unsortedCategory = "unsorted"         // Category for captionless photos awaiting triage
+	unsortedPath     = "./files/unsorted" // Default path for unsorted category
+)
+
+// Behaviors for captions naming more than one category, e.g. "/image /document photo.jpg"
+const (
+	extraCategoriesKeep   = "keep"   // Use the first, later tokens become part of the filename
+	extraCategoriesStrip  = "strip"  // Use the first, drop later category tokens from the filename
+	extraCategoriesReject = "reject" // Ask the sender to use a single category
+	extraCategoriesCopy   = "copy"   // Save to the first, link or copy the file into the others
+)
+
+// Behaviors for photos sent without caption when no default category applies
+const (
+	captionlessPhotoSave     = "save"     // Save to the image category like other files
+	captionlessPhotoUnsorted = "unsorted" // Save to the unsorted category for later triage
+	captionlessPhotoAsk      = "ask"      // Ask the sender to pick a category
+)
+
+// Behaviors for files without an extension
+const (
+	noExtensionMime  = "mime"     // Append extension derived from MIME type
+	noExtensionKeep  = "keep"     // Leave filename as is
+	noExtensionRoute = "category" // Route to the no-extension category
+)
+
+// Policies for content identical to an already saved file
+const (
+	duplicateKeepBoth   = "keep-both"   // Save both copies
+	duplicateKeepFirst  = "keep-first"  // Reject the new copy
+	duplicateKeepLatest = "keep-latest" // Replace the old copy and its metadata
+)
+
+// Handling of messages without a file
+const (
+	unsupportedReply   = "reply"   // Reply with guidance
+	unsupportedIgnore  = "ignore"  // Ignore quietly
+	unsupportedPrivate = "private" // Reply in private chats, ignore in groups
+)
+
+// Scopes in which duplicate content is detected
+const (
+	dedupScopeGlobal   = "global"   // Across all categories
+	dedupScopeCategory = "category" // Within the category of the new file
+)
+
+// Scopes in which a saved filename must be unique
+const (
+	uniqueScopeFolder   = "folder"   // Within the resolved target folder, e.g. one date folder
+	uniqueScopeCategory = "category" // Across all folders of the category
+)
+
+// Handling of captions naming an unknown category
+const (
+	unknownCategoryReject   = "reject"   // List valid categories and do not save
+	unknownCategoryFallback = "fallback" // Save with the automatically chosen category and say so
+)
+
+// Storage layouts for saved files
+const (
+	storageLayoutNamed   = "named"   // <root>/<filename>
+	storageLayoutContent = "content" // <root>/<sha256[:2]>/<sha256>, original name kept in metadata
+)
+
+const defaultRetryDelay = time.Second // Delay between retries when not configured
+
+const defaultSuccessTemplate = "File saved successfully!\nCategory: {category}\nLocation: {path}" // Confirmation after saving
+
+const defaultPartialMaxAge = 24 * time.Hour // Unfinished downloads untouched this long are removed
+
+const maxImportFileSize = 10 << 20 // Maximum size of category import file
+
+const maxFilenameLength = 240 // Longer filenames are truncated keeping the extension
+
+const defaultMaxUniqueSuffix = 1000 // Numbered copies tried before falling back to a random suffix
+
+const defaultFilenameHashLength = 6 // Hex characters of content hash added to filenames
+
+// Forward batch defaults
+const (
+	defaultForwardBatchWindow   = 30 * time.Second
+	defaultForwardBatchTemplate = "batch_{date}_{n}"
+)
+
+const maxBotDownloadSize = 20 << 20 // Largest file the Bot API lets bots download
+
+// Kinds of save failures, wrapping the underlying cause
+var (
+	errFileTooBig     = errors.New("file is too big, bots can only download files up to 20 MB")
+	errDownloadFailed = errors.New("download failed")
+	errStorageFailed  = errors.New("cannot write to storage")
+	errDiskFull       = errors.New("storage is full")
+
+	errStorageUnavailable = errors.New("storage unavailable") // Directory missing, unmounted, or not writable
 )
 
+const defaultStorageUnavailableMessage = "Error saving file: storage for category '{category}' is unavailable. Please try again later."
+
 // CategoryConfig represents a category configuration
 type CategoryConfig struct {
-	Name string `yaml:"name"`
-	Path string `yaml:"path"`
+	Name          string `yaml:"name"`
+	Path          string `yaml:"path"`
+	UseExifDate   bool   `yaml:"use_exif_date"`  // Use photo capture date from EXIF as file date
+	MaxConcurrent int    `yaml:"max_concurrent"` // Limit of parallel writes, 0 means unlimited
+	ReadOnly      bool   `yaml:"read_only"`      // Reject changes to files in this category
+
+	MaxFileSizeBytes  int64 `yaml:"max_file_size_bytes"`  // Overrides type and global size limits
+	MinFreeSpaceBytes int64 `yaml:"min_free_space_bytes"` // Overrides the global free space guard, e.g. for another disk
+
+	MaxDownloadBytesPerSec int64 `yaml:"max_download_bytes_per_sec"` // Shared by downloads to this category, on top of the global limit
+
+	Sanitize SanitizeConfig `yaml:"sanitize"` // Added to the global rules, e.g. stricter ones for FAT32 mounts
+
+	Confirm        bool `yaml:"confirm"`         // Ask the sender to confirm before saving
+	RequireCaption bool `yaml:"require_caption"` // Reject files sent without a custom filename
+
+	// Name for files arriving without one, e.g. memo_{date}_{time}; supports {date}, {time}, {unix}
+	DefaultFilename string `yaml:"default_filename"`
+
+	Hook HookConfig `yaml:"hook"` // Command run in background after each save
+
+	Thumbnails ThumbnailConfig `yaml:"thumbnails"` // Gallery thumbnail size and format
+
+	SaveVideoThumbnails bool `yaml:"save_video_thumbnails"` // Also save Telegram's thumbnail of videos as <name>_thumb.jpg
+
+	UniqueScope string `yaml:"unique_scope"` // folder (default) or category, where a filename must not repeat
+}
+
+// DownloadConfig represents settings for fetching files
+type DownloadConfig struct {
+	UserAgent  string            `yaml:"user_agent"`
+	Headers    map[string]string `yaml:"headers"`
+	Retries    int               `yaml:"retries"`     // Extra attempts after a transient failure
+	RetryDelay int               `yaml:"retry_delay"` // Seconds between attempts
+	LogURLs    bool              `yaml:"log_urls"`    // Log download URLs with the bot token redacted
+
+	PartialMaxAgeHours int `yaml:"partial_max_age_hours"` // Unfinished downloads untouched this long are removed
+	URLCacheTTL        int `yaml:"url_cache_ttl"`         // Seconds a download link is reused, negative disables
+	MaxConcurrent      int `yaml:"max_concurrent"`        // Limit of simultaneous downloads, 0 means unlimited
+}
+
+// PhotoConfig represents settings for photos sent as compressed images
+type PhotoConfig struct {
+	WarnCompressed bool `yaml:"warn_compressed"` // Suggest sending photos as files to keep the original
+	SaveAllSizes   bool `yaml:"save_all_sizes"`  // Save every size variant, not only the largest
+}
+
+// SanitizeConfig represents extra rules for cleaning filenames
+type SanitizeConfig struct {
+	Replacement   string `yaml:"replacement"`    // Replaces invalid characters, default _
+	ReplaceSpaces bool   `yaml:"replace_spaces"` // Also replace whitespace
+	StripChars    string `yaml:"strip_chars"`    // Characters removed from filenames
+	StripEmoji    bool   `yaml:"strip_emoji"`
+	MaxLength     int    `yaml:"max_length"` // Filename length limit in bytes, at most 240
+}
+
+// RuleConfig represents a filename pattern routed to a category
+type RuleConfig struct {
+	Pattern  string `yaml:"pattern"` // Regular expression matched against the original filename
+	Category string `yaml:"category"`
+}
+
+// categoryRule represents a compiled filename rule
+type categoryRule struct {
+	Pattern  *regexp.Regexp
+	Category string
 }
 
 // Config represents the application configuration
 type Config struct {
 	Categories []CategoryConfig `yaml:"categories"`
+	Rules      []RuleConfig     `yaml:"rules"`
+	Download   DownloadConfig   `yaml:"download"`
+	Photos     PhotoConfig      `yaml:"photos"`
+	API        APIConfig        `yaml:"api"`
+	Gallery    GalleryConfig    `yaml:"gallery"`
+	Trends     TrendsConfig     `yaml:"trends"`
+
+	HashBlocklist BlocklistConfig `yaml:"hash_blocklist"` // Uploads with these SHA-256 hashes are rejected
+	Async         AsyncConfig     `yaml:"async"`          // Acknowledge files at once and save them in the background
+	Trash         TrashConfig     `yaml:"trash"`          // Move deleted files to a restorable trash
+
+	// Name for files forwarded from channels without their own name, e.g. {channel}_{message_id}
+	ForwardedChannelName string `yaml:"forwarded_channel_name"`
+
+	ForwardBatch ForwardBatchConfig `yaml:"forward_batch"`
+
+	// Seconds state awaiting a user's next action is kept, e.g. a selected category or a save confirmation
+	PendingTimeout int `yaml:"pending_timeout"`
+
+	// Seconds a category selected via /category waits for the next file, overrides PendingTimeout
+	PendingCategoryTimeout int `yaml:"pending_category_timeout"`
+
+	// Behavior for files without extension: mime (default), keep, or category
+	NoExtension string `yaml:"no_extension"`
+
+	// Photos without caption and without a default category: save (default), unsorted, or ask
+	CaptionlessPhotos string `yaml:"captionless_photos"`
+
+	// Route images, videos and audio sent as files to their media category instead of document
+	RouteMediaDocuments bool `yaml:"route_media_documents"`
+	// Route documents with a media file extension, e.g. .mp4, to its media category; checked before the MIME type
+	ExtensionOverridesType bool `yaml:"extension_overrides_type"`
+
+	Admins []int64 `yaml:"admins"` // Telegram user IDs of bot administrators
+
+	AllowedChats    []int64 `yaml:"allowed_chats"`     // Chat IDs the bot works in, empty means everywhere
+	LeaveOtherChats bool    `yaml:"leave_other_chats"` // Leave group chats outside allowed_chats
+
+	AdminChatID         int64 `yaml:"admin_chat_id"`         // Chat receiving error notifications, off when 0
+	AdminNotifyInterval int   `yaml:"admin_notify_interval"` // Minimum seconds between notifications
+
+	UserFolders bool `yaml:"user_folders"` // Save files under <category>/<username>/
+
+	WelcomeKeyboard bool `yaml:"welcome_keyboard"` // Show quick action buttons on /start
+
+	MaxFileSizeBytes int64            `yaml:"max_file_size_bytes"` // Global size limit, 0 means unlimited
+	TypeSizeLimits   map[string]int64 `yaml:"type_size_limits"`    // Size limits by attachment type, e.g. photo
+
+	MinFreeSpaceBytes int64 `yaml:"min_free_space_bytes"` // Reject saves that would leave less free space, 0 disables
+
+	MaxDownloadBytesPerSec int64 `yaml:"max_download_bytes_per_sec"` // Shared by all downloads, 0 means unlimited
+
+	StorageLayout string `yaml:"storage_layout"` // named (default) or content
+	MetadataPath  string `yaml:"metadata_path"`  // Where metadata of saved files is kept
+	BanlistPath   string `yaml:"banlist_path"`   // Where banned user IDs are kept
+	SettingsPath  string `yaml:"settings_path"`  // Where user and chat default categories are kept
+
+	DuplicatePolicy string `yaml:"duplicate_policy"`  // keep-both (default), keep-first, or keep-latest
+	DedupScope      string `yaml:"dedup_scope"`       // global (default) or category
+	DuplicateGrace  int    `yaml:"duplicate_grace"`   // Seconds keep-latest replaces a recent copy, older copies are kept; 0 always replaces
+	MaxUniqueSuffix int    `yaml:"max_unique_suffix"` // Numbered copies tried before a random suffix
+
+	OverwriteOwnFiles bool `yaml:"overwrite_own_files"` // Same name from the same user replaces their earlier file
+
+	FilenameHash       bool `yaml:"filename_hash"`        // Add content hash fragment to filenames
+	FilenameHashLength int  `yaml:"filename_hash_length"` // Hex characters in the fragment
+
+	ConfirmTimeout int `yaml:"confirm_timeout"` // Seconds a save confirmation waits for an answer, overrides PendingTimeout
+
+	// Caption starting with an unknown /category: reject (default) or fallback
+	UnknownCaptionCategory string `yaml:"unknown_caption_category"`
+
+	// Words of the caption used as the filename, 0 means unlimited; the rest is kept as a note tag when CaptionNote is set
+	CaptionMaxWords int  `yaml:"caption_max_words"`
+	CaptionNote     bool `yaml:"caption_note"`
+
+	// Captions naming more than one category: keep (default, later ones become part of the name), strip, reject, or copy
+	ExtraCaptionCategories string `yaml:"extra_caption_categories"`
+
+	// Prefix of category names users may leave out, e.g. cat_ lets /image select cat_image
+	CommandPrefix string `yaml:"command_prefix"`
+
+	// Reaction to messages without a file: reply (default), ignore, or private to reply only in private chats
+	UnsupportedMessages string `yaml:"unsupported_messages"`
+
+	// Confirmation after saving, supports {filename}, {category}, {path}, {size}, {hash}
+	SuccessTemplate  string `yaml:"success_template"`
+	SuccessParseMode string `yaml:"success_parse_mode"` // HTML, Markdown or MarkdownV2; values are escaped for it
+
+	Sanitize SanitizeConfig `yaml:"sanitize"`
+
+	// Seconds before the bot deletes its save confirmations and save errors, 0 keeps them.
+	// Replying /delete to a confirmation only works while it exists.
+	StatusRetention int `yaml:"status_retention"`
+	ErrorRetention  int `yaml:"error_retention"`
+
+	// Reply when a category directory is missing or not writable, supports {category}
+	StorageUnavailableMessage string `yaml:"storage_unavailable_message"`
+	ProbeStorage              bool   `yaml:"probe_storage"` // Check the directory is writable before downloading
+
+	EchoFiles EchoConfig `yaml:"echo_files"` // Send small saved files back for re-sharing
+
+	SplitFiles SplitFilesConfig `yaml:"split_files"` // Join files sent in numbered parts, e.g. .001, .002
+
+	Maintenance MaintenanceConfig `yaml:"maintenance"` // Limits for trash purge and partial download cleanup
+
+	CommandCooldowns map[string]int `yaml:"command_cooldowns"` // Seconds between uses of a command per user, e.g. trends: 60
+
+	// Rejections in a row before the user gets a full explanation of the limits, default 3, negative disables
+	RejectionHelpThreshold int `yaml:"rejection_help_threshold"`
+
+	UpdateLog string `yaml:"update_log"` // off, basic (default) or content to also log message text
+}
+
+// commandUse identifies a command invoked by a user, for cooldowns
+type commandUse struct {
+	UserID  int64
+	Command string
+}
+
+// Sender is the part of the Telegram Bot API used by the handlers
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	GetFileDirectURL(fileID string) (string, error)
+	GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// ForwardBatchConfig represents settings for numbering bursts of forwarded files
+type ForwardBatchConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Window       int    `yaml:"window"`        // Seconds between forwards that continue a batch
+	NameTemplate string `yaml:"name_template"` // Supports {date}, {time} of batch start and {n}
+}
+
+// forwardBatch represents a burst of forwarded files from one user
+type forwardBatch struct {
+	Started time.Time
+	Last    time.Time
+	Count   int
+}
+
+// Placeholders supported in category paths, e.g. /data/{category}/{year}/{month}
+var (
+	pathPlaceholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+	pathPlaceholders       = map[string]bool{"{category}": true, "{year}": true, "{month}": true, "{day}": true}
+)
+
+// Placeholders supported in the forwarded channel filename template
+var channelFilenamePlaceholders = map[string]bool{"{channel}": true, "{message_id}": true, "{date}": true, "{time}": true}
+
+// Characters never allowed in filenames
+const invalidFilenameChars = "\\/:*?\"<>|"
+
+// Matches valid keys of key=value caption metadata
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,31}$`)
+
+// Matches a well-formed bot token: numeric bot ID, colon, 35-character secret
+var botTokenFormat = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]{35}$`)
+
+// Matches bot token in Telegram URLs, e.g. https://api.telegram.org/file/bot<token>/...
+var botTokenPattern = regexp.MustCompile(`bot[0-9]+:[A-Za-z0-9_-]+`)
+
+// Media categories of common file extensions, for documents routed by extension
+var mediaExtensionCategories = map[string]string{
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".gif": "image", ".webp": "image", ".heic": "image", ".bmp": "image", ".tif": "image", ".tiff": "image",
+	".mp4": "video", ".mov": "video", ".mkv": "video", ".avi": "video", ".webm": "video", ".m4v": "video",
+	".mp3": "audio", ".m4a": "audio", ".ogg": "audio", ".oga": "audio", ".opus": "audio", ".flac": "audio", ".wav": "audio", ".aac": "audio",
+}
+
+// Preferred file extensions for common MIME types
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"video/mp4":       ".mp4",
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
 }
 
 // Global variables
 var (
 	config       Config
 	categoryMap  = make(map[string]string) // Map of category name to path
+	categoriesMu sync.RWMutex              // Guards categoryMap and config.Categories, imports change them while saves run
 	userDefaults = make(map[int64]string)  // Map of user ID to default category
+	chatDefaults = make(map[int64]string)  // Map of chat ID to default category
+
+	pendingCategories = newPendingStore[int64, string]()       // Map of user ID to selected category awaiting a file
+	forwardBatches    = newPendingStore[int64, forwardBatch]() // Map of user ID to current forward batch, cleared after the window
+
+	categoryRules []categoryRule // Compiled filename rules, in config order
+
+	lastCommandUse = make(map[commandUse]time.Time) // Last invocation of commands with a cooldown
+
+	botUsername string // Username of the bot, for mentions in captions
+
+	envFileSources = make(map[string]string) // Map of variable name to env file that set it
+
+	categorySemaphoresMu sync.Mutex
+	categorySemaphores   = make(map[string]chan struct{}) // Map of category to write slots
+	downloadSemaphore    chan struct{}                    // Download slots shared by all categories
+
+	storageNamesMu sync.Mutex // Held while a save picks a free name and takes it
+
+	downloadingMu   sync.Mutex
+	downloadingCond = sync.NewCond(&downloadingMu)
+	downloading     = make(map[string]bool) // Set of file IDs being downloaded into their partial file
 )
 
 func main() {
-	// Try to get bot token from .env file first, then fall back to environment variable
-	botToken := readBotTokenFromEnvFile()
+	configFlag := flag.String("config", "", "Path to configuration file (overrides CONFIG_PATH)")
+	flag.Parse()
+
+	// Env files override the environment, so a token in .env wins over TELEGRAM_BOT_TOKEN
+	loadEnvFiles()
+	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if botToken == "" {
-		botToken = os.Getenv("TELEGRAM_BOT_TOKEN")
-		if botToken == "" {
-			log.Fatal("TELEGRAM_BOT_TOKEN not found in .env file or environment variables")
-		}
+		log.Fatal("TELEGRAM_BOT_TOKEN not found in .env file or environment variables")
 	}
+	if err := validateBotToken(botToken); err != nil {
+		log.Fatalf("Invalid TELEGRAM_BOT_TOKEN from %s: %v", envSource("TELEGRAM_BOT_TOKEN"), err)
+	}
+	setConfigSource("TELEGRAM_BOT_TOKEN", envSource("TELEGRAM_BOT_TOKEN"))
 
 	// Load configuration
-	if err := loadConfig(); err != nil {
-		log.Printf("Error loading config: %v. Using default categories.", err)
-		setupDefaultCategories()
+	configPath := resolveConfigPath(*configFlag)
+	log.Printf("Using config path %s", configPath)
+	if err := loadConfig(configPath); err != nil {
+		if envCategories := os.Getenv("CATEGORIES"); envCategories != "" {
+			categories, parseErr := parseCategoriesEnv(envCategories)
+			if parseErr != nil {
+				log.Fatalf("Error parsing CATEGORIES environment variable: %v", parseErr)
+			}
+			log.Printf("Error loading config %s: %v. Using categories from CATEGORIES environment variable.", configPath, err)
+			setupCategories(categories, "environment")
+			for _, cat := range categories {
+				setConfigSource("categories."+cat.Name, "CATEGORIES from "+envSource("CATEGORIES"))
+			}
+		} else if isStrictConfig() {
+			log.Fatalf("Error loading config %s: %v. STRICT_CONFIG is set, refusing to use default categories.", configPath, err)
+		} else {
+			log.Printf("Error loading config %s: %v. Using default categories.", configPath, err)
+			setupDefaultCategories()
+		}
+	}
+
+	// Register review category when files without extension are routed there
+	if config.NoExtension == noExtensionRoute {
+		ensureCategory(noExtensionCategory, noExtensionPath)
+	}
+
+	// Register triage category when captionless photos are routed there
+	if config.CaptionlessPhotos == captionlessPhotoUnsorted {
+		ensureCategory(unsortedCategory, unsortedPath)
+	}
+
+	logConfigSources(configPath)
+
+	// Load metadata of saved files
+	metadataPath := config.MetadataPath
+	if metadataPath == "" {
+		metadataPath = defaultMetadataPath
+	}
+	if err := loadMetadata(metadataPath); err != nil {
+		log.Fatalf("Error loading metadata from %s: %v", metadataPath, err)
+	}
+
+	// Load banned users
+	if config.BanlistPath != "" {
+		banlistPath = config.BanlistPath
+	}
+	if err := loadBanlist(banlistPath); err != nil {
+		log.Fatalf("Error loading banlist from %s: %v", banlistPath, err)
+	}
+
+	// Load user and chat preferences
+	if config.SettingsPath != "" {
+		settingsPath = config.SettingsPath
+	}
+	if err := loadSettings(settingsPath); err != nil {
+		log.Fatalf("Error loading settings from %s: %v", settingsPath, err)
 	}
 
 	// Create bot instance
 	bot, err := tgbotapi.NewBotAPI(botToken)
 	if err != nil {
-		log.Fatal("Error creating bot:", err)
+		log.Fatal("Error creating bot: ", redactError(err))
 	}
 
 	// Uncomment for debugging
 	// bot.Debug = true
 	log.Printf("Authorized on account %s", bot.Self.UserName)
+	botUsername = bot.Self.UserName
 
 	// Create storage directories
 	createStorageDirectories()
+	cleanupPartialDownloads()
+
+	// Record daily usage snapshots
+	startTrendsSnapshots()
+
+	// Load hashes of rejected files
+	startHashBlocklist()
+
+	// Save files in the background when enabled
+	startSaveWorkers(bot)
+
+	// Purge expired files from trash
+	startTrashPurge()
+
+	// Clear selections and confirmations nobody followed up on
+	startPendingSweeper(bot)
+
+	// Start optional JSON API
+	if config.API.Enabled {
+		go startAPIServer()
+	}
+
+	// Start optional web gallery
+	if config.Gallery.Enabled {
+		go startGalleryServer()
+	}
 
 	// Configure update settings
 	updateConfig := tgbotapi.NewUpdate(0)
@@ -74,32 +544,90 @@ func main() {
 	// Start receiving updates
 	updates := bot.GetUpdatesChan(updateConfig)
 
+	// Stop receiving on interrupt, files already queued are still saved
+	stopSignals := make(chan os.Signal, 1)
+	signal.Notify(stopSignals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopSignals
+		log.Printf("Stopping, no new updates are received")
+		bot.StopReceivingUpdates()
+	}()
+
 	// Handle updates
 	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+		logUpdate(update, func() { handleUpdate(bot, update) })
+	}
+	stopSaveWorkers()
+}
 
-		// Handle commands
-		if update.Message.IsCommand() {
-			handleCommand(bot, update.Message)
-			continue
+// Dispatch update to the handler for its kind
+func handleUpdate(bot Sender, update tgbotapi.Update) {
+	// Handle inline keyboard buttons
+	if update.CallbackQuery != nil {
+		if update.CallbackQuery.Message != nil && !isChatAllowed(update.CallbackQuery.Message.Chat.ID) {
+			return
 		}
-
-		// Handle file messages
-		if hasAttachment(update.Message) {
-			handleFileMessage(bot, update.Message)
-		} else if update.Message.Text != "" {
-			// Handle text messages that are not commands
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Please send a file with an optional category in caption. Example: /image vacation.jpg")
-			bot.Send(msg)
+		if isBanned(update.CallbackQuery.From.ID) {
+			log.Printf("Ignoring callback from banned user %d", update.CallbackQuery.From.ID)
+			return
 		}
+		handleCallbackQuery(bot, update.CallbackQuery)
+		return
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	// Ignore chats outside the allowlist
+	if !isChatAllowed(update.Message.Chat.ID) {
+		rejectChat(bot, update.Message.Chat)
+		return
+	}
+
+	// Ignore banned users entirely
+	if update.Message.From != nil && isBanned(update.Message.From.ID) {
+		log.Printf("Ignoring message from banned user %d", update.Message.From.ID)
+		return
+	}
+
+	// Handle commands, a command sent with a file is its caption and saves the file below
+	if update.Message.IsCommand() {
+		handleCommand(bot, update.Message)
+		return
+	}
+
+	// Handle file messages
+	if hasAttachment(update.Message) {
+		handleFileMessage(bot, update.Message)
+	} else {
+		handleUnsupportedMessage(bot, update.Message)
+	}
+}
+
+// Resolve config path from -config flag, CONFIG_PATH env var, or default
+func resolveConfigPath(flagValue string) string {
+	if flagValue != "" {
+		setConfigSource("config_path", "-config flag")
+		return flagValue
 	}
+	if envPath := os.Getenv("CONFIG_PATH"); envPath != "" {
+		setConfigSource("config_path", "CONFIG_PATH from "+envSource("CONFIG_PATH"))
+		return envPath
+	}
+	return defaultConfigPath
 }
 
 // Load configuration from YAML file
-func loadConfig() error {
-	data, err := ioutil.ReadFile(configPath)
+func loadConfig(path string) error {
+	// Fall back to compressed config when plain one is missing
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(path + ".gz"); err == nil {
+			path += ".gz"
+		}
+	}
+
+	data, err := readConfigFile(path)
 	if err != nil {
 		return err
 	}
@@ -107,358 +635,2466 @@ func loadConfig() error {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return err
 	}
+	if err := recordConfigFileSources(path, data); err != nil {
+		return err
+	}
+
+	if config.Maintenance.PauseHours != "" {
+		if _, _, err := parsePauseHours(config.Maintenance.PauseHours); err != nil {
+			return fmt.Errorf("maintenance: %w", err)
+		}
+	}
+
+	switch config.UpdateLog {
+	case "", updateLogOff, updateLogBasic, updateLogContent:
+	default:
+		return fmt.Errorf("unknown update_log level %q", config.UpdateLog)
+	}
+
+	if strings.ContainsAny(config.Sanitize.Replacement, invalidFilenameChars) {
+		return fmt.Errorf("sanitize replacement %q contains characters invalid in filenames", config.Sanitize.Replacement)
+	}
+
+	for _, placeholder := range pathPlaceholderPattern.FindAllString(config.ForwardedChannelName, -1) {
+		if !channelFilenamePlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s in forwarded_channel_name", placeholder)
+		}
+	}
+
+	// Path templates must only use known placeholders
+	for _, cat := range config.Categories {
+		if err := validatePathTemplate(cat.Path); err != nil {
+			return fmt.Errorf("category %s: %w", cat.Name, err)
+		}
+		if err := validateHook(cat.Hook); err != nil {
+			return fmt.Errorf("category %s: %w", cat.Name, err)
+		}
+		if err := validateThumbnailConfig(cat.Thumbnails); err != nil {
+			return fmt.Errorf("category %s: %w", cat.Name, err)
+		}
+		if cat.UniqueScope != "" && cat.UniqueScope != uniqueScopeFolder && cat.UniqueScope != uniqueScopeCategory {
+			return fmt.Errorf("category %s: unknown unique_scope %q, expected folder or category", cat.Name, cat.UniqueScope)
+		}
+		if strings.ContainsAny(cat.Sanitize.Replacement, invalidFilenameChars) {
+			return fmt.Errorf("category %s: sanitize replacement %q contains characters invalid in filenames", cat.Name, cat.Sanitize.Replacement)
+		}
+	}
+
+	log.Printf("Loaded configuration from %s", path)
 
 	// Build category map
+	categoriesMu.Lock()
 	for _, cat := range config.Categories {
 		categoryMap[cat.Name] = cat.Path
 		log.Printf("Loaded category: %s -> %s", cat.Name, cat.Path)
 	}
+	categoriesMu.Unlock()
+
+	// Rules refer to categories, so compile them once the map is built
+	compileCategoryRules()
 
 	return nil
 }
 
-// Setup default categories if config file is not available
-func setupDefaultCategories() {
-	defaultCategories := []CategoryConfig{
-		{Name: "document", Path: "./files/documents"},
-		{Name: "image", Path: "./files/images"},
-		{Name: "video", Path: "./files/videos"},
-		{Name: "audio", Path: "./files/audio"},
-		{Name: "other", Path: "./files/misc"},
+// Check that path template only uses known placeholders
+func validatePathTemplate(path string) error {
+	for _, placeholder := range pathPlaceholderPattern.FindAllString(path, -1) {
+		if !pathPlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s in path %s", placeholder, path)
+		}
 	}
 
-	config.Categories = defaultCategories
+	// Date folders above {category} would mix all categories under one root, their files could not be told apart
+	if category := strings.Index(path, "{category}"); category > 0 && strings.Contains(path[:category], "{") {
+		return fmt.Errorf("{category} must come before {year}, {month} and {day} in path %s", path)
+	}
+	return nil
+}
 
-	// Build category map
-	for _, cat := range defaultCategories {
-		categoryMap[cat.Name] = cat.Path
-		log.Printf("Using default category: %s -> %s", cat.Name, cat.Path)
+// Render category path template for a file saved at date
+func renderPathTemplate(path, category string, date time.Time) string {
+	if !strings.Contains(path, "{") {
+		return path
 	}
+	return strings.NewReplacer(
+		"{category}", category,
+		"{year}", date.Format("2006"),
+		"{month}", date.Format("01"),
+		"{day}", date.Format("02"),
+	).Replace(path)
 }
 
-// Check if message has any file attachment
-func hasAttachment(message *tgbotapi.Message) bool {
-	return message.Document != nil || len(message.Photo) > 0 || message.Video != nil ||
-		message.Audio != nil || message.Voice != nil || message.VideoNote != nil
+// Get directory holding all files of category, the part of its path before any date placeholder
+func categoryRoot(category string) string {
+	root, _ := categoryLocation(category)
+	return root
 }
 
-// Handle bot commands
-func handleCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	cmd := message.Command()
-	args := message.CommandArguments()
+// Get category root and the name its folders there start with, set when a placeholder sits inside
+// a folder name, e.g. img_ for ./files/img_{year}, since the root is then shared with other folders
+func categoryLocation(category string) (root, folderPrefix string) {
+	path := strings.ReplaceAll(getStoragePath(category), "{category}", category)
+	i := strings.Index(path, "{")
+	if i < 0 {
+		return path, ""
+	}
+	prefix := path[:i]
+	if prefix == "" || os.IsPathSeparator(prefix[len(prefix)-1]) {
+		return filepath.Dir(prefix), ""
+	}
+	return filepath.Dir(prefix), filepath.Base(prefix)
+}
 
-	switch cmd {
-	case "start":
-		sendStartMessage(bot, message)
-	case "help":
-		sendHelpMessage(bot, message)
-	case "categories":
-		sendCategoriesMessage(bot, message)
-	case "setdefault":
-		handleSetDefaultCommand(bot, message, args)
-	case "unsetdefault":
-		handleUnsetDefaultCommand(bot, message)
-	default:
-		// Check if command is a category name
-		if path, exists := categoryMap[cmd]; exists {
-			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Selected category: %s (path: %s)\nNow send me a file to save it in this category.", cmd, path))
-			bot.Send(msg)
-			return
-		}
+// Check if entry directly in the category root belongs to the category, other folders may share the root
+func ownsRootEntry(folderPrefix string, entry fs.DirEntry) bool {
+	return folderPrefix == "" || entry.IsDir() && strings.HasPrefix(entry.Name(), folderPrefix)
+}
 
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Unknown command. Type /help for available commands.")
-		bot.Send(msg)
+// Compile filename rules, skipping invalid patterns and unknown categories
+func compileCategoryRules() {
+	categoryRules = nil
+	for _, rule := range config.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Invalid rule pattern %q: %v", rule.Pattern, err)
+			continue
+		}
+		if _, exists := categoryPath(rule.Category); !exists {
+			log.Printf("Rule %q refers to unknown category %q", rule.Pattern, rule.Category)
+			continue
+		}
+		categoryRules = append(categoryRules, categoryRule{Pattern: pattern, Category: rule.Category})
+	}
+}
+
+// Find category of the first rule matching filename
+func matchCategoryRule(filename string) string {
+	for _, rule := range categoryRules {
+		if rule.Pattern.MatchString(filename) {
+			return rule.Category
+		}
+	}
+	return ""
+}
+
+// Check if a missing or unreadable config must be fatal
+func isStrictConfig() bool {
+	strict, _ := strconv.ParseBool(os.Getenv("STRICT_CONFIG"))
+	return strict
+}
+
+// Read configuration file, decompressing gzip files detected by extension
+func readConfigFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(path, ".gz") {
+		return gunzipData(path, data)
+	}
+	return data, nil
+}
+
+// Decompress gzip data, name is used for error messages
+func gunzipData(name string, data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing %s: %w", name, err)
+	}
+	return decompressed, nil
+}
+
+// Merge categories into configuration, updating paths of existing ones
+func mergeCategories(categories []CategoryConfig) (added, updated int) {
+	for _, cat := range categories {
+		if cat.Name == "" || cat.Path == "" {
+			continue
+		}
+		if err := validatePathTemplate(cat.Path); err != nil {
+			log.Printf("Skipping imported category %s: %v", cat.Name, err)
+			continue
+		}
+
+		// Saves in progress may hold the current list, so a changed copy replaces it
+		categoriesMu.Lock()
+		merged := slices.Clone(config.Categories)
+		i := slices.IndexFunc(merged, func(c CategoryConfig) bool { return c.Name == cat.Name })
+		if i >= 0 {
+			merged[i] = cat
+			updated++
+		} else {
+			merged = append(merged, cat)
+			added++
+		}
+		config.Categories = merged
+		categoryMap[cat.Name] = cat.Path
+		categoriesMu.Unlock()
+
+		if err := os.MkdirAll(categoryRoot(cat.Name), 0755); err != nil {
+			log.Printf("Error creating directory %s: %v", cat.Path, err)
+		}
+		log.Printf("Imported category: %s -> %s", cat.Name, cat.Path)
+	}
+	return added, updated
+}
+
+// Setup default categories if config file is not available
+func setupDefaultCategories() {
+	defaultCategories := []CategoryConfig{
+		{Name: "document", Path: "./files/documents"},
+		{Name: "image", Path: "./files/images"},
+		{Name: "video", Path: "./files/videos"},
+		{Name: "audio", Path: "./files/audio"},
+		{Name: "other", Path: "./files/misc"},
+	}
+
+	setupCategories(defaultCategories, "default")
+}
+
+// Use categories from a source other than the config file
+func setupCategories(categories []CategoryConfig, source string) {
+	categoriesMu.Lock()
+	defer categoriesMu.Unlock()
+
+	config.Categories = categories
+
+	// Build category map
+	for _, cat := range categories {
+		categoryMap[cat.Name] = cat.Path
+		log.Printf("Using %s category: %s -> %s", source, cat.Name, cat.Path)
+	}
+}
+
+// Parse categories in "name=path;name=path" format
+func parseCategoriesEnv(value string) ([]CategoryConfig, error) {
+	var categories []CategoryConfig
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("entry %q is not in name=path format", entry)
+		}
+		name, path := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "" || path == "" {
+			return nil, fmt.Errorf("entry %q has empty name or path", entry)
+		}
+		if strings.ContainsAny(name, " /") {
+			return nil, fmt.Errorf("category name %q must not contain spaces or slashes", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("category %q is defined more than once", name)
+		}
+		if err := validatePathTemplate(path); err != nil {
+			return nil, fmt.Errorf("category %s: %w", name, err)
+		}
+		seen[name] = true
+
+		categories = append(categories, CategoryConfig{Name: name, Path: path})
+	}
+
+	if len(categories) == 0 {
+		return nil, errors.New("no categories defined")
+	}
+	return categories, nil
+}
+
+// Join caption words into a filename of at most CaptionMaxWords words, returning the remaining words separately
+func capCaptionWords(parts []string) (string, string) {
+	var words []string
+	for _, part := range parts {
+		if part != "" {
+			words = append(words, part)
+		}
+	}
+	if config.CaptionMaxWords <= 0 || len(words) <= config.CaptionMaxWords {
+		return strings.Join(parts, " "), ""
+	}
+	return strings.Join(words[:config.CaptionMaxWords], " "), strings.Join(words[config.CaptionMaxWords:], " ")
+}
+
+// Split caption into text and key=value metadata, values may be double-quoted
+func parseCaptionMetadata(caption string) (string, map[string]string) {
+	var text []string
+	var tags map[string]string
+
+	rest := caption
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			break
+		}
+
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			end = len(rest)
+		}
+		token := rest[:end]
+
+		key, value, found := strings.Cut(token, "=")
+		if !found || !metadataKeyPattern.MatchString(key) {
+			text = append(text, token)
+			rest = rest[end:]
+			continue
+		}
+
+		// Quoted value runs to the closing quote and may contain spaces
+		if strings.HasPrefix(value, "\"") {
+			valueStart := len(key) + 2
+			if closing := strings.IndexByte(rest[valueStart:], '"'); closing >= 0 {
+				value = rest[valueStart : valueStart+closing]
+				end = valueStart + closing + 1
+			} else {
+				value = rest[valueStart:]
+				end = len(rest)
+			}
+		}
+
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[strings.ToLower(key)] = value
+		rest = rest[end:]
+	}
+
+	return strings.Join(text, " "), tags
+}
+
+// Turn captions addressing the bot, "@bot image name" or "/image@bot name", into "/image name"
+func stripBotMention(caption string) string {
+	if botUsername == "" {
+		return caption
+	}
+
+	first, rest, _ := strings.Cut(caption, " ")
+	if strings.EqualFold(first, "@"+botUsername) {
+		rest = strings.TrimLeft(rest, " ")
+		if rest != "" && !strings.HasPrefix(rest, "/") {
+			rest = "/" + rest
+		}
+		return rest
+	}
+
+	// Command form with the bot name appended, as Telegram suggests in groups
+	if command, mention, found := strings.Cut(first, "@"); found && strings.HasPrefix(command, "/") && strings.EqualFold(mention, botUsername) {
+		return strings.TrimRight(command+" "+rest, " ")
+	}
+	return caption
+}
+
+// Get configured categories, the list is replaced rather than changed in place when categories change
+func getCategories() []CategoryConfig {
+	categoriesMu.RLock()
+	defer categoriesMu.RUnlock()
+	return config.Categories
+}
+
+// Get path template of category
+func categoryPath(name string) (string, bool) {
+	categoriesMu.RLock()
+	defer categoriesMu.RUnlock()
+	path, ok := categoryMap[name]
+	return path, ok
+}
+
+// Get configuration for category by name
+func getCategoryConfig(name string) CategoryConfig {
+	for _, cat := range getCategories() {
+		if cat.Name == name {
+			return cat
+		}
+	}
+	return CategoryConfig{Name: name}
+}
+
+// Add category if it is not configured
+func ensureCategory(name, path string) {
+	categoriesMu.Lock()
+	defer categoriesMu.Unlock()
+
+	if _, exists := categoryMap[name]; exists {
+		return
+	}
+	config.Categories = append(slices.Clip(config.Categories), CategoryConfig{Name: name, Path: path})
+	categoryMap[name] = path
+	log.Printf("Using default category: %s -> %s", name, path)
+}
+
+// Get kind of message that carries no file, empty for service messages such as joins
+func unsupportedMessageType(message *tgbotapi.Message) string {
+	switch {
+	case message.Text != "":
+		return "text"
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Animation != nil:
+		return "animation"
+	case message.Poll != nil:
+		return "poll"
+	case message.Dice != nil:
+		return "dice"
+	case message.Game != nil:
+		return "game"
+	case message.Venue != nil:
+		return "venue"
+	case message.Location != nil:
+		return "location"
+	case message.Contact != nil:
+		return "contact"
+	default:
+		return ""
+	}
+}
+
+// Reply with guidance to a message without a file, unless configured to ignore it
+func handleUnsupportedMessage(bot Sender, message *tgbotapi.Message) {
+	kind := unsupportedMessageType(message)
+	if kind == "" {
+		return
+	}
+
+	switch config.UnsupportedMessages {
+	case unsupportedIgnore:
+		return
+	case unsupportedPrivate:
+		if !message.Chat.IsPrivate() {
+			return
+		}
+	}
+
+	text := "Please send a file with an optional category in caption. Example: /image vacation.jpg"
+	if kind != "text" {
+		text = fmt.Sprintf("Messages of type %s are not supported. %s", kind, text)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	bot.Send(msg)
+	noteRejection(bot, message)
+}
+
+// Check command cooldown of the sender and record the invocation, replying when it must wait
+func checkCommandCooldown(bot Sender, message *tgbotapi.Message, cmd string) bool {
+	seconds := config.CommandCooldowns[cmd]
+	if seconds <= 0 || message.From == nil || isAdmin(message.From.ID) {
+		return true
+	}
+
+	key := commandUse{UserID: message.From.ID, Command: cmd}
+	now := time.Now()
+	if last, ok := lastCommandUse[key]; ok {
+		if wait := last.Add(time.Duration(seconds) * time.Second).Sub(now); wait > 0 {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Please wait %d seconds before using /%s again.", int(wait.Seconds())+1, cmd))
+			bot.Send(msg)
+			noteRejection(bot, message)
+			return false
+		}
+	}
+	lastCommandUse[key] = now
+	return true
+}
+
+// Check if message has any file attachment
+func hasAttachment(message *tgbotapi.Message) bool {
+	return message.Document != nil || len(message.Photo) > 0 || message.Video != nil ||
+		message.Audio != nil || message.Voice != nil || message.VideoNote != nil
+}
+
+// Handle bot commands
+func handleCommand(bot Sender, message *tgbotapi.Message) {
+	cmd := message.Command()
+	args := message.CommandArguments()
+
+	if !checkCommandCooldown(bot, message, cmd) {
+		return
+	}
+
+	switch cmd {
+	case "start":
+		sendStartMessage(bot, message)
+	case "help":
+		sendHelpMessage(bot, message)
+	case "categories":
+		sendCategoriesMessage(bot, message)
+	case "setdefault":
+		handleSetDefaultCommand(bot, message, args)
+	case "unsetdefault":
+		handleUnsetDefaultCommand(bot, message)
+	case "settings":
+		handleSettingsCommand(bot, message)
+	case "setchatdefault":
+		handleSetChatDefaultCommand(bot, message, args)
+	case "importcategories":
+		handleImportCategoriesCommand(bot, message)
+	case "where":
+		handleWhereCommand(bot, message, args)
+	case "trends":
+		sendTrendsMessage(bot, message)
+	case "delete":
+		handleDeleteCommand(bot, message)
+	case "restore":
+		handleRestoreCommand(bot, message, args)
+	case "exportmetadata":
+		handleExportMetadataCommand(bot, message)
+	case "usage":
+		handleUsageCommand(bot, message, args)
+	case "ban":
+		handleBanCommand(bot, message, args)
+	case "unban":
+		handleUnbanCommand(bot, message, args)
+	default:
+		// Check if command is a category name or number
+		if name, exists := resolveCategorySelector(cmd); exists {
+			cmd = name
+			path, _ := categoryPath(name)
+			// Category command replying to a save confirmation moves the file there
+			if message.ReplyToMessage != nil && handleMoveByReply(bot, message, name) {
+				return
+			}
+
+			if !checkCategoryWritable(bot, message, cmd) {
+				return
+			}
+			timeout := pendingTimeout(config.PendingCategoryTimeout)
+			pendingCategories.Put(message.From.ID, cmd, timeout)
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Selected category: %s (path: %s)\nNow send me a file within %s to save it in this category.", cmd, path, timeout))
+			bot.Send(msg)
+			return
+		}
+
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Unknown command. Type /help for available commands.")
+		bot.Send(msg)
+	}
+}
+
+// Send welcome message
+func sendStartMessage(bot Sender, message *tgbotapi.Message) {
+	welcomeText := fmt.Sprintf("Welcome, %s! I'm a file saving bot. Send me files and I'll save them for you.\n\nUse /help to see available commands.", message.From.FirstName)
+	msg := tgbotapi.NewMessage(message.Chat.ID, welcomeText)
+	if config.WelcomeKeyboard {
+		msg.ReplyMarkup = welcomeKeyboard()
+	}
+	bot.Send(msg)
+}
+
+// Send help message
+func sendHelpMessage(bot Sender, message *tgbotapi.Message) {
+	helpText := `
+Available commands:
+/start - Start the bot
+/help - Show this help message
+/categories - List available file categories
+/setdefault [category] - Set default category for saving files
+/unsetdefault - Remove default category setting
+/settings - Show and change your preferences
+/setchatdefault [category] - Set default category for this chat (admins only in groups)
+/category - Select a category for the next file you send
+/importcategories - Reply to a YAML file to import categories (admins only)
+/where [category] [filename] - Show where a file would be saved
+/trends - Show storage growth per category over the last week and free disk space
+/delete - Reply to a file's confirmation message to delete the file
+/category - Reply to a file's confirmation message to move the file to that category
+/restore [filename] - Restore a deleted file from trash, or list trash without a filename
+/exportmetadata - Get metadata of all saved files as CSV (admins only)
+/usage [page|all] - Show files and bytes stored per user, largest first (admins only)
+/ban [userID], /unban [userID] - Ban or unban a user (admins only)
+
+To save a file with a specific category, send the file with a caption in the format: 
+/category filename
+
+Example: /image vacation.jpg
+
+In groups with several bots, address this one with @botname image vacation.jpg or /image@botname vacation.jpg
+
+Add key=value pairs to the caption to attach metadata, e.g. /document contract.pdf client=acme note="signed copy"
+
+If no category is specified, the category is chosen in this order:
+1. The category you just selected with /category
+2. Your default category (/setdefault)
+3. The chat's default category (/setchatdefault)
+4. The first filename rule that matches
+5. Automatically based on file type
+`
+	msg := tgbotapi.NewMessage(message.Chat.ID, helpText)
+	bot.Send(msg)
+}
+
+// Send categories message
+func sendCategoriesMessage(bot Sender, message *tgbotapi.Message) {
+	categoriesText := "Available categories for file organization:\n"
+	for i, cat := range getCategories() {
+		categoriesText += fmt.Sprintf("%d. /%s - Save file to %s folder\n", i+1, categoryCommand(cat.Name), cat.Path)
+	}
+	categoriesText += "\nYou can also use the number instead of the name, e.g. /1 filename"
+	msg := tgbotapi.NewMessage(message.Chat.ID, categoriesText)
+	bot.Send(msg)
+}
+
+// Handle set default category command
+func handleSetDefaultCommand(bot Sender, message *tgbotapi.Message, args string) {
+	if args == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Please specify a category. Usage: /setdefault [category]")
+		bot.Send(msg)
+		return
+	}
+
+	// Check if category exists
+	name, exists := resolveCategorySelector(args)
+	if !exists {
+		sendUnknownCategoryMessage(bot, message, args)
+		return
+	}
+	args = name
+
+	// Set default category for user
+	userDefaults[message.From.ID] = args
+	if err := saveSettings(); err != nil {
+		log.Printf("Error saving settings: %v", err)
+	}
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Default category set to '%s'. All your files will be saved to this category unless specified otherwise.", args),
+	)
+	bot.Send(msg)
+}
+
+// Send message listing available categories for an unknown category
+func sendUnknownCategoryMessage(bot Sender, message *tgbotapi.Message, category string) {
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Category '%s' does not exist. Available categories: %s", category, categoryNames()),
+	)
+	bot.Send(msg)
+}
+
+// Resolve category name, name without command prefix, or 1-based index in /categories order to a category name
+func resolveCategorySelector(selector string) (string, bool) {
+	if _, exists := categoryPath(selector); exists {
+		return selector, true
+	}
+	if _, exists := categoryPath(config.CommandPrefix + selector); exists && config.CommandPrefix != "" {
+		return config.CommandPrefix + selector, true
+	}
+	categories := getCategories()
+	if index, err := strconv.Atoi(selector); err == nil && index >= 1 && index <= len(categories) {
+		return categories[index-1].Name, true
+	}
+	return "", false
+}
+
+// Get name users type for category, without the configured command prefix
+func categoryCommand(name string) string {
+	return strings.TrimPrefix(name, config.CommandPrefix)
+}
+
+// Get comma-separated names of configured categories
+func categoryNames() string {
+	categories := getCategories()
+	names := make([]string, 0, len(categories))
+	for _, cat := range categories {
+		names = append(names, categoryCommand(cat.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Handle set chat default category command
+func handleSetChatDefaultCommand(bot Sender, message *tgbotapi.Message, args string) {
+	if args == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Please specify a category. Usage: /setchatdefault [category]")
+		bot.Send(msg)
+		return
+	}
+
+	// Only chat admins may change the default in groups
+	if !message.Chat.IsPrivate() && !isChatAdmin(bot, message.Chat.ID, message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Only chat administrators can set the chat default category.")
+		bot.Send(msg)
+		return
+	}
+
+	// Check if category exists
+	name, exists := resolveCategorySelector(args)
+	if !exists {
+		sendUnknownCategoryMessage(bot, message, args)
+		return
+	}
+	args = name
+
+	// Set default category for chat
+	chatDefaults[message.Chat.ID] = args
+	if err := saveSettings(); err != nil {
+		log.Printf("Error saving settings: %v", err)
+	}
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Chat default category set to '%s'. Files sent here will be saved to this category unless a personal default or caption category is used.", args),
+	)
+	bot.Send(msg)
+}
+
+// Check if user is an administrator or creator of the chat
+func isChatAdmin(bot Sender, chatID, userID int64) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		log.Printf("Error getting chat member %d in chat %d: %v", userID, chatID, redactError(err))
+		return false
+	}
+	return member.IsAdministrator() || member.IsCreator()
+}
+
+// Handle preview of where a file would be saved
+func handleWhereCommand(bot Sender, message *tgbotapi.Message, args string) {
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Please specify a filename. Usage: /where [category] [filename]")
+		bot.Send(msg)
+		return
+	}
+
+	// First argument is a category if it matches one
+	category := ""
+	if name, exists := resolveCategorySelector(strings.TrimPrefix(parts[0], "/")); exists && len(parts) > 1 {
+		category = name
+		parts = parts[1:]
+	}
+	filename := strings.Join(parts, " ")
+
+	if category == "" {
+		category = resolveDefaultCategory(message.From.ID, message.Chat.ID, filename)
+	}
+	if category == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("No category applies to '%s', it would be chosen by the type of the file you send.", filename))
+		bot.Send(msg)
+		return
+	}
+
+	storagePath := resolveStoragePath(category, message)
+	destination := buildFilePath(storagePath, filename, category)
+	if config.StorageLayout == storageLayoutContent {
+		destination = filepath.Join(storagePath, "<sha256[:2]>", "<sha256>")
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("File '%s' would be saved to:\nCategory: %s\nLocation: %s", filename, category, destination))
+	bot.Send(msg)
+}
+
+// Handle deletion of a saved file by replying to its confirmation message
+func handleDeleteCommand(bot Sender, message *tgbotapi.Message) {
+	if message.ReplyToMessage == nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Reply to the confirmation of a saved file with /delete to remove it.")
+		bot.Send(msg)
+		return
+	}
+
+	records := metadata.FindByMessage(message.Chat.ID, message.ReplyToMessage.MessageID)
+	if len(records) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "I don't know which file that message refers to.")
+		bot.Send(msg)
+		return
+	}
+
+	// Only the uploader or an administrator may delete
+	if records[0].UserID != message.From.ID && !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "You can only delete files you saved yourself.")
+		bot.Send(msg)
+		return
+	}
+	if !checkCategoryWritable(bot, message, records[0].Category) {
+		return
+	}
+
+	var deleted, missing []string
+	for _, record := range records {
+		// Already in trash
+		if record.TrashedAt != nil {
+			missing = append(missing, record.Name)
+			continue
+		}
+
+		// Keep the file restorable when trash is enabled
+		if config.Trash.Enabled {
+			if err := moveToTrash(record); err != nil {
+				if !os.IsNotExist(err) {
+					msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error deleting file: %s", err.Error()))
+					bot.Send(msg)
+					return
+				}
+				missing = append(missing, record.Name)
+				if err := metadata.RemoveRecord(record); err != nil {
+					log.Printf("Error removing metadata for %s: %v", record.Path, err)
+				}
+				continue
+			}
+			deleted = append(deleted, record.Name)
+			log.Printf("File %s moved to trash by user %d", record.Path, message.From.ID)
+			continue
+		}
+
+		// Content storage may share the file with other uploads, then only this record goes
+		if metadata.Shared(record) {
+			if err := metadata.RemoveRecord(record); err != nil {
+				log.Printf("Error removing metadata for %s: %v", record.Path, err)
+			}
+			deleted = append(deleted, record.Name)
+			log.Printf("Record of %s deleted by user %d, file kept for other uploads", record.Path, message.From.ID)
+			continue
+		}
+
+		err := os.Remove(record.Path)
+		if err != nil && !os.IsNotExist(err) {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error deleting file: %s", err.Error()))
+			bot.Send(msg)
+			return
+		}
+		if err != nil {
+			missing = append(missing, record.Name)
+		} else {
+			deleted = append(deleted, record.Name)
+		}
+
+		if err := metadata.RemoveRecord(record); err != nil {
+			log.Printf("Error removing metadata for %s: %v", record.Path, err)
+		}
+		log.Printf("File %s deleted by user %d", record.Path, message.From.ID)
+	}
+
+	text := ""
+	if len(deleted) > 0 && config.Trash.Enabled {
+		text = fmt.Sprintf("Moved to trash: %s\nUse /restore [filename] within %d days to bring it back.",
+			strings.Join(deleted, ", "), int(trashRetention().Hours()/24))
+	} else if len(deleted) > 0 {
+		text = fmt.Sprintf("Deleted: %s", strings.Join(deleted, ", "))
+	}
+	if len(missing) > 0 {
+		text = strings.TrimSpace(text + fmt.Sprintf("\nAlready removed: %s", strings.Join(missing, ", ")))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	bot.Send(msg)
+}
+
+// Check if bot may work in chat
+func isChatAllowed(chatID int64) bool {
+	if len(config.AllowedChats) == 0 {
+		return true
+	}
+	for _, id := range config.AllowedChats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// Log message from chat outside the allowlist, leaving the chat if configured
+func rejectChat(bot Sender, chat *tgbotapi.Chat) {
+	log.Printf("Ignoring message from chat %d (%s %q) not in allowed_chats", chat.ID, chat.Type, chat.Title)
+	if !config.LeaveOtherChats || chat.IsPrivate() {
+		return
+	}
+	if _, err := bot.Request(tgbotapi.LeaveChatConfig{ChatID: chat.ID}); err != nil {
+		log.Printf("Error leaving chat %d: %v", chat.ID, err)
+	} else {
+		log.Printf("Left chat %d", chat.ID)
+	}
+}
+
+// Check if user is a bot administrator
+func isAdmin(userID int64) bool {
+	for _, id := range config.Admins {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle bulk import of categories from a replied-to YAML file
+func handleImportCategoriesCommand(bot Sender, message *tgbotapi.Message) {
+	if !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Only bot administrators can import categories.")
+		bot.Send(msg)
+		return
+	}
+
+	if message.ReplyToMessage == nil || message.ReplyToMessage.Document == nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Reply to a YAML file (optionally .gz) with a categories list using /importcategories.")
+		bot.Send(msg)
+		return
+	}
+	document := message.ReplyToMessage.Document
+
+	data, err := downloadFileData(bot, document.FileID, maxImportFileSize)
+	if err == nil && strings.HasSuffix(document.FileName, ".gz") {
+		data, err = gunzipData(document.FileName, data)
+	}
+
+	var imported Config
+	if err == nil {
+		err = yaml.Unmarshal(data, &imported)
+	}
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error importing categories: %s", err.Error()))
+		bot.Send(msg)
+		return
+	}
+
+	added, updated := mergeCategories(imported.Categories)
+	if updated > 0 {
+		cancelMaintenance("categories were updated")
+	}
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Imported categories: %d added, %d updated.\nAdd them to the config file to keep them after restart.", added, updated),
+	)
+	bot.Send(msg)
+}
+
+// Download file into memory, up to maxSize bytes
+func downloadFileData(bot Sender, fileID string, maxSize int64) ([]byte, error) {
+	fileURL, err := getFileURL(bot, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file URL: %w", redactError(err))
+	}
+
+	resp, err := fetchURL(fileURL, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// Handle unset default category command
+func handleUnsetDefaultCommand(bot Sender, message *tgbotapi.Message) {
+	// Check if user has a default category
+	if _, exists := userDefaults[message.From.ID]; !exists {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "You don't have a default category set.")
+		bot.Send(msg)
+		return
+	}
+
+	// Remove default category for user
+	delete(userDefaults, message.From.ID)
+	if err := saveSettings(); err != nil {
+		log.Printf("Error saving settings: %v", err)
+	}
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		"Default category removed. Files will be categorized automatically based on type.",
+	)
+	bot.Send(msg)
+}
+
+// Handle file messages
+func handleFileMessage(bot Sender, message *tgbotapi.Message) {
+	// Get file info
+	fileID, originalFilename := getFileInfo(message)
+	if fileID == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Could not process this file.")
+		bot.Send(msg)
+		return
+	}
+
+	// Extract category from caption if present
+	category := ""
+	customFilename := ""
+	var copies []string // Further categories receiving the file
+
+	// Separate key=value metadata from the rest of the caption
+	caption, tags := parseCaptionMetadata(message.Caption)
+	caption = stripBotMention(caption)
+
+	if caption != "" {
+		parts := strings.Split(caption, " ")
+
+		// A caption that is just a category number selects it
+		if _, err := strconv.Atoi(caption); err == nil {
+			if name, ok := resolveCategorySelector(caption); ok {
+				parts[0] = "/" + name
+			}
+		}
+
+		if len(parts) > 0 && strings.HasPrefix(parts[0], "/") {
+			requestedCategory := strings.TrimPrefix(parts[0], "/")
+			if name, ok := resolveCategorySelector(requestedCategory); ok {
+				category = name
+			} else if config.UnknownCaptionCategory == unknownCategoryFallback {
+				msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Category '%s' does not exist, choosing the category automatically.", requestedCategory))
+				bot.Send(msg)
+			} else {
+				msg := tgbotapi.NewMessage(
+					message.Chat.ID,
+					fmt.Sprintf("Category '%s' does not exist, the file was not saved. Send it again with one of: %s", requestedCategory, categoryNames()),
+				)
+				bot.Send(msg)
+				noteRejection(bot, message)
+				return
+			}
+
+			// Further category tokens are kept in the name, stripped, rejected, or receive copies
+			nameParts := parts[1:]
+			if config.ExtraCaptionCategories != "" && config.ExtraCaptionCategories != extraCategoriesKeep {
+				var extra []string
+				nameParts = nil
+				for _, part := range parts[1:] {
+					if name, ok := resolveCategorySelector(strings.TrimPrefix(part, "/")); strings.HasPrefix(part, "/") && ok {
+						extra = append(extra, part)
+						if config.ExtraCaptionCategories == extraCategoriesCopy && name != category && !slices.Contains(copies, name) {
+							copies = append(copies, name)
+						}
+					} else {
+						nameParts = append(nameParts, part)
+					}
+				}
+				if len(extra) > 0 && config.ExtraCaptionCategories == extraCategoriesReject {
+					msg := tgbotapi.NewMessage(
+						message.Chat.ID,
+						fmt.Sprintf("Please use a single category, the caption also names %s. Send the file again with one category.", strings.Join(extra, " ")),
+					)
+					bot.Send(msg)
+					noteRejection(bot, message)
+					return
+				}
+			}
+
+			// Check if custom filename is provided after category
+			if len(nameParts) > 0 {
+				var note string
+				customFilename, note = capCaptionWords(nameParts)
+				if note != "" && config.CaptionNote && tags["note"] == "" {
+					if tags == nil {
+						tags = make(map[string]string)
+					}
+					tags["note"] = note
+				}
+			}
+		}
+	}
+
+	// If no category specified in caption, use the category selected via /category
+	if category == "" {
+		category = takePendingCategory(message.From.ID)
+	}
+
+	// Otherwise check for user default, chat default, and filename rules
+	if category == "" {
+		category = resolveDefaultCategory(message.From.ID, message.Chat.ID, originalFilename)
+	}
+	chosen := category != "" // Caption, /category, defaults or a filename rule picked the category
+
+	// Captionless photos may go to triage or wait for the sender to pick a category
+	askCategory := false
+	if category == "" && len(message.Photo) > 0 && message.Caption == "" {
+		switch config.CaptionlessPhotos {
+		case captionlessPhotoUnsorted:
+			category = unsortedCategory
+		case captionlessPhotoAsk:
+			askCategory = true
+		}
+	}
+
+	// If nothing matched, determine based on file type
+	if category == "" {
+		category = determineCategory(message)
+	}
+
+	// Use custom filename if provided, otherwise use original
+	filename := chooseFilename(message, category, customFilename, originalFilename)
+	generated := filename == originalFilename // Category defaults may still rename the file
+
+	// Handle files without extension
+	if filepath.Ext(filename) == "" {
+		switch config.NoExtension {
+		case noExtensionKeep:
+		case noExtensionRoute:
+			// Only replaces the category guessed from the file type
+			if !chosen {
+				category = noExtensionCategory
+			}
+		default: // noExtensionMime
+			filename += extensionForMimeType(getFileMimeType(message))
+		}
+	}
+
+	// Reject names that could escape the category folder
+	if err := validateFilename(filename, category); err != nil {
+		log.Printf("Rejected filename %q from user %d: %v", filename, message.From.ID, err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Invalid filename: %v. Please send the file again with another name.", err))
+		bot.Send(msg)
+		noteRejection(bot, message)
+		return
+	}
+
+	// Reject saves to frozen categories, unnamed files where names are required and files over the size limit
+	if !checkCategoryAccepts(bot, message, category, customFilename != "") {
+		noteRejection(bot, message)
+		return
+	}
+	for _, copyCategory := range copies {
+		if !checkCategoryAccepts(bot, message, copyCategory, customFilename != "") {
+			noteRejection(bot, message)
+			return
+		}
+	}
+
+	// Reject files Telegram will not let the bot download before announcing the save
+	if getFileSize(message) > maxBotDownloadSize {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error saving file: %s", errFileTooBig.Error()))
+		bot.Send(msg)
+		noteRejection(bot, message)
+		return
+	}
+
+	// Let the sender pick a category for a captionless photo
+	if askCategory {
+		askSaveCategory(bot, message, fileID, filename, generated, tags)
+		return
+	}
+
+	// Ask before saving to categories that require confirmation
+	if getCategoryConfig(category).Confirm {
+		askSaveConfirmation(bot, message, fileID, category, filename, tags, copies)
+		return
+	}
+
+	submitSave(bot, message, fileID, category, filename, tags, copies)
+}
+
+// Get name to save file under in category, a custom name from the caption wins over generated names
+func chooseFilename(message *tgbotapi.Message, category, customFilename, originalFilename string) string {
+	filename := originalFilename
+	if customFilename != "" {
+		// Keep the original extension if present
+		originalExt := filepath.Ext(originalFilename)
+		customExt := filepath.Ext(customFilename)
+
+		if customExt == "" && originalExt != "" {
+			customFilename += originalExt
+		}
+		filename = customFilename
+	} else if config.ForwardedChannelName != "" && isChannelForward(message) && !hasOriginalFilename(message) {
+		// Name files from channel archives after their origin instead of a generic name
+		filename = renderChannelFilename(config.ForwardedChannelName, message, originalFilename)
+	} else if config.ForwardBatch.Enabled && message.ForwardDate != 0 && message.MediaGroupID == "" {
+		// Number quickly forwarded files with a shared batch name, albums keep their names
+		filename = nextForwardBatchName(message.From.ID, message.Time()) + filepath.Ext(originalFilename)
+	} else if template := getCategoryConfig(category).DefaultFilename; template != "" && !hasOriginalFilename(message) {
+		filename = renderDefaultFilename(template, message.Time(), originalFilename)
+	}
+	return filename
+}
+
+// Download validated file to category, add it to copies categories and confirm the save to the user
+func saveFile(bot Sender, message *tgbotapi.Message, fileID, category, filename string, tags map[string]string, copies []string) {
+	// Get storage path for category
+	storagePath := resolveStoragePath(category, message)
+
+	// Status message to user
+	statusMsg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Saving file '%s' to category '%s' (path: %s)...", filename, category, storagePath))
+	statusMessage, _ := bot.Send(statusMsg)
+
+	// Download and save the file
+	saved, err := downloadAndSaveFile(bot, fileID, category, storagePath, filename, getFileSize(message), message.From.ID)
+	if err != nil {
+		resultID := sendSaveResult(bot, message, statusMessage.MessageID, saveErrorText(err, category), "")
+		scheduleDeletion(bot, message.Chat.ID, resultID, config.ErrorRetention)
+		if errors.Is(err, errStorageUnavailable) {
+			log.Printf("ALERT: storage for category %s is unavailable: %v", category, err)
+		}
+		notifySaveFailure(bot, message, category, filename, err)
+		return
+	}
+
+	// Use the photo capture date for the file when enabled for the category
+	if getCategoryConfig(category).UseExifDate {
+		applyCaptureDate(saved.Path, message.Time())
+	}
+
+	// Remember the upload, confirmation message ID allows acting on it by reply
+	upload := FileRecord{
+		Category:  category,
+		Tags:      tags,
+		UserID:    message.From.ID,
+		ChatID:    message.Chat.ID,
+		ChatType:  message.Chat.Type,
+		MessageID: statusMessage.MessageID,
+	}
+	recordSavedFile(upload, filename, saved)
+	resetRejections(message.From.ID)
+	runCategoryHook(bot, message, category, saved.Path)
+	trackSplitPart(bot, message, category, filename, tags, saved)
+
+	successText := renderSuccessMessage(category, saved)
+	notes := ""
+
+	// Other categories named in the caption get the same file without downloading it again
+	if len(copies) > 0 {
+		notes += saveCategoryCopies(bot, message, upload, filename, saved, copies)
+	}
+
+	// Let the user know the name they asked for did not fit
+	if len(filename) > filenameLimit(sanitizeRules(category)) && config.StorageLayout != storageLayoutContent {
+		notes += fmt.Sprintf("\n\nNote: the filename was too long and was shortened to '%s'.", filepath.Base(saved.Path))
+	}
+
+	// Photos are compressed by Telegram, optionally keep all variants and warn the user
+	if len(message.Photo) > 0 {
+		if config.Photos.SaveAllSizes {
+			savePhotoVariants(bot, message, upload, storagePath, filename)
+		}
+		if config.Photos.WarnCompressed {
+			notes += "\n\nNote: this photo was compressed by Telegram. Send it as a file to keep the original quality."
+		}
+	}
+
+	// Keep the thumbnail Telegram made for the video, cheaper than generating one
+	if getCategoryConfig(category).SaveVideoThumbnails {
+		saveVideoThumbnail(bot, message, upload, storagePath, saved)
+	}
+
+	// Success message
+	resultID := sendSaveResult(bot, message, statusMessage.MessageID, successText+escapeForParseMode(notes), config.SuccessParseMode)
+	if statusMessage.MessageID != 0 && resultID != 0 && resultID != statusMessage.MessageID {
+		// Replies to the new confirmation must find the saved files
+		if err := metadata.ReplaceMessage(message.Chat.ID, statusMessage.MessageID, resultID); err != nil {
+			log.Printf("Error updating confirmation of %s: %v", saved.Path, err)
+		}
+	}
+	scheduleDeletion(bot, message.Chat.ID, resultID, config.StatusRetention)
+
+	echoSavedFile(bot, message.Chat.ID, message.From.ID, saved)
+}
+
+// Show outcome of a save in the status message, sending a new message when editing keeps failing.
+// Returns ID of the message showing the outcome, 0 when it could not be delivered.
+func sendSaveResult(bot Sender, message *tgbotapi.Message, statusID int, text, parseMode string) int {
+	if statusID != 0 {
+		edit := tgbotapi.NewEditMessageText(message.Chat.ID, statusID, text)
+		edit.ParseMode = parseMode
+		err := withRetry("edit save confirmation", isTransientAPIError, func() error {
+			_, err := bot.Send(edit)
+			return err
+		})
+		if err == nil {
+			return statusID
+		}
+		log.Printf("Error editing confirmation %d for message %d in chat %d, sending a new one: %v", statusID, message.MessageID, message.Chat.ID, err)
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = parseMode
+	var sent tgbotapi.Message
+	err := withRetry("send save confirmation", isTransientAPIError, func() error {
+		var err error
+		sent, err = bot.Send(msg)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error sending confirmation for message %d in chat %d, the user was not told: %v", message.MessageID, message.Chat.ID, err)
+		return 0
+	}
+	return sent.MessageID
+}
+
+// Delete bot's message after given seconds, keeping it when seconds is 0
+func scheduleDeletion(bot Sender, chatID int64, messageID int, seconds int) {
+	if seconds <= 0 || messageID == 0 {
+		return
+	}
+	time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		if _, err := bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+			log.Printf("Error deleting message %d in chat %d: %v", messageID, chatID, err)
+		}
+	})
+}
+
+// Get message telling the user why saving failed
+func saveErrorText(err error, category string) string {
+	switch {
+	case errors.Is(err, errStorageUnavailable):
+		text := config.StorageUnavailableMessage
+		if text == "" {
+			text = defaultStorageUnavailableMessage
+		}
+		return strings.ReplaceAll(text, "{category}", category)
+	case errors.Is(err, errDiskFull):
+		return "Error saving file: storage is full. Please try again later."
+	case errors.Is(err, errDownloadFailed):
+		return fmt.Sprintf("Error saving file: could not get it from Telegram, please send it again. (%v)", err)
+	default:
+		return fmt.Sprintf("Error saving file: %s", err.Error())
+	}
+}
+
+// Render configured confirmation for a saved file
+func renderSuccessMessage(category string, saved savedFile) string {
+	template := config.SuccessTemplate
+	if template == "" {
+		template = defaultSuccessTemplate
+	}
+	return strings.NewReplacer(
+		"{filename}", escapeForParseMode(filepath.Base(saved.Path)),
+		"{category}", escapeForParseMode(category),
+		"{path}", escapeForParseMode(saved.Path),
+		"{size}", escapeForParseMode(formatBytes(saved.Size)),
+		"{hash}", escapeForParseMode(saved.SHA256),
+	).Replace(template)
+}
+
+// Escape text for the configured success parse mode, plain text is returned unchanged
+func escapeForParseMode(text string) string {
+	if config.SuccessParseMode == "" {
+		return text
+	}
+	return tgbotapi.EscapeText(config.SuccessParseMode, text)
+}
+
+// Save smaller photo size variants next to the largest one
+func savePhotoVariants(bot Sender, message *tgbotapi.Message, upload FileRecord, storagePath, filename string) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+
+	// The largest variant (last in the array) is already saved
+	for _, photo := range message.Photo[:len(message.Photo)-1] {
+		variantName := fmt.Sprintf("%s_%dx%d%s", base, photo.Width, photo.Height, ext)
+		saved, err := downloadAndSaveFile(bot, photo.FileID, upload.Category, storagePath, variantName, int64(photo.FileSize), upload.UserID)
+		if err != nil {
+			log.Printf("Error saving photo variant %s: %v", variantName, err)
+			continue
+		}
+		recordSavedFile(upload, variantName, saved)
+	}
+}
+
+// Save thumbnail of video message next to the saved video, videos without one are left alone
+func saveVideoThumbnail(bot Sender, message *tgbotapi.Message, upload FileRecord, storagePath string, video savedFile) {
+	var thumbnail *tgbotapi.PhotoSize
+	if message.Video != nil {
+		thumbnail = message.Video.Thumbnail
+	} else if message.VideoNote != nil {
+		thumbnail = message.VideoNote.Thumbnail
+	}
+	if thumbnail == nil {
+		return
+	}
+
+	// Name after the video as saved, which may differ from the requested name
+	base := filepath.Base(video.Path)
+	thumbName := strings.TrimSuffix(base, filepath.Ext(base)) + "_thumb.jpg"
+	saved, err := downloadAndSaveFile(bot, thumbnail.FileID, upload.Category, storagePath, thumbName, int64(thumbnail.FileSize), upload.UserID)
+	if err != nil {
+		log.Printf("Error saving video thumbnail %s: %v", thumbName, err)
+		return
+	}
+	recordSavedFile(upload, thumbName, saved)
+}
+
+// Record metadata of a saved file, upload holds the fields describing the upload itself
+func recordSavedFile(upload FileRecord, filename string, saved savedFile) {
+	record := upload
+	record.Path = saved.Path
+	record.Name = filename
+	record.Size = saved.Size
+	record.SHA256 = saved.SHA256
+	record.SavedAt = time.Now()
+	if err := metadata.Add(record); err != nil {
+		log.Printf("Error saving metadata for %s: %v", saved.Path, err)
+	}
+}
+
+// Resolve category from user default, chat default, or filename rules
+func resolveDefaultCategory(userID, chatID int64, filename string) string {
+	if defaultCat, hasDefault := userDefaults[userID]; hasDefault {
+		return defaultCat
+	}
+	if chatCat, hasChatDefault := chatDefaults[chatID]; hasChatDefault {
+		return chatCat
+	}
+	return matchCategoryRule(filename)
+}
+
+// Get storage path for category
+func getStoragePath(category string) string {
+	storagePath, ok := categoryPath(category)
+	if !ok {
+		// Fallback to misc if category not found (should not happen)
+		storagePath, _ = categoryPath("other")
+		if storagePath == "" {
+			storagePath = "./files/misc"
+		}
+	}
+	return storagePath
+}
+
+// Get storage directory for a file from message, nested under sender's folder if enabled
+func resolveStoragePath(category string, message *tgbotapi.Message) string {
+	storagePath := renderPathTemplate(getStoragePath(category), category, message.Time())
+	if config.UserFolders {
+		storagePath = filepath.Join(storagePath, userFolderName(message.From))
+	}
+	return storagePath
+}
+
+// Get filesystem-safe folder name for user, falling back to user ID
+func userFolderName(user *tgbotapi.User) string {
+	name := sanitizeFilename(user.UserName, config.Sanitize)
+	if name == "" || name == "." || name == ".." {
+		return strconv.FormatInt(user.ID, 10)
+	}
+	return name
+}
+
+// Build destination path for filename, sanitized and unique within the category's uniqueness scope
+func buildFilePath(storagePath, filename, category string) string {
+	path := filepath.Join(storagePath, sanitizeFilename(filename, sanitizeRules(category)))
+	if getCategoryConfig(category).UniqueScope != uniqueScopeCategory {
+		return ensureUniqueFilename(path)
+	}
+
+	// Names in other folders of the category count as taken too
+	names := categoryFilenames(category)
+	return uniqueFilename(path, func(candidate string) bool {
+		return names[filepath.Base(candidate)] || fileExists(candidate)
+	})
+}
+
+// Get names of all files in the category's folders
+func categoryFilenames(category string) map[string]bool {
+	names := make(map[string]bool)
+	root, folderPrefix := categoryLocation(category)
+	root = filepath.Clean(root)
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && filepath.Dir(path) == root && !ownsRootEntry(folderPrefix, entry) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			names[entry.Name()] = true
+		}
+		return nil
+	})
+	return names
+}
+
+// Build destination path for filename, reusing the user's own earlier file of that name when overwriting is enabled
+func ownedFilePath(storagePath, filename, category string, userID int64) (string, bool) {
+	if config.OverwriteOwnFiles {
+		path := filepath.Join(storagePath, sanitizeFilename(filename, sanitizeRules(category)))
+		// Only files the metadata attributes to this user are replaced
+		if record := metadata.FindByPath(path); record.Path != "" && record.UserID == userID {
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+	return buildFilePath(storagePath, filename, category), false
+}
+
+// Check that category accepts changes, replying to the user if it does not
+func checkCategoryWritable(bot Sender, message *tgbotapi.Message, category string) bool {
+	if !getCategoryConfig(category).ReadOnly {
+		return true
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Category '%s' is read-only, files cannot be added or changed there.", category))
+	bot.Send(msg)
+	return false
+}
+
+// Check that category takes the file, replying to the user with the reason if it does not
+func checkCategoryAccepts(bot Sender, message *tgbotapi.Message, category string, named bool) bool {
+	if !checkCategoryWritable(bot, message, category) {
+		return false
+	}
+
+	// Some categories only accept files named by the sender
+	if !named && getCategoryConfig(category).RequireCaption {
+		msg := tgbotapi.NewMessage(
+			message.Chat.ID,
+			fmt.Sprintf("Files in category '%s' need a name. Please resend the file with a caption like: /%s descriptive-name", category, category),
+		)
+		bot.Send(msg)
+		return false
+	}
+
+	// Reject files over the most specific applicable size limit
+	if limit, source := resolveSizeLimit(category, attachmentType(message)); limit > 0 && getFileSize(message) > limit {
+		msg := tgbotapi.NewMessage(
+			message.Chat.ID,
+			fmt.Sprintf("File is too large (%s). The %s limit is %s.", formatBytes(getFileSize(message)), source, formatBytes(limit)),
+		)
+		bot.Send(msg)
+		return false
+	}
+	return true
+}
+
+// Get name for the next file in user's forward batch, starting a new batch after the window
+func nextForwardBatchName(userID int64, received time.Time) string {
+	window := time.Duration(config.ForwardBatch.Window) * time.Second
+	if window <= 0 {
+		window = defaultForwardBatchWindow
+	}
+
+	batch, ok := forwardBatches.Peek(userID)
+	if !ok || received.Sub(batch.Last) > window {
+		batch = forwardBatch{Started: received}
+	}
+	batch.Last = received
+	batch.Count++
+	forwardBatches.Put(userID, batch, window)
+
+	template := config.ForwardBatch.NameTemplate
+	if template == "" {
+		template = defaultForwardBatchTemplate
+	}
+	return strings.NewReplacer(
+		"{date}", batch.Started.Format("20060102"),
+		"{time}", batch.Started.Format("150405"),
+		"{n}", fmt.Sprintf("%02d", batch.Count),
+	).Replace(template)
+}
+
+// Return and clear user's pending category, ignoring expired selections
+func takePendingCategory(userID int64) string {
+	name, ok := pendingCategories.Take(userID)
+	if !ok {
+		return ""
+	}
+	if _, exists := categoryPath(name); !exists {
+		return ""
+	}
+	return name
+}
+
+// Get file info (ID and filename) from message
+func getFileInfo(message *tgbotapi.Message) (string, string) {
+	if message.Document != nil {
+		return message.Document.FileID, message.Document.FileName
+	} else if len(message.Photo) > 0 {
+		// Get the largest photo (last in the array)
+		photo := message.Photo[len(message.Photo)-1]
+		// Photos don't have filenames, generate one based on date
+		return photo.FileID, fmt.Sprintf("photo_%d.jpg", time.Now().Unix())
+	} else if message.Video != nil {
+		filename := message.Video.FileName
+		if filename == "" {
+			filename = fmt.Sprintf("video_%d.mp4", time.Now().Unix())
+		}
+		return message.Video.FileID, filename
+	} else if message.Audio != nil {
+		filename := message.Audio.FileName
+		if filename == "" {
+			filename = fmt.Sprintf("audio_%d.mp3", time.Now().Unix())
+		}
+		return message.Audio.FileID, filename
+	} else if message.Voice != nil {
+		return message.Voice.FileID, fmt.Sprintf("voice_%d.ogg", time.Now().Unix())
+	} else if message.VideoNote != nil {
+		return message.VideoNote.FileID, fmt.Sprintf("video_note_%d.mp4", time.Now().Unix())
+	}
+	return "", ""
+}
+
+// Check if attachment came with its own filename rather than a generated one
+func hasOriginalFilename(message *tgbotapi.Message) bool {
+	if message.Document != nil {
+		return message.Document.FileName != ""
+	} else if message.Video != nil {
+		return message.Video.FileName != ""
+	} else if message.Audio != nil {
+		return message.Audio.FileName != ""
+	}
+	return false
+}
+
+// Render category default filename template, keeping extension of the generated name unless template has one
+func renderDefaultFilename(template string, received time.Time, generated string) string {
+	filename := strings.NewReplacer(
+		"{date}", received.Format("20060102"),
+		"{time}", received.Format("150405"),
+		"{unix}", strconv.FormatInt(received.Unix(), 10),
+	).Replace(template)
+	if filepath.Ext(filename) == "" {
+		filename += filepath.Ext(generated)
+	}
+	return filename
+}
+
+// Check if message was forwarded from a channel
+func isChannelForward(message *tgbotapi.Message) bool {
+	return message.ForwardFromChat != nil && message.ForwardFromChat.IsChannel()
+}
+
+// Render forwarded channel filename template, keeping extension of the generated name unless template has one
+func renderChannelFilename(template string, message *tgbotapi.Message, generated string) string {
+	channel := message.ForwardFromChat.UserName
+	if channel == "" {
+		channel = message.ForwardFromChat.Title
+	}
+	if channel == "" {
+		channel = strconv.FormatInt(message.ForwardFromChat.ID, 10)
+	}
+
+	posted := time.Unix(int64(message.ForwardDate), 0)
+	filename := strings.NewReplacer(
+		"{channel}", channel,
+		"{message_id}", strconv.Itoa(message.ForwardFromMessageID),
+		"{date}", posted.Format("20060102"),
+		"{time}", posted.Format("150405"),
+	).Replace(template)
+	if filepath.Ext(filename) == "" {
+		filename += filepath.Ext(generated)
+	}
+	return filename
+}
+
+// Get attachment type name used for type-specific settings
+func attachmentType(message *tgbotapi.Message) string {
+	if message.Document != nil {
+		return "document"
+	} else if len(message.Photo) > 0 {
+		return "photo"
+	} else if message.Video != nil {
+		return "video"
+	} else if message.Audio != nil {
+		return "audio"
+	} else if message.Voice != nil {
+		return "voice"
+	} else if message.VideoNote != nil {
+		return "video_note"
+	}
+	return ""
+}
+
+// Get size of the attachment in bytes as reported by Telegram
+func getFileSize(message *tgbotapi.Message) int64 {
+	if message.Document != nil {
+		return int64(message.Document.FileSize)
+	} else if len(message.Photo) > 0 {
+		return int64(message.Photo[len(message.Photo)-1].FileSize)
+	} else if message.Video != nil {
+		return int64(message.Video.FileSize)
+	} else if message.Audio != nil {
+		return int64(message.Audio.FileSize)
+	} else if message.Voice != nil {
+		return int64(message.Voice.FileSize)
+	} else if message.VideoNote != nil {
+		return int64(message.VideoNote.FileSize)
+	}
+	return 0
+}
+
+// Resolve size limit for category and attachment type, most specific wins
+func resolveSizeLimit(category, fileType string) (int64, string) {
+	if limit := getCategoryConfig(category).MaxFileSizeBytes; limit > 0 {
+		return limit, fmt.Sprintf("'%s' category", category)
+	}
+	if limit := config.TypeSizeLimits[fileType]; limit > 0 {
+		return limit, fmt.Sprintf("%s type", fileType)
+	}
+	return config.MaxFileSizeBytes, "global"
+}
+
+// Get MIME type of the attachment as reported by Telegram
+func getFileMimeType(message *tgbotapi.Message) string {
+	if message.Document != nil {
+		return message.Document.MimeType
+	} else if len(message.Photo) > 0 {
+		return "image/jpeg"
+	} else if message.Video != nil {
+		return message.Video.MimeType
+	} else if message.Audio != nil {
+		return message.Audio.MimeType
+	} else if message.Voice != nil {
+		return message.Voice.MimeType
+	} else if message.VideoNote != nil {
+		return "video/mp4"
+	}
+	return ""
+}
+
+// Get file extension for MIME type, empty if unknown
+func extensionForMimeType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+
+	// Prefer common extensions over the first registered one
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+
+	extensions, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(extensions) == 0 {
+		return ""
+	}
+	return extensions[0]
+}
+
+// Determine category based on file type
+func determineCategory(message *tgbotapi.Message) string {
+	if message.Document != nil {
+		if config.ExtensionOverridesType {
+			if category, ok := mediaExtensionCategories[strings.ToLower(filepath.Ext(message.Document.FileName))]; ok {
+				return category
+			}
+		}
+		if config.RouteMediaDocuments {
+			if category := mediaCategoryForMimeType(message.Document.MimeType); category != "" {
+				return category
+			}
+		}
+		return "document"
+	} else if len(message.Photo) > 0 {
+		return "image"
+	} else if message.Video != nil || message.VideoNote != nil {
+		return "video"
+	} else if message.Audio != nil || message.Voice != nil {
+		return "audio"
+	}
+	return "other"
+}
+
+// Get media category for MIME type, empty for non-media types
+func mediaCategoryForMimeType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	}
+	return ""
+}
+
+// savedFile represents a file written to storage
+type savedFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// Download and save file
+func downloadAndSaveFile(bot Sender, fileID, category, storagePath, filename string, size, userID int64) (savedFile, error) {
+	// Fail before downloading when the category storage cannot be written
+	if config.ProbeStorage {
+		if err := probeStorage(storagePath); err != nil {
+			return savedFile{}, storageError(err)
+		}
+	}
+
+	// Get file URL
+	var fileURL string
+	err := withRetry("get file URL", isTransientAPIError, func() error {
+		var err error
+		fileURL, err = getFileURL(bot, fileID)
+		return redactError(err)
+	})
+	if isFileTooBigError(err) {
+		return savedFile{}, errFileTooBig
+	}
+	if err != nil {
+		return savedFile{}, downloadError(fmt.Errorf("error getting file URL: %w", err))
+	}
+
+	// Create directory
+	if err := os.MkdirAll(storagePath, 0755); err != nil {
+		return savedFile{}, storageError(fmt.Errorf("error creating directory: %w", err))
+	}
+
+	// Keep the configured free space on the filesystem of the category
+	if err := checkFreeSpace(storagePath, category, size); err != nil {
+		return savedFile{}, err
+	}
+
+	// Limit parallel writes to the category storage
+	release := acquireCategorySlot(category)
+	defer release()
+
+	// Download into a partial file that is kept on failure so the next attempt resumes it
+	unlockDownload := lockFileDownload(fileID)
+	defer unlockDownload()
+	outFile, err := os.OpenFile(partialDownloadPath(category, fileID), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return savedFile{}, storageError(fmt.Errorf("error creating file: %w", err))
+	}
+	defer outFile.Close()
+
+	// Server errors are retried, continuing the partial file; client errors are permanent
+	var saved savedFile
+	releaseDownload := acquireDownloadSlot()
+	err = withRetry("download file", isTransientDownloadError, func() error {
+		var err error
+		saved, err = resumeDownload(outFile, fileURL, category)
+		return err
+	})
+	releaseDownload()
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && !statusErr.Transient() {
+		// Nothing to resume, and the link may be stale
+		outFile.Close()
+		os.Remove(outFile.Name())
+		forgetFileURL(fileID)
+	}
+	if err != nil {
+		return savedFile{}, err
+	}
+
+	// Content-Length may be missing, the size Telegram reported for the file must match too
+	if size > 0 && saved.Size != size {
+		if saved.Size > size {
+			// Not the file Telegram described, resuming it cannot help
+			outFile.Close()
+			os.Remove(outFile.Name())
+			forgetFileURL(fileID)
+		}
+		return savedFile{}, downloadError(fmt.Errorf("incomplete download, got %d of %d bytes", saved.Size, size))
+	}
+
+	// Reject known-bad content before it gets a name in storage
+	if isBlockedHash(saved.SHA256) {
+		log.Printf("Rejected blocked file %s with hash %s", filename, saved.SHA256)
+		outFile.Close()
+		os.Remove(outFile.Name())
+		return savedFile{}, errBlockedFile
+	}
+
+	// Move complete file to its final name, which may depend on the content hash
+	unlockNames := lockStorageNames()
+	defer unlockNames()
+	if config.StorageLayout == storageLayoutContent {
+		saved, err = moveToContentPath(outFile, storagePath, saved)
+	} else if config.FilenameHash {
+		saved, err = moveToHashedName(outFile, storagePath, filename, category, saved)
+	} else {
+		path, overwrite := ownedFilePath(storagePath, filename, category, userID)
+		saved, err = moveToName(outFile, path, saved)
+		if err == nil && overwrite {
+			log.Printf("Overwrote %s previously saved by user %d", path, userID)
+			if err := metadata.RemovePath(path); err != nil {
+				log.Printf("Error removing metadata for %s: %v", path, err)
+			}
+		}
+	}
+	if err != nil {
+		return savedFile{}, storageError(err)
+	}
+	return applyDuplicatePolicy(saved, category, userID)
+}
+
+// httpStatusError is returned when the download server answers with a non-2xx status
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("download server returned %s", e.Status)
+}
+
+// Check if the server may succeed on another attempt, rate limits and server errors
+func (e *httpStatusError) Transient() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// Check if download failure is worth retrying, only server-side HTTP errors are
+func isTransientDownloadError(err error) bool {
+	var statusErr *httpStatusError
+	return errors.As(err, &statusErr) && statusErr.Transient()
+}
+
+// Mark error as a failed transfer from Telegram
+func downloadError(err error) error {
+	return fmt.Errorf("%w: %w", errDownloadFailed, err)
+}
+
+// Mark error as a failed write to storage, telling a full disk apart
+func storageError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", errDiskFull, err)
+	}
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.ENOTDIR) {
+		return fmt.Errorf("%w: %w", errStorageUnavailable, err)
+	}
+	return fmt.Errorf("%w: %w", errStorageFailed, err)
+}
+
+// Reject file of size when writing it would leave less than the minimum free space on dir's filesystem
+func checkFreeSpace(dir, category string, size int64) error {
+	minFree := config.MinFreeSpaceBytes
+	if catMinFree := getCategoryConfig(category).MinFreeSpaceBytes; catMinFree > 0 {
+		minFree = catMinFree
+	}
+	if minFree <= 0 {
+		return nil
+	}
+
+	_, free, _, err := diskSpace(dir)
+	if err != nil {
+		// Free space is unknown on this platform or filesystem, rely on ENOSPC handling
+		return nil
+	}
+	if free-size < minFree {
+		return fmt.Errorf("%w: %s free, %s needed to keep %s free", errDiskFull, formatBytes(free), formatBytes(size), formatBytes(minFree))
+	}
+	return nil
+}
+
+// Check that directory exists or can be created and accepts new files
+func probeStorage(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("error writing to directory: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Get path of the partial download of file, hidden in the category root so it survives date folders changing
+func partialDownloadPath(category, fileID string) string {
+	key := sha256.Sum256([]byte(fileID))
+	return filepath.Join(categoryRoot(category), ".partial-"+hex.EncodeToString(key[:8])+".part")
+}
+
+// Download URL into partial file, continuing after its current content when the server supports ranges
+func resumeDownload(file *os.File, url, category string) (savedFile, error) {
+	// Hash content written by an earlier attempt, leaving the file offset at its end
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return savedFile{}, storageError(fmt.Errorf("error reading partial file: %w", err))
+	}
+	hasher := sha256.New()
+	offset, err := io.Copy(hasher, file)
+	if err != nil {
+		return savedFile{}, storageError(fmt.Errorf("error reading partial file: %w", err))
+	}
+
+	resp, err := fetchURL(url, offset)
+	if err != nil {
+		return savedFile{}, downloadError(fmt.Errorf("error downloading file: %w", err))
+	}
+	defer resp.Body.Close()
+
+	// Never write an error page as the file, the partial file is kept for the next attempt
+	if err := checkDownloadStatus(resp); err != nil {
+		return savedFile{}, err
+	}
+
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		log.Printf("Resuming download into %s after %d bytes", file.Name(), offset)
+	} else if offset > 0 {
+		// Server cannot resume, start over
+		log.Printf("Restarting download into %s, server answered range request with %s", file.Name(), resp.Status)
+		if err := file.Truncate(0); err != nil {
+			return savedFile{}, storageError(fmt.Errorf("error writing file: %w", err))
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return savedFile{}, storageError(fmt.Errorf("error writing file: %w", err))
+		}
+		hasher.Reset()
+		offset = 0
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			if resp, err = fetchURL(url, 0); err != nil {
+				return savedFile{}, downloadError(fmt.Errorf("error downloading file: %w", err))
+			}
+			defer resp.Body.Close()
+			if err := checkDownloadStatus(resp); err != nil {
+				return savedFile{}, err
+			}
+		}
+	}
+
+	// Copy data, hashing it along the way
+	written, err := io.Copy(io.MultiWriter(file, hasher), throttleDownload(resp.Body, category))
+	if err != nil {
+		err = fmt.Errorf("error copying file, %d bytes kept to resume: %w", offset+written, err)
+		// Failed writes report the file path, anything else came from the connection
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return savedFile{}, storageError(err)
+		}
+		return savedFile{}, downloadError(err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return savedFile{}, downloadError(fmt.Errorf("incomplete download, got %d of %d bytes", written, resp.ContentLength))
 	}
-}
 
-// Send welcome message
-func sendStartMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	welcomeText := fmt.Sprintf("Welcome, %s! I'm a file saving bot. Send me files and I'll save them for you.\n\nUse /help to see available commands.", message.From.FirstName)
-	msg := tgbotapi.NewMessage(message.Chat.ID, welcomeText)
-	bot.Send(msg)
+	return savedFile{Path: file.Name(), Size: offset + written, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
 }
 
-// Send help message
-func sendHelpMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	helpText := `
-Available commands:
-/start - Start the bot
-/help - Show this help message
-/categories - List available file categories
-/setdefault [category] - Set default category for saving files
-/unsetdefault - Remove default category setting
+// Remove partial downloads of all categories not written to for the configured age
+func cleanupPartialDownloads() {
+	maxAge := time.Duration(config.Download.PartialMaxAgeHours) * time.Hour
+	if maxAge <= 0 {
+		maxAge = defaultPartialMaxAge
+	}
 
-To save a file with a specific category, send the file with a caption in the format: 
-/category filename
+	var paths []string
+	for _, cat := range getCategories() {
+		catPaths, err := filepath.Glob(filepath.Join(categoryRoot(cat.Name), ".partial-*.part"))
+		if err != nil {
+			continue
+		}
+		paths = append(paths, catPaths...)
+	}
 
-Example: /image vacation.jpg
+	ctx, cancel := startMaintenanceJob()
+	defer cancel()
 
-If no category is specified, I'll use your default category (if set) or determine it automatically based on file type.
-`
-	msg := tgbotapi.NewMessage(message.Chat.ID, helpText)
-	bot.Send(msg)
+	err := runMaintenance(ctx, paths, func(path string) {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error removing stale partial download %s: %v", path, err)
+		} else {
+			log.Printf("Removed stale partial download %s", path)
+		}
+	})
+	if err != nil {
+		log.Printf("Partial download cleanup stopped early: %v", err)
+	}
 }
 
-// Send categories message
-func sendCategoriesMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	categoriesText := "Available categories for file organization:\n"
-	for catName, catPath := range categoryMap {
-		categoriesText += fmt.Sprintf("/%s - Save file to %s folder\n", catName, catPath)
+// Move downloaded temporary file to filename with hash fragment before extension, e.g. report-a1b2c3.pdf
+func moveToHashedName(tmpFile *os.File, storagePath, filename, category string, saved savedFile) (savedFile, error) {
+	length := config.FilenameHashLength
+	if length <= 0 || length > len(saved.SHA256) {
+		length = defaultFilenameHashLength
 	}
-	msg := tgbotapi.NewMessage(message.Chat.ID, categoriesText)
-	bot.Send(msg)
+
+	ext := filepath.Ext(filename)
+	hashedName := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filename, ext), saved.SHA256[:length], ext)
+	return moveToName(tmpFile, buildFilePath(storagePath, hashedName, category), saved)
 }
 
-// Handle set default category command
-func handleSetDefaultCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
-	if args == "" {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Please specify a category. Usage: /setdefault [category]")
-		bot.Send(msg)
-		return
+// Move downloaded temporary file to path
+func moveToName(tmpFile *os.File, path string, saved savedFile) (savedFile, error) {
+	tmpPath := tmpFile.Name()
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return savedFile{}, fmt.Errorf("error writing file: %w", err)
 	}
 
-	// Check if category exists
-	if _, exists := categoryMap[args]; !exists {
-		availableCategories := make([]string, 0, len(categoryMap))
-		for cat := range categoryMap {
-			availableCategories = append(availableCategories, cat)
-		}
-		msg := tgbotapi.NewMessage(
-			message.Chat.ID,
-			fmt.Sprintf("Category '%s' does not exist. Available categories: %s",
-				args, strings.Join(availableCategories, ", ")),
-		)
-		bot.Send(msg)
-		return
+	saved.Path = path
+	if err := os.Rename(tmpPath, saved.Path); err != nil {
+		os.Remove(tmpPath)
+		return savedFile{}, fmt.Errorf("error moving file: %w", err)
 	}
+	return saved, nil
+}
 
-	// Set default category for user
-	userDefaults[message.From.ID] = args
-	msg := tgbotapi.NewMessage(
-		message.Chat.ID,
-		fmt.Sprintf("Default category set to '%s'. All your files will be saved to this category unless specified otherwise.", args),
-	)
-	bot.Send(msg)
+// duplicateFileError is returned when identical content is already saved
+type duplicateFileError struct {
+	Existing FileRecord
 }
 
-// Handle unset default category command
-func handleUnsetDefaultCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	// Check if user has a default category
-	if _, exists := userDefaults[message.From.ID]; !exists {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "You don't have a default category set.")
-		bot.Send(msg)
-		return
+func (e *duplicateFileError) Error() string {
+	return fmt.Sprintf("identical file already saved as '%s' at %s", e.Existing.Name, e.Existing.Path)
+}
+
+// Apply configured policy for content that is already saved under another name
+func applyDuplicatePolicy(saved savedFile, category string, userID int64) (savedFile, error) {
+	if config.DuplicatePolicy == "" || config.DuplicatePolicy == duplicateKeepBoth {
+		return saved, nil
 	}
 
-	// Remove default category for user
-	delete(userDefaults, message.From.ID)
-	msg := tgbotapi.NewMessage(
-		message.Chat.ID,
-		"Default category removed. Files will be categorized automatically based on type.",
-	)
-	bot.Send(msg)
-}
+	// Only records whose files still exist, within the dedup scope, count as duplicates
+	var existing []FileRecord
+	for _, record := range metadata.FindByHash(saved.SHA256) {
+		if record.TrashedAt != nil || config.DedupScope == dedupScopeCategory && record.Category != category {
+			continue
+		}
+		if _, err := os.Stat(record.Path); err == nil {
+			existing = append(existing, record)
+		}
+	}
+	if len(existing) == 0 {
+		return saved, nil
+	}
 
-// Handle file messages
-func handleFileMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	// Extract category from caption if present
-	category := ""
-	customFilename := ""
+	policy := config.DuplicatePolicy
+	if policy == duplicateKeepLatest && !withinDuplicateGrace(existing, time.Now()) {
+		// Too long since the last copy for a re-send, keep it like a real duplicate
+		policy = duplicateKeepFirst
+	}
 
-	if message.Caption != "" {
-		parts := strings.Split(message.Caption, " ")
-		if len(parts) > 0 && strings.HasPrefix(parts[0], "/") {
-			requestedCategory := strings.TrimPrefix(parts[0], "/")
-			if _, ok := categoryMap[requestedCategory]; ok {
-				category = requestedCategory
+	switch policy {
+	case duplicateKeepFirst:
+		if saved.Path != existing[0].Path {
+			os.Remove(saved.Path)
+		}
+		return savedFile{}, &duplicateFileError{Existing: existing[0]}
+	case duplicateKeepLatest:
+		for _, record := range existing {
+			// Copies of other users and in read-only categories stay, both are kept
+			if record.UserID != userID || getCategoryConfig(record.Category).ReadOnly {
+				continue
 			}
-
-			// Check if custom filename is provided after category
-			if len(parts) > 1 {
-				customFilename = strings.Join(parts[1:], " ")
+			if err := metadata.RemoveRecord(record); err != nil {
+				log.Printf("Error removing metadata for %s: %v", record.Path, err)
+			}
+			// Content storage shares one file between uploads, remove it only when unused
+			if record.Path != saved.Path && !metadata.Referenced(record.Path) {
+				if err := os.Remove(record.Path); err != nil {
+					log.Printf("Error removing older duplicate %s: %v", record.Path, err)
+				}
 			}
+			log.Printf("Replaced duplicate %s with %s", record.Path, saved.Path)
 		}
 	}
+	return saved, nil
+}
 
-	// If no category specified in caption, check for user default
-	if category == "" {
-		if defaultCat, hasDefault := userDefaults[message.From.ID]; hasDefault {
-			category = defaultCat
-		} else {
-			// If no default, determine based on file type
-			category = determineCategory(message)
+// Check if the latest of existing copies was saved within the keep-latest grace period
+func withinDuplicateGrace(existing []FileRecord, now time.Time) bool {
+	if config.DuplicateGrace <= 0 {
+		return true
+	}
+	var latest time.Time
+	for _, record := range existing {
+		if record.SavedAt.After(latest) {
+			latest = record.SavedAt
 		}
 	}
+	return now.Sub(latest) <= time.Duration(config.DuplicateGrace)*time.Second
+}
 
-	// Get file info
-	fileID, originalFilename := getFileInfo(message)
-	if fileID == "" {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Could not process this file.")
-		bot.Send(msg)
-		return
+// Acquire a write slot for category, returns function releasing it
+func acquireCategorySlot(category string) func() {
+	limit := getCategoryConfig(category).MaxConcurrent
+	if limit <= 0 {
+		return func() {}
 	}
 
-	// Use custom filename if provided, otherwise use original
-	filename := originalFilename
-	if customFilename != "" {
-		// Keep the original extension if present
-		originalExt := filepath.Ext(originalFilename)
-		customExt := filepath.Ext(customFilename)
-
-		if customExt == "" && originalExt != "" {
-			customFilename += originalExt
-		}
-		filename = customFilename
+	categorySemaphoresMu.Lock()
+	semaphore, ok := categorySemaphores[category]
+	if !ok {
+		semaphore = make(chan struct{}, limit)
+		categorySemaphores[category] = semaphore
 	}
+	categorySemaphoresMu.Unlock()
 
-	// Get storage path for category
-	storagePath, ok := categoryMap[category]
-	if !ok {
-		// Fallback to misc if category not found (should not happen)
-		storagePath = categoryMap["other"]
-		if storagePath == "" {
-			storagePath = "./files/misc"
-		}
+	semaphore <- struct{}{}
+	return func() { <-semaphore }
+}
+
+// Lock picking file names, parallel saves must not choose the same free name
+func lockStorageNames() func() {
+	storageNamesMu.Lock()
+	return storageNamesMu.Unlock
+}
+
+// Wait until no other save downloads the file, its partial file is shared; returns function releasing it
+func lockFileDownload(fileID string) func() {
+	downloadingMu.Lock()
+	for downloading[fileID] {
+		downloadingCond.Wait()
+	}
+	downloading[fileID] = true
+	downloadingMu.Unlock()
+
+	return func() {
+		downloadingMu.Lock()
+		delete(downloading, fileID)
+		downloadingMu.Unlock()
+		downloadingCond.Broadcast()
 	}
+}
 
-	// Status message to user
-	statusMsg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Saving file '%s' to category '%s' (path: %s)...", filename, category, storagePath))
-	statusMessage, _ := bot.Send(statusMsg)
+// Acquire one of the global download slots, returns function releasing it
+func acquireDownloadSlot() func() {
+	if config.Download.MaxConcurrent <= 0 {
+		return func() {}
+	}
 
-	// Download and save the file
-	savedPath, err := downloadAndSaveFile(bot, fileID, storagePath, filename)
-	if err != nil {
-		errorMsg := tgbotapi.NewEditMessageText(message.Chat.ID, statusMessage.MessageID, fmt.Sprintf("Error saving file: %s", err.Error()))
-		bot.Send(errorMsg)
-		return
+	categorySemaphoresMu.Lock()
+	if downloadSemaphore == nil {
+		downloadSemaphore = make(chan struct{}, config.Download.MaxConcurrent)
 	}
+	semaphore := downloadSemaphore
+	categorySemaphoresMu.Unlock()
 
-	// Success message
-	successMsg := tgbotapi.NewEditMessageText(
-		message.Chat.ID,
-		statusMessage.MessageID,
-		fmt.Sprintf("File saved successfully!\nCategory: %s\nLocation: %s", category, savedPath),
-	)
-	bot.Send(successMsg)
+	semaphore <- struct{}{}
+	return func() { <-semaphore }
 }
 
-// Get file info (ID and filename) from message
-func getFileInfo(message *tgbotapi.Message) (string, string) {
-	if message.Document != nil {
-		return message.Document.FileID, message.Document.FileName
-	} else if len(message.Photo) > 0 {
-		// Get the largest photo (last in the array)
-		photo := message.Photo[len(message.Photo)-1]
-		// Photos don't have filenames, generate one based on date
-		return photo.FileID, fmt.Sprintf("photo_%d.jpg", time.Now().Unix())
-	} else if message.Video != nil {
-		filename := message.Video.FileName
-		if filename == "" {
-			filename = fmt.Sprintf("video_%d.mp4", time.Now().Unix())
+// Run operation, retrying transient failures as configured for downloads
+func withRetry(operation string, isTransient func(error) bool, fn func() error) error {
+	delay := time.Duration(config.Download.RetryDelay) * time.Second
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt >= config.Download.Retries {
+			return err
 		}
-		return message.Video.FileID, filename
-	} else if message.Audio != nil {
-		filename := message.Audio.FileName
-		if filename == "" {
-			filename = fmt.Sprintf("audio_%d.mp3", time.Now().Unix())
+
+		wait := delay
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = time.Duration(apiErr.RetryAfter) * time.Second
 		}
-		return message.Audio.FileID, filename
-	} else if message.Voice != nil {
-		return message.Voice.FileID, fmt.Sprintf("voice_%d.ogg", time.Now().Unix())
-	} else if message.VideoNote != nil {
-		return message.VideoNote.FileID, fmt.Sprintf("video_note_%d.mp4", time.Now().Unix())
+		log.Printf("Error trying to %s (attempt %d), retrying in %s: %v", operation, attempt+1, wait, err)
+		time.Sleep(wait)
 	}
-	return "", ""
 }
 
-// Determine category based on file type
-func determineCategory(message *tgbotapi.Message) string {
-	if message.Document != nil {
-		return "document"
-	} else if len(message.Photo) > 0 {
-		return "image"
-	} else if message.Video != nil || message.VideoNote != nil {
-		return "video"
-	} else if message.Audio != nil || message.Voice != nil {
-		return "audio"
+// Check if Telegram API error is worth retrying
+func isTransientAPIError(err error) bool {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return true // Network errors
 	}
-	return "other"
+	// Client errors such as invalid file ID or file too big are permanent, except rate limiting
+	return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
 }
 
-// Download and save file
-func downloadAndSaveFile(bot *tgbotapi.BotAPI, fileID, storagePath, filename string) (string, error) {
-	// Get file URL
-	fileURL, err := bot.GetFileDirectURL(fileID)
-	if err != nil {
-		return "", fmt.Errorf("error getting file URL: %w", err)
+// Check if Telegram refused the file because of its size
+func isFileTooBigError(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && strings.Contains(strings.ToLower(apiErr.Message), "file is too big")
+}
+
+// Move downloaded temporary file to <root>/<sha256[:2]>/<sha256>
+func moveToContentPath(tmpFile *os.File, storagePath string, saved savedFile) (savedFile, error) {
+	tmpPath := tmpFile.Name()
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return savedFile{}, fmt.Errorf("error writing file: %w", err)
 	}
 
-	// Sanitize filename
-	safeFilename := sanitizeFilename(filename)
+	dir := filepath.Join(storagePath, saved.SHA256[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		os.Remove(tmpPath)
+		return savedFile{}, fmt.Errorf("error creating directory: %w", err)
+	}
 
-	// Create directory
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		return "", fmt.Errorf("error creating directory: %w", err)
+	// Identical content is already stored, keep the existing copy
+	saved.Path = filepath.Join(dir, saved.SHA256)
+	if _, err := os.Stat(saved.Path); err == nil {
+		os.Remove(tmpPath)
+		return saved, nil
 	}
 
-	// Create unique filename if file already exists
-	finalPath := filepath.Join(storagePath, safeFilename)
-	finalPath = ensureUniqueFilename(finalPath)
+	if err := os.Rename(tmpPath, saved.Path); err != nil {
+		os.Remove(tmpPath)
+		return savedFile{}, fmt.Errorf("error moving file: %w", err)
+	}
+	return saved, nil
+}
 
-	// Download file
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		return "", fmt.Errorf("error downloading file: %w", err)
+// Get error for a download response that does not carry file content
+func checkDownloadStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return downloadError(&httpStatusError{Code: resp.StatusCode, Status: resp.Status})
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	// Create file
-	outFile, err := os.Create(finalPath)
+// Fetch URL from byte offset using configured User-Agent and extra headers
+func fetchURL(url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return "", fmt.Errorf("error creating file: %w", err)
+		return nil, redactError(err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	defer outFile.Close()
 
-	// Copy data
-	_, err = io.Copy(outFile, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error writing file: %w", err)
+	userAgent := config.Download.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for name, value := range config.Download.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if config.Download.LogURLs {
+		log.Printf("Downloading %s", redactToken(url))
 	}
 
-	return finalPath, nil
+	resp, err := http.DefaultClient.Do(req)
+	return resp, redactError(err)
+}
+
+// Replace bot tokens embedded in Telegram API and file URLs
+func redactToken(s string) string {
+	return botTokenPattern.ReplaceAllString(s, "bot<redacted>")
+}
+
+// redactedError hides bot tokens in the message of the wrapped error
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string { return redactToken(e.err.Error()) }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Wrap error so its message never reveals the bot token
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
 }
 
 // Create storage directories
 func createStorageDirectories() {
-	for _, path := range categoryMap {
+	for _, cat := range getCategories() {
+		path := categoryRoot(cat.Name)
 		if err := os.MkdirAll(path, 0755); err != nil {
 			log.Printf("Error creating directory %s: %v", path, err)
 		}
 	}
 }
 
+// Get sanitization rules for category, its own rules add to the global ones
+func sanitizeRules(category string) SanitizeConfig {
+	rules := config.Sanitize
+	own := getCategoryConfig(category).Sanitize
+	if own.Replacement != "" {
+		rules.Replacement = own.Replacement
+	}
+	rules.ReplaceSpaces = rules.ReplaceSpaces || own.ReplaceSpaces
+	rules.StripChars += own.StripChars
+	rules.StripEmoji = rules.StripEmoji || own.StripEmoji
+	if own.MaxLength > 0 && (rules.MaxLength <= 0 || own.MaxLength < rules.MaxLength) {
+		rules.MaxLength = own.MaxLength
+	}
+	return rules
+}
+
+// Get maximum filename length in bytes allowed by rules
+func filenameLimit(rules SanitizeConfig) int {
+	if rules.MaxLength > 0 && rules.MaxLength < maxFilenameLength {
+		return rules.MaxLength
+	}
+	return maxFilenameLength
+}
+
 // Sanitize filename to make it safe for filesystem
-func sanitizeFilename(filename string) string {
-	// List of invalid characters in filenames
-	invalidChars := []string{"\\", "/", ":", "*", "?", "\"", "<", ">", "|"}
+func sanitizeFilename(filename string, rules SanitizeConfig) string {
+	replacement := rules.Replacement
+	if replacement == "" {
+		replacement = "_"
+	}
+
+	var b strings.Builder
+	for _, r := range filename {
+		switch {
+		case strings.ContainsRune(invalidFilenameChars, r):
+			b.WriteString(replacement)
+		case rules.ReplaceSpaces && unicode.IsSpace(r):
+			b.WriteString(replacement)
+		case strings.ContainsRune(rules.StripChars, r):
+		case rules.StripEmoji && isEmojiRune(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	result := b.String()
+
+	// Names of only dots refer to directories, never use them as filenames
+	if result != "" && isDirectoryName(result) {
+		result = strings.Repeat(replacement, len(result))
+	}
 
-	result := filename
-	for _, char := range invalidChars {
-		result = strings.ReplaceAll(result, char, "_")
+	// Stripping must not leave only an extension, which would make a hidden file
+	if strings.TrimSuffix(result, filepath.Ext(result)) == "" && strings.TrimSuffix(filename, filepath.Ext(filename)) != "" {
+		result = "file" + result
 	}
 
-	// Limit filename length
-	if len(result) > 240 {
+	// Limit filename length, keeping the extension when it fits
+	if limit := filenameLimit(rules); len(result) > limit {
 		ext := filepath.Ext(result)
-		result = result[:240-len(ext)] + ext
+		if len(ext) >= limit {
+			ext = ""
+		}
+		cut := limit - len(ext)
+		for cut > 0 && !utf8.RuneStart(result[cut]) {
+			cut--
+		}
+		result = result[:cut] + ext
 	}
 
 	return result
 }
 
+// Check if name is empty or only dots and spaces, which file systems treat as the current or parent directory
+func isDirectoryName(name string) bool {
+	return strings.Trim(name, ". ") == ""
+}
+
+// Check that filename names a file inside the category root once sanitized
+func validateFilename(filename, category string) error {
+	if isDirectoryName(filename) {
+		return fmt.Errorf("'%s' is not a file name", filename)
+	}
+
+	root := filepath.Clean(categoryRoot(category))
+	path := filepath.Clean(filepath.Join(root, sanitizeFilename(filename, sanitizeRules(category))))
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return fmt.Errorf("'%s' would be saved outside the category folder", filename)
+	}
+	return nil
+}
+
+// Check if rune is an emoji or a modifier joining emoji sequences
+func isEmojiRune(r rune) bool {
+	return unicode.Is(unicode.So, r) || (r >= 0x1F3FB && r <= 0x1F3FF) || r == 0x200D || (r >= 0xFE00 && r <= 0xFE0F)
+}
+
 // Ensure filename is unique by adding number if needed
 func ensureUniqueFilename(filePath string) string {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	return uniqueFilename(filePath, fileExists)
+}
+
+// Check if anything exists at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// Add a number or random suffix to filePath until taken reports it free
+func uniqueFilename(filePath string, taken func(string) bool) string {
+	if !taken(filePath) {
 		return filePath // File doesn't exist, use as is
 	}
 
@@ -467,27 +3103,84 @@ func ensureUniqueFilename(filePath string) string {
 	ext := filepath.Ext(filePath)
 	name := filepath.Base(filePath[:len(filePath)-len(ext)])
 
-	for i := 1; ; i++ {
+	maxAttempts := config.MaxUniqueSuffix
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxUniqueSuffix
+	}
+
+	for i := 1; i <= maxAttempts; i++ {
 		newPath := filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, i, ext))
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
+		if !taken(newPath) {
+			return newPath
+		}
+	}
+
+	// Too many numbered copies, use a timestamp and random suffix instead
+	for {
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		newPath := filepath.Join(dir, fmt.Sprintf("%s_%d_%s%s", name, time.Now().Unix(), hex.EncodeToString(suffix), ext))
+		if !taken(newPath) {
 			return newPath
 		}
 	}
 }
 
-// Read bot token from .env file
-func readBotTokenFromEnvFile() string {
-	// Check if .env file exists
-	envFile := ".env"
-	if _, err := os.Stat(envFile); os.IsNotExist(err) {
-		return "" // File doesn't exist
+// Check bot token shape without revealing it, so typos fail before any API call
+func validateBotToken(token string) error {
+	if botTokenFormat.MatchString(token) {
+		return nil
+	}
+
+	id, secret, found := strings.Cut(token, ":")
+	switch {
+	case strings.TrimSpace(token) != token:
+		return errors.New("token has leading or trailing whitespace")
+	case !found:
+		return errors.New("token has no colon, expected <bot ID>:<secret>")
+	case id == "" || strings.Trim(id, "0123456789") != "":
+		return errors.New("bot ID before the colon must be numeric")
+	case len(secret) != 35:
+		return fmt.Errorf("secret after the colon has %d characters, expected 35", len(secret))
+	default:
+		return errors.New("secret after the colon may only contain letters, digits, _ and -")
+	}
+}
+
+// Describe where environment variable came from
+func envSource(key string) string {
+	if file, ok := envFileSources[key]; ok {
+		return file
+	}
+	return "environment"
+}
+
+// Load variables from env files listed in ENV_FILES (default .env), later files override earlier ones
+func loadEnvFiles() {
+	files := []string{defaultEnvFile}
+	if list := os.Getenv("ENV_FILES"); list != "" {
+		files = strings.Split(list, ",")
+	}
+
+	for _, envFile := range files {
+		envFile = strings.TrimSpace(envFile)
+		if envFile == "" {
+			continue
+		}
+		if err := loadEnvFile(envFile); err != nil {
+			if os.IsNotExist(err) {
+				continue // File doesn't exist
+			}
+			log.Printf("Error reading env file %s: %v", envFile, err)
+		}
 	}
+}
 
-	// Read file content
+// Set environment variables from KEY=value lines of env file, overriding existing values
+func loadEnvFile(envFile string) error {
 	data, err := ioutil.ReadFile(envFile)
 	if err != nil {
-		log.Printf("Error reading .env file: %v", err)
-		return ""
+		return err
 	}
 
 	// Parse file content line by line
@@ -499,17 +3192,18 @@ func readBotTokenFromEnvFile() string {
 			continue
 		}
 
-		// Look for TELEGRAM_BOT_TOKEN=value
-		if strings.HasPrefix(line, "TELEGRAM_BOT_TOKEN=") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				token := strings.TrimSpace(parts[1])
-				// Remove quotes if present
-				token = strings.Trim(token, "\"'")
-				return token
-			}
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		// Remove quotes if present
+		value = strings.Trim(strings.TrimSpace(value), "\"'")
+		if err := os.Setenv(key, value); err != nil {
+			return err
 		}
+		envFileSources[key] = envFile
 	}
-
-	return "" // Token not found in .env file
+	log.Printf("Loaded environment from %s", envFile)
+	return nil
 }
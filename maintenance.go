@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MaintenanceConfig represents limits for background jobs such as trash purge and partial download cleanup
+type MaintenanceConfig struct {
+	Workers    int    `yaml:"workers"`     // Files processed in parallel, default 1
+	PauseHours string `yaml:"pause_hours"` // Local hours when jobs wait, e.g. 08-20 or 22-06
+	JobTimeout int    `yaml:"job_timeout"` // Seconds a single run may take before it stops, 0 means unlimited
+}
+
+const maintenancePausePoll = time.Minute // How often a paused job checks whether it may run
+
+var (
+	maintenanceMu sync.Mutex // Held while maintenanceCtx is read or replaced
+
+	// Parent of running jobs, replaced when categories change so jobs stop working on old paths
+	maintenanceCtx, maintenanceCancel = context.WithCancel(context.Background())
+)
+
+// Parse pause hours "HH-HH" into start and end hour, the end is exclusive and may wrap past midnight
+func parsePauseHours(value string) (start, end int, err error) {
+	if _, err := fmt.Sscanf(value, "%d-%d", &start, &end); err != nil {
+		return 0, 0, fmt.Errorf("invalid pause hours %q, expected e.g. 08-20", value)
+	}
+	if start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+		return 0, 0, fmt.Errorf("invalid pause hours %q, hours must be 0-24 and differ", value)
+	}
+	return start, end, nil
+}
+
+// Check if background jobs are paused at time
+func maintenancePaused(now time.Time) bool {
+	pauseHours := config.Maintenance.PauseHours
+	if pauseHours == "" {
+		return false
+	}
+	start, end, err := parsePauseHours(pauseHours)
+	if err != nil {
+		return false
+	}
+	hour := now.Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// Block until background jobs are no longer paused
+func waitForMaintenanceWindow() {
+	for maintenancePaused(time.Now()) {
+		time.Sleep(maintenancePausePoll)
+	}
+}
+
+// Start a run of a background job, limited by the configured timeout and stopped when categories change
+func startMaintenanceJob() (context.Context, context.CancelFunc) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	if config.Maintenance.JobTimeout > 0 {
+		return context.WithTimeout(maintenanceCtx, time.Duration(config.Maintenance.JobTimeout)*time.Second)
+	}
+	return context.WithCancel(maintenanceCtx)
+}
+
+// Stop running background jobs, later runs use the current configuration
+func cancelMaintenance(reason string) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	maintenanceCancel()
+	maintenanceCtx, maintenanceCancel = context.WithCancel(context.Background())
+	log.Printf("Stopped running background jobs: %s", reason)
+}
+
+// Run work for every item with at most the configured number of workers.
+// Once ctx is done no further items start, items already started finish so each is fully processed or untouched.
+func runMaintenance[T any](ctx context.Context, items []T, work func(T)) error {
+	workers := config.Maintenance.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	for _, item := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			work(item)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
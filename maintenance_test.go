@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestCategoryImportCancelsRunningJob(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Admins = []int64{1}
+	fks := newFakeSender(t)
+	fks.addFile("import", []byte(fmt.Sprintf("categories:\n  - name: docs\n    path: %s\n", filepath.Join(dir, "archive"))))
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	var processed atomic.Int32
+	result := make(chan error)
+
+	ctx, cancel := startMaintenanceJob()
+	defer cancel()
+	go func() {
+		result <- runMaintenance(ctx, []int{1, 2, 3, 4, 5}, func(item int) {
+			if processed.Add(1) == 1 {
+				close(started)
+				<-proceed
+			}
+		})
+	}()
+
+	<-started
+	importMessage := commandMessage("/importcategories")
+	importMessage.ReplyToMessage = &tgbotapi.Message{Document: &tgbotapi.Document{FileID: "import", FileName: "categories.yml"}}
+	handleImportCategoriesCommand(fks, importMessage)
+	close(proceed)
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("runMaintenance error = %v, want context.Canceled", err)
+	}
+	if got := processed.Load(); got != 1 {
+		t.Errorf("processed %d items, want only the one in progress", got)
+	}
+
+	// Later runs are not affected by the cancelled one
+	ctx, cancel = startMaintenanceJob()
+	defer cancel()
+	if err := runMaintenance(ctx, []int{1}, func(int) {}); err != nil {
+		t.Errorf("next run error = %v", err)
+	}
+}
+
+func TestRunMaintenanceWorkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		workers  int
+		wantPeak int32
+	}{
+		{name: "default", workers: 0, wantPeak: 1},
+		{name: "one worker", workers: 1, wantPeak: 1},
+		{name: "three workers", workers: 3, wantPeak: 3},
+		{name: "more workers than items", workers: 20, wantPeak: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.Maintenance.Workers = tt.workers
+
+			var inFlight, peak, processed atomic.Int32
+			items := make([]int, 8)
+			err := runMaintenance(context.Background(), items, func(int) {
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				inFlight.Add(-1)
+				processed.Add(1)
+			})
+			if err != nil {
+				t.Fatalf("runMaintenance: %v", err)
+			}
+			if got := processed.Load(); got != int32(len(items)) {
+				t.Errorf("processed %d of %d items", got, len(items))
+			}
+			if got := peak.Load(); got != tt.wantPeak {
+				t.Errorf("peak workers = %d, want %d", got, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestRunMaintenanceJobTimeout(t *testing.T) {
+	setupTestBot(t)
+	config.Maintenance.JobTimeout = 1
+
+	ctx, cancel := startMaintenanceJob()
+	defer cancel()
+	var processed atomic.Int32
+	err := runMaintenance(ctx, make([]int, 10), func(int) {
+		processed.Add(1)
+		time.Sleep(300 * time.Millisecond)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runMaintenance error = %v, want context.DeadlineExceeded", err)
+	}
+	if got := processed.Load(); got < 3 || got > 5 {
+		t.Errorf("processed %d items in one second, want about 4", got)
+	}
+}
+
+func TestParsePauseHours(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantStart int
+		wantEnd   int
+		wantErr   bool
+	}{
+		{value: "08-20", wantStart: 8, wantEnd: 20},
+		{value: "22-06", wantStart: 22, wantEnd: 6},
+		{value: "0-24", wantStart: 0, wantEnd: 24},
+		{value: "8-8", wantErr: true},
+		{value: "24-06", wantErr: true},
+		{value: "08-25", wantErr: true},
+		{value: "-1-06", wantErr: true},
+		{value: "night", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := parsePauseHours(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePauseHours(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.wantStart || end != tt.wantEnd) {
+			t.Errorf("parsePauseHours(%q) = %d, %d, want %d, %d", tt.value, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestMaintenancePaused(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2024, 6, 1, hour, 30, 0, 0, time.Local) }
+	tests := []struct {
+		pauseHours string
+		hour       int
+		want       bool
+	}{
+		{pauseHours: "", hour: 12, want: false},
+		{pauseHours: "08-20", hour: 7, want: false},
+		{pauseHours: "08-20", hour: 8, want: true},
+		{pauseHours: "08-20", hour: 19, want: true},
+		{pauseHours: "08-20", hour: 20, want: false},
+		{pauseHours: "22-06", hour: 23, want: true},
+		{pauseHours: "22-06", hour: 3, want: true},
+		{pauseHours: "22-06", hour: 6, want: false},
+		{pauseHours: "22-06", hour: 12, want: false},
+		{pauseHours: "invalid", hour: 12, want: false},
+	}
+	for _, tt := range tests {
+		config.Maintenance.PauseHours = tt.pauseHours
+		if got := maintenancePaused(at(tt.hour)); got != tt.want {
+			t.Errorf("maintenancePaused(%q at %d:30) = %v, want %v", tt.pauseHours, tt.hour, got, tt.want)
+		}
+	}
+	config.Maintenance.PauseHours = ""
+}
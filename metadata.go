@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultMetadataPath = "./files/metadata.json" // Path to metadata of saved files
+
+// FileRecord represents metadata of a saved file
+type FileRecord struct {
+	Path     string            `json:"path"`
+	Name     string            `json:"name"` // Original filename, may differ from the stored one
+	Category string            `json:"category"`
+	Size     int64             `json:"size"`
+	SHA256   string            `json:"sha256"`
+	Tags     map[string]string `json:"tags,omitempty"` // Metadata from key=value caption tokens
+	UserID   int64             `json:"user_id"`
+	ChatID   int64             `json:"chat_id"`
+	ChatType string            `json:"chat_type,omitempty"` // private, group, supergroup or channel
+	SavedAt  time.Time         `json:"saved_at"`
+
+	MessageID int `json:"message_id,omitempty"` // Bot's confirmation message for the upload
+
+	// Set while the file is in trash, Path then points into the trash directory
+	OriginalPath string     `json:"original_path,omitempty"`
+	TrashedAt    *time.Time `json:"trashed_at,omitempty"`
+}
+
+// metadataStore keeps records of saved files persisted as a JSON file
+type metadataStore struct {
+	mu      sync.Mutex
+	path    string
+	records []FileRecord
+}
+
+var metadata = &metadataStore{}
+
+// Load metadata from file, missing file means empty store
+func loadMetadata(path string) error {
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+
+	metadata.path = path
+	metadata.records = nil
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &metadata.records)
+}
+
+// Add record and persist the store
+func (s *metadataStore) Add(record FileRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	return s.save()
+}
+
+// Write records to file atomically, caller must hold the lock
+func (s *metadataStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// Write file via temporary file and rename so readers never see partial data
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Records returns a copy of all records
+func (s *metadataStore) Records() []FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]FileRecord, len(s.records))
+	copy(records, s.records)
+	return records
+}
+
+// FindByHash returns records of files with the given content hash
+func (s *metadataStore) FindByHash(hash string) []FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []FileRecord
+	for _, record := range s.records {
+		if record.SHA256 == hash {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// RemovePath removes records of file at path and persists the store
+func (s *metadataStore) RemovePath(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.records[:0]
+	for _, record := range s.records {
+		if record.Path != path {
+			kept = append(kept, record)
+		}
+	}
+	s.records = kept
+	return s.save()
+}
+
+// RemoveRecord drops record alone, other records of the same file are kept
+func (s *metadataStore) RemoveRecord(record FileRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.records[:0]
+	for _, r := range s.records {
+		if !sameRecord(r, record) {
+			kept = append(kept, r)
+		}
+	}
+	s.records = kept
+	return s.save()
+}
+
+// Referenced reports whether any record still points at path, trashed records point into trash
+func (s *metadataStore) Referenced(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if record.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Shared reports whether another upload outside trash uses the file of record
+func (s *metadataStore) Shared(record FileRecord) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.records {
+		if r.Path == record.Path && r.TrashedAt == nil && !sameRecord(r, record) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a and b describe the same upload, content storage gives several uploads one path
+func sameRecord(a, b FileRecord) bool {
+	return a.Path == b.Path && a.UserID == b.UserID && a.ChatID == b.ChatID &&
+		a.MessageID == b.MessageID && a.SavedAt.Equal(b.SavedAt)
+}
+
+// FindByPath returns the latest record of file at path, empty if unknown
+func (s *metadataStore) FindByPath(path string) FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.records) - 1; i >= 0; i-- {
+		if s.records[i].Path == path {
+			return s.records[i]
+		}
+	}
+	return FileRecord{}
+}
+
+// FindByMessage returns records of files confirmed by the bot's message in chat
+func (s *metadataStore) FindByMessage(chatID int64, messageID int) []FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []FileRecord
+	for _, record := range s.records {
+		if record.ChatID == chatID && record.MessageID == messageID {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// Trash marks record as moved to trashPath and persists the store, other uploads of the file stay
+func (s *metadataStore) Trash(record FileRecord, trashPath string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.records {
+		if sameRecord(s.records[i], record) && s.records[i].TrashedAt == nil {
+			s.records[i].OriginalPath = record.Path
+			s.records[i].Path = trashPath
+			s.records[i].TrashedAt = &at
+		}
+	}
+	return s.save()
+}
+
+// Move updates record to newPath in category and persists the store, other uploads of the file stay
+func (s *metadataStore) Move(record FileRecord, newPath, category string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.records {
+		if sameRecord(s.records[i], record) && s.records[i].TrashedAt == nil {
+			s.records[i].Path = newPath
+			s.records[i].Category = category
+		}
+	}
+	return s.save()
+}
+
+// ReplaceMessage points records confirmed by messageID in chat to newMessageID and persists the store
+func (s *metadataStore) ReplaceMessage(chatID int64, messageID, newMessageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.records {
+		if s.records[i].ChatID == chatID && s.records[i].MessageID == messageID {
+			s.records[i].MessageID = newMessageID
+		}
+	}
+	return s.save()
+}
+
+// Restore marks trashed record as active at path and persists the store
+func (s *metadataStore) Restore(record FileRecord, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.records {
+		if sameRecord(s.records[i], record) && s.records[i].TrashedAt != nil {
+			s.records[i].Path = path
+			s.records[i].OriginalPath = ""
+			s.records[i].TrashedAt = nil
+		}
+	}
+	return s.save()
+}
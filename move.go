@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Move files confirmed by the replied-to message to category, true when the reply referred to saved files
+func handleMoveByReply(bot Sender, message *tgbotapi.Message, category string) bool {
+	records := metadata.FindByMessage(message.Chat.ID, message.ReplyToMessage.MessageID)
+	if len(records) == 0 {
+		return false
+	}
+
+	// Only the uploader or an administrator may move
+	if records[0].UserID != message.From.ID && !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "You can only move files you saved yourself.")
+		bot.Send(msg)
+		return true
+	}
+	if records[0].Category == category {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("The file is already in category '%s'.", category))
+		bot.Send(msg)
+		return true
+	}
+	if !checkCategoryWritable(bot, message, records[0].Category) || !checkCategoryWritable(bot, message, category) {
+		return true
+	}
+
+	var moved, missing []string
+	for _, record := range records {
+		if record.TrashedAt != nil {
+			missing = append(missing, record.Name)
+			continue
+		}
+
+		newPath, err := moveRecordToCategory(record, category)
+		if os.IsNotExist(err) {
+			missing = append(missing, record.Name)
+			if err := metadata.RemoveRecord(record); err != nil {
+				log.Printf("Error removing metadata for %s: %v", record.Path, err)
+			}
+			continue
+		}
+		if err != nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error moving file: %s", err.Error()))
+			bot.Send(msg)
+			return true
+		}
+		moved = append(moved, filepath.Base(newPath))
+		log.Printf("File %s moved to %s by user %d", record.Path, newPath, message.From.ID)
+	}
+
+	text := ""
+	if len(moved) > 0 {
+		text = fmt.Sprintf("Moved to category '%s': %s", category, strings.Join(moved, ", "))
+	}
+	if len(missing) > 0 {
+		text = strings.TrimSpace(text + fmt.Sprintf("\nNo longer exists: %s", strings.Join(missing, ", ")))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	bot.Send(msg)
+	return true
+}
+
+// Move saved file into category under a unique name, returning its new path
+func moveRecordToCategory(record FileRecord, category string) (string, error) {
+	if _, err := os.Stat(record.Path); err != nil {
+		return "", err
+	}
+
+	// Keep the date folders of the original save and the uploader's folder
+	dir := renderPathTemplate(getStoragePath(category), category, record.SavedAt)
+	if config.UserFolders && config.StorageLayout != storageLayoutContent {
+		dir = filepath.Join(dir, filepath.Base(filepath.Dir(record.Path)))
+	}
+
+	// Content storage names files by hash, the category may already hold the same content
+	var newPath string
+	if config.StorageLayout == storageLayoutContent && record.SHA256 != "" {
+		dir = filepath.Join(dir, record.SHA256[:2])
+		newPath = filepath.Join(dir, record.SHA256)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+
+	newPath, err := placeMovedFile(record, dir, newPath, category)
+	if err != nil {
+		return "", err
+	}
+	return newPath, metadata.Move(record, newPath, category)
+}
+
+// Move or link file of record into dir, picking a free name unless newPath is already set
+func placeMovedFile(record FileRecord, dir, newPath, category string) (string, error) {
+	// Saves running meanwhile must not pick the same name
+	unlockNames := lockStorageNames()
+	defer unlockNames()
+
+	// Other uploads of a shared file keep it in place, this one gets its own copy
+	shared := metadata.Shared(record)
+	switch {
+	case newPath != "" && fileExists(newPath):
+		if !shared && newPath != record.Path {
+			os.Remove(record.Path)
+		}
+	case shared:
+		if newPath == "" {
+			newPath = buildFilePath(dir, filepath.Base(record.Path), category)
+		}
+		if err := os.Link(record.Path, newPath); err != nil {
+			if err := copyFile(record.Path, newPath); err != nil {
+				return "", err
+			}
+		}
+	default:
+		if newPath == "" {
+			newPath = buildFilePath(dir, filepath.Base(record.Path), category)
+		}
+		if err := moveFile(record.Path, newPath); err != nil {
+			return "", err
+		}
+	}
+	return newPath, nil
+}
+
+// Rename file, copying it when source and destination are on different filesystems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Copy file contents to a new file at dst, removing it again on failure
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMoveRecordToCategory(t *testing.T) {
+	dir := setupTestBot(t, "docs", "archive")
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+
+	newPath, err := moveRecordToCategory(record, "archive")
+	if err != nil {
+		t.Fatalf("moveRecordToCategory: %v", err)
+	}
+
+	if want := filepath.Join(dir, "archive", "report.txt"); newPath != want {
+		t.Errorf("new path = %s, want %s", newPath, want)
+	}
+	if fileExists(record.Path) || !fileExists(newPath) {
+		t.Errorf("old exists %v, new exists %v", fileExists(record.Path), fileExists(newPath))
+	}
+	if moved := recordByMessage(t, 10); moved.Path != newPath || moved.Category != "archive" {
+		t.Errorf("record = %+v", moved)
+	}
+}
+
+func TestMoveDuringSaves(t *testing.T) {
+	dir := setupTestBot(t, "docs", "archive")
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+	fks := newFakeSender(t)
+
+	const saves = 10
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		fileID := fmt.Sprintf("file-%d", i)
+		fks.addFile(fileID, []byte(fileID))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := documentMessage(fileID, "report.txt", "/archive", len(fileID))
+			message.MessageID = 100 + i
+			handleFileMessage(fks, message)
+		}()
+	}
+	if _, err := moveRecordToCategory(record, "archive"); err != nil {
+		t.Fatalf("moveRecordToCategory: %v", err)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files int
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			files++
+		}
+	}
+	if files != saves+1 {
+		t.Errorf("%d files in category, want %d", files, saves+1)
+	}
+	if moved := recordByMessage(t, 10); moved.Category != "archive" {
+		t.Errorf("record = %+v", moved)
+	} else if data, err := os.ReadFile(moved.Path); err != nil || string(data) != "same content" {
+		t.Errorf("moved file %s holds %q, %v", moved.Path, data, err)
+	}
+}
+
+func TestMoveRecordToCategorySharedBlob(t *testing.T) {
+	dir := setupTestBot(t, "docs", "archive")
+	config.StorageLayout = storageLayoutContent
+
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	mine := addSavedFile(t, blob, "docs", 1, 10)
+	addSavedFile(t, blob, "docs", 2, 11)
+
+	newPath, err := moveRecordToCategory(mine, "archive")
+	if err != nil {
+		t.Fatalf("moveRecordToCategory: %v", err)
+	}
+
+	if want := filepath.Join(dir, "archive", "ab", "abc123"); newPath != want {
+		t.Errorf("new path = %s, want %s", newPath, want)
+	}
+	if !fileExists(blob) {
+		t.Error("blob used by another upload was moved away")
+	}
+	if data, err := os.ReadFile(newPath); err != nil || string(data) != "same content" {
+		t.Errorf("moved copy = %q, %v", data, err)
+	}
+	if theirs := recordByMessage(t, 11); theirs.Path != blob || theirs.Category != "docs" {
+		t.Errorf("other user's record changed: %+v", theirs)
+	}
+	if moved := recordByMessage(t, 10); moved.Path != newPath || moved.Category != "archive" {
+		t.Errorf("moved record = %+v", moved)
+	}
+}
+
+func TestMoveRecordToCategoryContentAlreadyStored(t *testing.T) {
+	dir := setupTestBot(t, "docs", "archive")
+	config.StorageLayout = storageLayoutContent
+
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	mine := addSavedFile(t, blob, "docs", 1, 10)
+	existing := addSavedFile(t, filepath.Join(dir, "archive", "ab", "abc123"), "archive", 2, 11)
+
+	newPath, err := moveRecordToCategory(mine, "archive")
+	if err != nil {
+		t.Fatalf("moveRecordToCategory: %v", err)
+	}
+
+	if newPath != existing.Path {
+		t.Errorf("new path = %s, want the stored %s", newPath, existing.Path)
+	}
+	if fileExists(blob) {
+		t.Error("unused source blob was left behind")
+	}
+	if !fileExists(existing.Path) {
+		t.Error("stored content was removed")
+	}
+}
+
+func TestHandleMoveByReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		owner     int64   // Uploader of docs/report.txt
+		admins    []int64 // Configured administrators
+		setup     func(t *testing.T, dir string)
+		category  string
+		replyTo   int
+		wantFound bool   // Reply referred to saved files
+		wantPath  string // File expected afterwards, relative to the test directory
+		wantReply string
+	}{
+		{name: "own file", owner: 1, category: "archive", replyTo: 10, wantFound: true, wantPath: "archive/report.txt", wantReply: "Moved to category 'archive': report.txt"},
+		{name: "other user's file", owner: 2, category: "archive", replyTo: 10, wantFound: true, wantPath: "docs/report.txt", wantReply: "You can only move files you saved yourself."},
+		{name: "other user's file as admin", owner: 2, admins: []int64{1}, category: "archive", replyTo: 10, wantFound: true, wantPath: "archive/report.txt", wantReply: "Moved to category 'archive'"},
+		{name: "same category", owner: 1, category: "docs", replyTo: 10, wantFound: true, wantPath: "docs/report.txt", wantReply: "already in category 'docs'"},
+		{name: "reply to other message", owner: 1, category: "archive", replyTo: 99, wantPath: "docs/report.txt"},
+		{
+			name:  "name taken in target",
+			owner: 1,
+			setup: func(t *testing.T, dir string) {
+				addSavedFile(t, filepath.Join(dir, "archive", "report.txt"), "archive", 1, 20)
+			},
+			category:  "archive",
+			replyTo:   10,
+			wantFound: true,
+			wantPath:  "archive/report_1.txt",
+			wantReply: "Moved to category 'archive': report_1.txt",
+		},
+		{
+			name:  "file removed from disk",
+			owner: 1,
+			setup: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "docs", "report.txt"))
+			},
+			category:  "archive",
+			replyTo:   10,
+			wantFound: true,
+			wantReply: "No longer exists: report.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "archive")
+			config.Admins = tt.admins
+			addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", tt.owner, 10)
+			if tt.setup != nil {
+				tt.setup(t, dir)
+			}
+			fks := newFakeSender(t)
+
+			found := handleMoveByReply(fks, moveReply("/"+tt.category, tt.replyTo), tt.category)
+
+			if found != tt.wantFound {
+				t.Errorf("found = %v, want %v", found, tt.wantFound)
+			}
+			if tt.wantPath != "" && !fileExists(filepath.Join(dir, tt.wantPath)) {
+				t.Errorf("%s missing, replies %q", tt.wantPath, fks.texts())
+			}
+			if tt.wantReply != "" && !fks.sentContaining(tt.wantReply) {
+				t.Errorf("replies %q, want %q", fks.texts(), tt.wantReply)
+			}
+			if tt.wantReply == "" && len(fks.sent) > 0 {
+				t.Errorf("unexpected replies %q", fks.texts())
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRenderDefaultFilename(t *testing.T) {
+	received := time.Date(2024, 6, 1, 9, 5, 7, 0, time.UTC)
+	tests := []struct {
+		template  string
+		generated string
+		want      string
+	}{
+		{template: "memo_{date}_{time}", generated: "voice_42.ogg", want: "memo_20240601_090507.ogg"},
+		{template: "memo_{unix}", generated: "voice_42.ogg", want: "memo_1717232707.ogg"},
+		{template: "memo_{date}.opus", generated: "voice_42.ogg", want: "memo_20240601.opus"},
+		{template: "memo", generated: "voice_42", want: "memo"},
+		{template: "{date}/{time}", generated: "voice_42.ogg", want: "20240601/090507.ogg"},
+	}
+	for _, tt := range tests {
+		if got := renderDefaultFilename(tt.template, received, tt.generated); got != tt.want {
+			t.Errorf("renderDefaultFilename(%q, %q) = %q, want %q", tt.template, tt.generated, got, tt.want)
+		}
+	}
+}
+
+func TestHandleFileMessageDefaultFilename(t *testing.T) {
+	date := time.Date(2024, 6, 1, 9, 5, 7, 0, time.Local)
+	tests := []struct {
+		name     string
+		message  func(m *tgbotapi.Message)
+		caption  string
+		wantName string
+	}{
+		{
+			name: "voice note gets the template",
+			message: func(m *tgbotapi.Message) {
+				m.Document = nil
+				m.Voice = &tgbotapi.Voice{FileID: "file-1", FileSize: 4, MimeType: "audio/ogg"}
+			},
+			caption:  "/audio",
+			wantName: "memo_20240601_090507.ogg",
+		},
+		{name: "named document keeps its name", message: func(*tgbotapi.Message) {}, caption: "/audio", wantName: "song.mp3"},
+		{
+			name: "caption name wins over the template",
+			message: func(m *tgbotapi.Message) {
+				m.Document = nil
+				m.Voice = &tgbotapi.Voice{FileID: "file-1", FileSize: 4, MimeType: "audio/ogg"}
+			},
+			caption:  "/audio reminder",
+			wantName: "reminder.ogg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "audio")
+			config.Categories[0].DefaultFilename = "memo_{date}_{time}"
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			message := documentMessage("file-1", "song.mp3", tt.caption, 4)
+			message.Date = int(date.Unix())
+			tt.message(message)
+			handleFileMessage(fks, message)
+
+			if !fileExists(filepath.Join(dir, "audio", tt.wantName)) {
+				t.Errorf("%s not saved, replies %q", tt.wantName, fks.texts())
+			}
+		})
+	}
+}
+
+func TestRenderChannelFilename(t *testing.T) {
+	posted := time.Date(2024, 6, 1, 9, 5, 7, 0, time.Local)
+	tests := []struct {
+		name      string
+		template  string
+		chat      tgbotapi.Chat
+		generated string
+		want      string
+	}{
+		{name: "username", template: "{channel}_{message_id}", chat: tgbotapi.Chat{ID: -100, UserName: "news", Title: "News"}, generated: "photo.jpg", want: "news_42.jpg"},
+		{name: "title without username", template: "{channel}_{message_id}", chat: tgbotapi.Chat{ID: -100, Title: "News"}, generated: "photo.jpg", want: "News_42.jpg"},
+		{name: "id without title", template: "{channel}_{message_id}", chat: tgbotapi.Chat{ID: -100}, generated: "photo.jpg", want: "-100_42.jpg"},
+		{name: "date and time", template: "{channel}_{date}_{time}", chat: tgbotapi.Chat{UserName: "news"}, generated: "photo.jpg", want: "news_20240601_090507.jpg"},
+		{name: "template extension kept", template: "{channel}.png", chat: tgbotapi.Chat{UserName: "news"}, generated: "photo.jpg", want: "news.png"},
+		{name: "generated name without extension", template: "{channel}", chat: tgbotapi.Chat{UserName: "news"}, generated: "voice", want: "news"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &tgbotapi.Message{ForwardFromChat: &tt.chat, ForwardFromMessageID: 42, ForwardDate: int(posted.Unix())}
+			if got := renderChannelFilename(tt.template, message, tt.generated); got != tt.want {
+				t.Errorf("renderChannelFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageChannelForward(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		from     *tgbotapi.Chat
+		original string // Original filename of the document
+		want     string // Saved name, empty when the usual name is kept
+	}{
+		{name: "channel forward", template: "{channel}_{message_id}", from: &tgbotapi.Chat{Type: "channel", UserName: "news"}, want: "news_42.txt"},
+		{name: "template not configured", from: &tgbotapi.Chat{Type: "channel", UserName: "news"}},
+		{name: "group forward", template: "{channel}_{message_id}", from: &tgbotapi.Chat{Type: "supergroup", UserName: "talk"}},
+		{name: "original filename kept", template: "{channel}_{message_id}", from: &tgbotapi.Chat{Type: "channel", UserName: "news"}, original: "report.txt", want: "report.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.ForwardedChannelName = tt.template
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+			message := documentMessage("file-1", tt.original, "/docs", 4)
+			message.Document.MimeType = "text/plain"
+			message.ForwardFromChat = tt.from
+			message.ForwardFromMessageID = 42
+			message.ForwardDate = int(time.Now().Unix())
+
+			handleFileMessage(fks, message)
+
+			records := metadata.Records()
+			if len(records) != 1 {
+				t.Fatalf("%d files saved, replies %q", len(records), fks.texts())
+			}
+			name := filepath.Base(records[0].Path)
+			if tt.want != "" && name != tt.want {
+				t.Errorf("saved as %q, want %q", name, tt.want)
+			}
+			if tt.want == "" && (name == "news_42.txt" || name == "talk_42.txt") {
+				t.Errorf("saved as %q, want the usual name", name)
+			}
+		})
+	}
+}
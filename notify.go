@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultAdminNotifyInterval = time.Minute // Minimum time between admin error notifications
+
+// Rate limiting state of admin notifications, also sent by background saves and hooks
+var (
+	adminNoticeMu         sync.Mutex
+	lastAdminNotice       time.Time
+	suppressedAdminNotice int
+)
+
+// Post error notification to the admin chat, dropping notifications sent too soon after the last one
+func notifyAdmin(bot Sender, text string) {
+	if config.AdminChatID == 0 {
+		return
+	}
+
+	adminNoticeMu.Lock()
+	defer adminNoticeMu.Unlock()
+
+	interval := time.Duration(config.AdminNotifyInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultAdminNotifyInterval
+	}
+	if time.Since(lastAdminNotice) < interval {
+		suppressedAdminNotice++
+		return
+	}
+
+	if suppressedAdminNotice > 0 {
+		text += fmt.Sprintf("\n\n%d more errors since the last notification were not reported.", suppressedAdminNotice)
+	}
+	msg := tgbotapi.NewMessage(config.AdminChatID, text)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Error notifying admin chat: %v", err)
+		return
+	}
+	lastAdminNotice = time.Now()
+	suppressedAdminNotice = 0
+}
+
+// Notify admin chat that saving file from message failed
+func notifySaveFailure(bot Sender, message *tgbotapi.Message, category, filename string, err error) {
+	// Rejected duplicates are expected, not operational issues
+	var duplicate *duplicateFileError
+	if errors.As(err, &duplicate) || errors.Is(err, errFileTooBig) {
+		return
+	}
+
+	title := "Download failed"
+	if errors.Is(err, errDiskFull) {
+		title = "Disk full"
+	} else if errors.Is(err, errStorageUnavailable) {
+		title = "Storage unavailable for category " + category
+	} else if errors.Is(err, errBlockedFile) {
+		title = "Blocked file rejected"
+	}
+
+	user := "unknown"
+	if message.From != nil {
+		user = fmt.Sprintf("%d", message.From.ID)
+		if message.From.UserName != "" {
+			user += " (@" + message.From.UserName + ")"
+		}
+	}
+
+	notifyAdmin(bot, fmt.Sprintf("%s: %v\nFile: %s\nCategory: %s\nUser: %s\nMessage: %d in chat %d",
+		title, err, filename, category, user, message.MessageID, message.Chat.ID))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleFileMessageOverwriteOwnFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		overwrite   bool
+		ownerID     int64
+		wantPath    string // Where the new content is saved
+		wantOldKept bool   // Earlier content still on disk
+	}{
+		{name: "disabled", overwrite: false, ownerID: 1, wantPath: "report_1.txt", wantOldKept: true},
+		{name: "own file replaced", overwrite: true, ownerID: 1, wantPath: "report.txt"},
+		{name: "other user's file kept", overwrite: true, ownerID: 2, wantPath: "report_1.txt", wantOldKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.OverwriteOwnFiles = tt.overwrite
+			old := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", tt.ownerID, 10)
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("new content"))
+
+			handleFileMessage(fks, documentMessage("file-1", "report.txt", "/docs", 11))
+
+			data, err := os.ReadFile(filepath.Join(dir, "docs", tt.wantPath))
+			if err != nil || string(data) != "new content" {
+				t.Fatalf("%s = %q, %v, replies %q", tt.wantPath, data, err, fks.texts())
+			}
+			if tt.wantOldKept {
+				if data, _ := os.ReadFile(old.Path); string(data) != "same content" {
+					t.Errorf("earlier file changed to %q", data)
+				}
+			}
+			wantRecords := 1
+			if tt.wantOldKept {
+				wantRecords = 2
+			}
+			if n := len(metadata.Records()); n != wantRecords {
+				t.Errorf("%d records, want %d", n, wantRecords)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultPendingTimeout = 5 * time.Minute // How long state awaiting a user's next action is kept
+	pendingSweepInterval  = time.Minute     // How often expired pending state is cleared
+)
+
+// pendingEntry represents state awaiting a user's next action and when it stops applying
+type pendingEntry[V any] struct {
+	Value   V
+	Expires time.Time
+}
+
+// pendingStore keeps state awaiting a user's next action, such as a selected category, until it expires
+type pendingStore[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]pendingEntry[V]
+}
+
+// Create empty pending store
+func newPendingStore[K comparable, V any]() *pendingStore[K, V] {
+	return &pendingStore[K, V]{entries: make(map[K]pendingEntry[V])}
+}
+
+// Put stores value under key until ttl passes, replacing any earlier value
+func (s *pendingStore[K, V]) Put(key K, value V, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = pendingEntry[V]{Value: value, Expires: time.Now().Add(ttl)}
+}
+
+// Peek returns value under key, false when missing or expired
+func (s *pendingStore[K, V]) Peek(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.Expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+// Take removes value under key and returns it, false when missing or expired
+func (s *pendingStore[K, V]) Take(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	delete(s.entries, key)
+	if !ok || time.Now().After(entry.Expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+// Delete removes value under key
+func (s *pendingStore[K, V]) Delete(key K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// Sweep removes values expired at now and returns them
+func (s *pendingStore[K, V]) Sweep(now time.Time) []V {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []V
+	for key, entry := range s.entries {
+		if now.After(entry.Expires) {
+			expired = append(expired, entry.Value)
+			delete(s.entries, key)
+		}
+	}
+	return expired
+}
+
+// Get configured inactivity timeout of pending state, specific is a more precise setting in seconds
+func pendingTimeout(specific int) time.Duration {
+	if specific > 0 {
+		return time.Duration(specific) * time.Second
+	}
+	if config.PendingTimeout > 0 {
+		return time.Duration(config.PendingTimeout) * time.Second
+	}
+	return defaultPendingTimeout
+}
+
+// Clear expired pending state in background so stale selections neither apply nor use memory
+func startPendingSweeper(bot Sender) {
+	go func() {
+		for {
+			time.Sleep(pendingSweepInterval)
+			sweepPendingState(bot, time.Now())
+		}
+	}()
+}
+
+// Clear pending state expired at now, telling senders of unanswered confirmations
+func sweepPendingState(bot Sender, now time.Time) {
+	pendingCategories.Sweep(now)
+	forwardBatches.Sweep(now)
+	expirePendingSaves(bot, now)
+	expireSplitUploads(bot, now)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPendingStoreSweep(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttls        map[string]time.Duration
+		after       time.Duration
+		wantExpired []string
+		wantKept    []string
+	}{
+		{name: "empty store", after: time.Hour},
+		{name: "nothing expired", ttls: map[string]time.Duration{"a": time.Minute, "b": time.Hour}, after: 0, wantKept: []string{"a", "b"}},
+		{name: "some expired", ttls: map[string]time.Duration{"a": time.Minute, "b": time.Hour}, after: 2 * time.Minute, wantExpired: []string{"a"}, wantKept: []string{"b"}},
+		{name: "all expired", ttls: map[string]time.Duration{"a": time.Minute, "b": time.Hour}, after: 2 * time.Hour, wantExpired: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newPendingStore[string, string]()
+			for key, ttl := range tt.ttls {
+				store.Put(key, key, ttl)
+			}
+
+			expired := store.Sweep(time.Now().Add(tt.after))
+			slices.Sort(expired)
+			if !slices.Equal(expired, tt.wantExpired) {
+				t.Errorf("expired = %v, want %v", expired, tt.wantExpired)
+			}
+			for _, key := range tt.wantKept {
+				if _, ok := store.Peek(key); !ok {
+					t.Errorf("%s swept, want it kept", key)
+				}
+			}
+			for _, key := range tt.wantExpired {
+				if _, ok := store.entries[key]; ok {
+					t.Errorf("%s still stored after sweep", key)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardBatchName(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		offsets []time.Duration // When each forward arrives after start
+		want    string          // Name of the last forward
+	}{
+		{name: "first forward", offsets: []time.Duration{0}, want: "batch_20240601_01"},
+		{name: "within window", offsets: []time.Duration{0, 10 * time.Second, 20 * time.Second}, want: "batch_20240601_03"},
+		{name: "after window", offsets: []time.Duration{0, time.Minute}, want: "batch_20240601_01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.ForwardBatch = ForwardBatchConfig{Enabled: true, Window: 30}
+
+			var got string
+			for _, offset := range tt.offsets {
+				got = nextForwardBatchName(1, start.Add(offset))
+			}
+			if got != tt.want {
+				t.Errorf("name = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepPendingStateClearsForwardBatches(t *testing.T) {
+	setupTestBot(t)
+	config.ForwardBatch = ForwardBatchConfig{Enabled: true, Window: 30}
+	received := time.Now()
+	nextForwardBatchName(1, received)
+	nextForwardBatchName(2, received)
+
+	sweepPendingState(newFakeSender(t), time.Now().Add(10*time.Second))
+	if _, ok := forwardBatches.Peek(1); !ok {
+		t.Fatalf("batch swept within its window")
+	}
+
+	sweepPendingState(newFakeSender(t), time.Now().Add(time.Minute))
+	if n := len(forwardBatches.entries); n != 0 {
+		t.Errorf("%d batches left after their window", n)
+	}
+	if got := nextForwardBatchName(1, received.Add(time.Minute)); got != "batch_"+received.Add(time.Minute).Format("20060102")+"_01" {
+		t.Errorf("name after expiry = %q, want a new batch", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const sourceDefault = "default"
+
+// Settings whose values are never logged
+var secretSettingWords = []string{"token", "password", "secret", "headers"}
+
+var configSources = make(map[string]string) // Map of setting name, e.g. download.retries, to where its value came from
+
+// Record where setting got its value
+func setConfigSource(setting, source string) {
+	configSources[setting] = source
+}
+
+// Get where setting got its value, settings nobody set keep their default
+func configSource(setting string) string {
+	if source, ok := configSources[setting]; ok {
+		return source
+	}
+	return sourceDefault
+}
+
+// Record settings present in config file data, including nested ones and categories by name
+func recordConfigFileSources(path string, data []byte) error {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+
+	source := "config file " + path
+	var walk func(prefix string, items yaml.MapSlice)
+	walk = func(prefix string, items yaml.MapSlice) {
+		for _, item := range items {
+			name := prefix + fmt.Sprint(item.Key)
+			setConfigSource(name, source)
+			if nested, ok := item.Value.(yaml.MapSlice); ok {
+				walk(name+".", nested)
+			}
+		}
+	}
+	walk("", root)
+
+	for _, cat := range getCategories() {
+		setConfigSource("categories."+cat.Name, source)
+	}
+	return nil
+}
+
+// Describe effective settings with their sources, one line each, secrets redacted
+func describeConfigSources(configPath string) []string {
+	lines := []string{
+		fmt.Sprintf("TELEGRAM_BOT_TOKEN = [redacted] (%s)", configSource("TELEGRAM_BOT_TOKEN")),
+		fmt.Sprintf("config path = %s (%s)", configPath, configSource("config_path")),
+	}
+
+	// Categories are listed by name so each resolved path shows its own source
+	for _, cat := range getCategories() {
+		lines = append(lines, fmt.Sprintf("categories.%s = %s (%s)", cat.Name, cat.Path, configSource("categories."+cat.Name)))
+	}
+
+	var walk func(prefix string, value reflect.Value)
+	walk = func(prefix string, value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" || prefix == "" && tag == "categories" {
+				continue
+			}
+			name := prefix + tag
+			fieldValue := value.Field(i)
+			if fieldValue.Kind() == reflect.Struct {
+				walk(name+".", fieldValue)
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s = %s (%s)", name, formatSettingValue(name, fieldValue), configSource(name)))
+		}
+	}
+	walk("", reflect.ValueOf(config))
+	return lines
+}
+
+// Format value of setting for the log, hiding secrets and summarizing collections
+func formatSettingValue(name string, value reflect.Value) string {
+	for _, word := range secretSettingWords {
+		if strings.Contains(strings.ToLower(name), word) {
+			if value.IsZero() {
+				return "(not set)"
+			}
+			return "[redacted]"
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return "(none)"
+		}
+		if value.Kind() == reflect.Map {
+			keys := make([]string, 0, value.Len())
+			for _, key := range value.MapKeys() {
+				keys = append(keys, fmt.Sprint(key.Interface()))
+			}
+			sort.Strings(keys)
+			return fmt.Sprintf("%d entries: %s", len(keys), strings.Join(keys, ", "))
+		}
+		return fmt.Sprintf("%d items", value.Len())
+	case reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// Log every effective setting and where it came from
+func logConfigSources(configPath string) {
+	log.Printf("Effective configuration:")
+	for _, line := range describeConfigSources(configPath) {
+		log.Printf("  %s", line)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecordConfigFileSources(t *testing.T) {
+	data := "download:\n  retries: 3\nadmins: [1]\ncategories:\n  - name: docs\n    path: /srv/docs\n"
+	tests := []struct {
+		setting string
+		want    string
+	}{
+		{setting: "download", want: "config file /etc/bot.yml"},
+		{setting: "download.retries", want: "config file /etc/bot.yml"},
+		{setting: "admins", want: "config file /etc/bot.yml"},
+		{setting: "categories.docs", want: "config file /etc/bot.yml"},
+		{setting: "download.retry_delay", want: sourceDefault},
+		{setting: "duplicate_policy", want: sourceDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.setting, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			if err := recordConfigFileSources("/etc/bot.yml", []byte(data)); err != nil {
+				t.Fatal(err)
+			}
+			if got := configSource(tt.setting); got != tt.want {
+				t.Errorf("configSource(%q) = %q, want %q", tt.setting, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		inFile  bool // Variable is set by the env file
+		wantEnv bool // Source is the process environment
+	}{
+		{name: "from env file", key: "GO_TG_FILE_TEST_FROM_FILE", inFile: true},
+		{name: "from environment", key: "GO_TG_FILE_TEST_FROM_ENV", wantEnv: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t)
+			t.Setenv(tt.key, "outside")
+			t.Setenv("OTHER_UNUSED", "")
+			envFile := filepath.Join(dir, "test.env")
+			data := "OTHER_UNUSED=1\n"
+			if tt.inFile {
+				data = "export " + tt.key + "=\"inside\"\n"
+			}
+			if err := os.WriteFile(envFile, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := loadEnvFile(envFile); err != nil {
+				t.Fatal(err)
+			}
+
+			want := envFile
+			if tt.wantEnv {
+				want = "environment"
+			}
+			if got := envSource(tt.key); got != want {
+				t.Errorf("envSource(%q) = %q, want %q", tt.key, got, want)
+			}
+		})
+	}
+}
+
+func TestFormatSettingValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		value   any
+		want    string
+	}{
+		{name: "string", setting: "duplicate_policy", value: "keep-first", want: `"keep-first"`},
+		{name: "number", setting: "download.retries", value: 3, want: "3"},
+		{name: "bool", setting: "user_folders", value: true, want: "true"},
+		{name: "secret set", setting: "gallery.password", value: "hunter2", want: "[redacted]"},
+		{name: "secret not set", setting: "api.token", value: "", want: "(not set)"},
+		{name: "secret headers", setting: "notify.headers", value: map[string]string{"Authorization": "Bearer x"}, want: "[redacted]"},
+		{name: "empty list", setting: "admins", value: []int64{}, want: "(none)"},
+		{name: "list", setting: "admins", value: []int64{1, 2}, want: "2 items"},
+		{name: "map keys sorted", setting: "type_size_limits", value: map[string]int64{"video": 2, "audio": 1}, want: "2 entries: audio, video"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSettingValue(tt.setting, reflect.ValueOf(tt.value)); got != tt.want {
+				t.Errorf("formatSettingValue(%q) = %q, want %q", tt.setting, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeConfigSources(t *testing.T) {
+	setupTestBot(t, "docs")
+	config.Download.Retries = 3
+	config.Categories[0].Path = "/srv/docs"
+	setConfigSource("download.retries", "config file /etc/bot.yml")
+	setConfigSource("categories.docs", "CATEGORIES from environment")
+	setConfigSource("TELEGRAM_BOT_TOKEN", ".env")
+
+	lines := describeConfigSources("/etc/bot.yml")
+
+	for _, want := range []string{
+		"TELEGRAM_BOT_TOKEN = [redacted] (.env)",
+		"config path = /etc/bot.yml (default)",
+		"categories.docs = /srv/docs (CATEGORIES from environment)",
+		"download.retries = 3 (config file /etc/bot.yml)",
+		"download.retry_delay = 0 (default)",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("lines do not contain %q", want)
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "categories =") {
+			t.Errorf("categories listed as a whole: %q", line)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Background saving defaults
+const (
+	defaultSaveQueueSize = 100 // Saves waiting for a background worker
+	defaultSaveWorkers   = 1
+)
+
+// AsyncConfig represents settings for saving files in the background
+type AsyncConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	QueueSize int  `yaml:"queue_size"` // Saves that may wait before new files are refused
+	Workers   int  `yaml:"workers"`    // Files saved in parallel, download.max_concurrent limits their downloads
+}
+
+// saveJob represents a validated file waiting to be downloaded
+type saveJob struct {
+	Message  *tgbotapi.Message
+	FileID   string
+	Category string
+	Filename string
+	Tags     map[string]string
+	Copies   []string
+}
+
+var (
+	saveJobs    chan saveJob   // Queue of background saves, nil when saving synchronously
+	saveWorkers sync.WaitGroup // Workers taking saves from the queue
+)
+
+// Start background workers saving queued files, picking names is serialized by lockStorageNames
+func startSaveWorkers(bot Sender) {
+	if !config.Async.Enabled {
+		return
+	}
+
+	size := config.Async.QueueSize
+	if size <= 0 {
+		size = defaultSaveQueueSize
+	}
+	workers := config.Async.Workers
+	if workers <= 0 {
+		workers = defaultSaveWorkers
+	}
+	saveJobs = make(chan saveJob, size)
+
+	for i := 0; i < workers; i++ {
+		saveWorkers.Add(1)
+		go func(jobs <-chan saveJob) {
+			defer saveWorkers.Done()
+			for job := range jobs {
+				saveFile(bot, job.Message, job.FileID, job.Category, job.Filename, job.Tags, job.Copies)
+			}
+		}(saveJobs)
+	}
+	log.Printf("Saving files in the background, %d workers, queue size %d", workers, size)
+}
+
+// Stop taking saves and wait until queued ones are finished
+func stopSaveWorkers() {
+	if saveJobs == nil {
+		return
+	}
+	close(saveJobs)
+	saveWorkers.Wait()
+	saveJobs = nil
+}
+
+// Save file now, or queue it and acknowledge right away when background saving is enabled
+func submitSave(bot Sender, message *tgbotapi.Message, fileID, category, filename string, tags map[string]string, copies []string) {
+	if saveJobs == nil {
+		saveFile(bot, message, fileID, category, filename, tags, copies)
+		return
+	}
+
+	job := saveJob{Message: message, FileID: fileID, Category: category, Filename: filename, Tags: tags, Copies: copies}
+	select {
+	case saveJobs <- job:
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Queued '%s' for category '%s' (%d waiting). You will get a confirmation when it is saved.", filename, category, len(saveJobs)))
+		if ack, err := bot.Send(msg); err == nil {
+			scheduleDeletion(bot, message.Chat.ID, ack.MessageID, config.StatusRetention)
+		}
+	default:
+		log.Printf("Save queue is full, refusing %s from user %d", filename, message.From.ID)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Too many files are waiting to be saved. Please send this file again later.")
+		bot.Send(msg)
+	}
+}
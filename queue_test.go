@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Start background save workers, they finish queued saves when the test ends
+func startTestSaveWorkers(t *testing.T, bot Sender, workers int) {
+	t.Helper()
+	config.Async.Enabled = true
+	config.Async.Workers = workers
+	startSaveWorkers(bot)
+	t.Cleanup(stopSaveWorkers)
+}
+
+// Run with -race: imports change categories while the worker resolves them for queued saves
+func TestImportCategoriesDuringQueuedSave(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Admins = []int64{1}
+	fks := newFakeSender(t)
+	fks.addFile("import", []byte(fmt.Sprintf("categories:\n  - name: docs\n    path: %s\n  - name: music\n    path: %s\n",
+		filepath.Join(dir, "docs"), filepath.Join(dir, "music"))))
+	startTestSaveWorkers(t, fks, 1)
+
+	const files = 20
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		importMessage := commandMessage("/importcategories")
+		importMessage.ReplyToMessage = &tgbotapi.Message{Document: &tgbotapi.Document{FileID: "import", FileName: "categories.yml"}}
+		for i := 0; i < files; i++ {
+			handleImportCategoriesCommand(fks, importMessage)
+		}
+	}()
+
+	for i := 0; i < files; i++ {
+		fileID := fmt.Sprintf("file-%d", i)
+		fks.addFile(fileID, []byte(fileID))
+		message := documentMessage(fileID, fileID+".txt", "/docs", len(fileID))
+		message.MessageID = i + 1
+		handleFileMessage(fks, message)
+	}
+	wg.Wait()
+	stopSaveWorkers()
+
+	if saved := len(metadata.Records()); saved != files {
+		t.Errorf("saved %d of %d files", saved, files)
+	}
+	if !fks.sentContaining("Imported categories: 0 added, 2 updated") {
+		t.Errorf("replies do not confirm the import")
+	}
+}
+
+func TestSaveWorkersDownloadLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		wantPeak int32 // 0 when more downloads than one may run at once
+	}{
+		{name: "single download", limit: 1, wantPeak: 1},
+		{name: "two downloads", limit: 2, wantPeak: 2},
+		{name: "limit above workers", limit: 8, wantPeak: 4},
+		{name: "unlimited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.Download.MaxConcurrent = tt.limit
+			fks := newFakeSender(t)
+
+			var inFlight, peak atomic.Int32
+			fks.onFetch = func(string) {
+				n := inFlight.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				inFlight.Add(-1)
+			}
+			startTestSaveWorkers(t, fks, 4)
+
+			const files = 8
+			for i := 0; i < files; i++ {
+				fileID := fmt.Sprintf("file-%d", i)
+				fks.addFile(fileID, []byte(fileID))
+				message := documentMessage(fileID, fileID+".txt", "/docs", len(fileID))
+				message.MessageID = i + 1
+				handleFileMessage(fks, message)
+			}
+			stopSaveWorkers()
+
+			if saved := len(metadata.Records()); saved != files {
+				t.Fatalf("saved %d of %d files", saved, files)
+			}
+			got := peak.Load()
+			if tt.wantPeak != 0 && got != tt.wantPeak {
+				t.Errorf("peak simultaneous downloads = %d, want %d", got, tt.wantPeak)
+			}
+			if tt.wantPeak == 0 && got < 2 {
+				t.Errorf("peak simultaneous downloads = %d, want downloads to overlap", got)
+			}
+		})
+	}
+}
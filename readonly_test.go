@@ -0,0 +1,118 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestReadOnlyCategory(t *testing.T) {
+	tests := []struct {
+		name      string
+		readOnly  string // Category made read-only
+		action    func(fks *fakeSender, dir string)
+		wantSaved string // File expected afterwards, relative to the test directory
+		wantGone  string // File expected to be missing afterwards
+		rejected  bool   // Reply explains the category is read-only
+	}{
+		{
+			name:     "save to writable category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				handleFileMessage(fks, documentMessage("file-1", "new.txt", "/docs", 4))
+			},
+			wantSaved: "docs/new.txt",
+		},
+		{
+			name:     "save to read-only category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				handleFileMessage(fks, documentMessage("file-1", "new.txt", "/archive", 4))
+			},
+			wantGone: "archive/new.txt",
+			rejected: true,
+		},
+		{
+			name:     "select read-only category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				handleCommand(fks, commandMessage("/archive"))
+				handleFileMessage(fks, documentMessage("file-1", "new.txt", "", 4))
+			},
+			wantSaved: "document/new.txt",
+			wantGone:  "archive/new.txt",
+			rejected:  true,
+		},
+		{
+			name:     "delete from read-only category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				addSavedFile(t, filepath.Join(dir, "archive", "old.txt"), "archive", 1, 10)
+				handleDeleteCommand(fks, deleteReply(10))
+			},
+			wantSaved: "archive/old.txt",
+			rejected:  true,
+		},
+		{
+			name:     "move out of read-only category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				addSavedFile(t, filepath.Join(dir, "archive", "old.txt"), "archive", 1, 10)
+				handleCommand(fks, moveReply("/docs", 10))
+			},
+			wantSaved: "archive/old.txt",
+			wantGone:  "docs/old.txt",
+			rejected:  true,
+		},
+		{
+			name:     "move into read-only category",
+			readOnly: "archive",
+			action: func(fks *fakeSender, dir string) {
+				addSavedFile(t, filepath.Join(dir, "docs", "old.txt"), "docs", 1, 10)
+				handleCommand(fks, moveReply("/archive", 10))
+			},
+			wantSaved: "docs/old.txt",
+			wantGone:  "archive/old.txt",
+			rejected:  true,
+		},
+		{
+			name: "move between writable categories",
+			action: func(fks *fakeSender, dir string) {
+				addSavedFile(t, filepath.Join(dir, "docs", "old.txt"), "docs", 1, 10)
+				handleCommand(fks, moveReply("/archive", 10))
+			},
+			wantSaved: "archive/old.txt",
+			wantGone:  "docs/old.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs", "archive", "document")
+			for i := range config.Categories {
+				config.Categories[i].ReadOnly = config.Categories[i].Name == tt.readOnly
+			}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			tt.action(fks, dir)
+
+			if tt.wantSaved != "" && !fileExists(filepath.Join(dir, tt.wantSaved)) {
+				t.Errorf("%s missing, replies %q", tt.wantSaved, fks.texts())
+			}
+			if tt.wantGone != "" && fileExists(filepath.Join(dir, tt.wantGone)) {
+				t.Errorf("%s exists, replies %q", tt.wantGone, fks.texts())
+			}
+			if got := fks.sentContaining("is read-only"); got != tt.rejected {
+				t.Errorf("read-only reply = %v, want %v, replies %q", got, tt.rejected, fks.texts())
+			}
+		})
+	}
+}
+
+// Build a category command replying to the bot's confirmation messageID
+func moveReply(command string, messageID int) *tgbotapi.Message {
+	message := commandMessage(command)
+	message.ReplyToMessage = &tgbotapi.Message{MessageID: messageID, Chat: message.Chat}
+	return message
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultRejectionHelpThreshold = 3
+
+var (
+	rejectionsMu          sync.Mutex
+	consecutiveRejections = make(map[int64]int) // Map of user ID to rejections since their last successful save
+)
+
+// Count rejection of sender's message, explaining the limits in full once the threshold is reached
+func noteRejection(bot Sender, message *tgbotapi.Message) {
+	threshold := config.RejectionHelpThreshold
+	if threshold < 0 || message.From == nil {
+		return
+	}
+	if threshold == 0 {
+		threshold = defaultRejectionHelpThreshold
+	}
+
+	rejectionsMu.Lock()
+	consecutiveRejections[message.From.ID]++
+	count := consecutiveRejections[message.From.ID]
+	if count >= threshold {
+		// Start over so the explanation is not repeated on every rejection
+		delete(consecutiveRejections, message.From.ID)
+	}
+	rejectionsMu.Unlock()
+
+	if count >= threshold {
+		msg := tgbotapi.NewMessage(message.Chat.ID, rejectionHelpText(count))
+		bot.Send(msg)
+	}
+}
+
+// Forget rejections of user after a successful save
+func resetRejections(userID int64) {
+	rejectionsMu.Lock()
+	defer rejectionsMu.Unlock()
+	delete(consecutiveRejections, userID)
+}
+
+// Build explanation of what the bot accepts and the limits that apply
+func rejectionHelpText(count int) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Your last %d messages could not be saved. Here is what the bot accepts:\n\n", count)
+	text.WriteString("- Send photos, videos, audio, voice messages or documents. Text, stickers and other messages are not saved.\n")
+	text.WriteString("- Choose a category with a caption like /image vacation.jpg, see /categories for the list.\n")
+
+	maxSize := int64(maxBotDownloadSize)
+	if config.MaxFileSizeBytes > 0 {
+		maxSize = min(maxSize, config.MaxFileSizeBytes)
+	}
+	fmt.Fprintf(&text, "- Files can be at most %s.\n", formatBytes(maxSize))
+
+	if len(config.TypeSizeLimits) > 0 {
+		types := make([]string, 0, len(config.TypeSizeLimits))
+		for fileType, limit := range config.TypeSizeLimits {
+			types = append(types, fmt.Sprintf("%s %s", fileType, formatBytes(limit)))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(&text, "- Limits by type: %s.\n", strings.Join(types, ", "))
+	}
+
+	if len(config.CommandCooldowns) > 0 {
+		commands := make([]string, 0, len(config.CommandCooldowns))
+		for command, seconds := range config.CommandCooldowns {
+			commands = append(commands, fmt.Sprintf("/%s every %d seconds", command, seconds))
+		}
+		sort.Strings(commands)
+		fmt.Fprintf(&text, "- Some commands can only be used so often: %s.\n", strings.Join(commands, ", "))
+	}
+
+	text.WriteString("\nSee /help for all commands.")
+	return text.String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRejectionHelpThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		events    string // r for a rejected file, s for a saved file
+		wantHelp  int    // Explanations sent
+	}{
+		{name: "below default threshold", events: "rr"},
+		{name: "at default threshold", events: "rrr", wantHelp: 1},
+		{name: "counting starts over after help", events: "rrrrr", wantHelp: 1},
+		{name: "help again after threshold more", events: "rrrrrr", wantHelp: 2},
+		{name: "save resets the count", events: "rrsrr"},
+		{name: "configured threshold", threshold: 1, events: "rr", wantHelp: 2},
+		{name: "disabled", threshold: -1, events: "rrrrrr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.RejectionHelpThreshold = tt.threshold
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			for i, event := range tt.events {
+				caption := "/music"
+				if event == 's' {
+					caption = "/docs"
+				}
+				message := documentMessage("file-1", "new.txt", caption, 4)
+				message.MessageID = i + 1
+				handleFileMessage(fks, message)
+			}
+
+			var help int
+			for _, text := range fks.texts() {
+				if strings.HasPrefix(text, "Your last ") {
+					help++
+				}
+			}
+			if help != tt.wantHelp {
+				t.Errorf("explanations = %d, want %d, replies %q", help, tt.wantHelp, fks.texts())
+			}
+		})
+	}
+}
+
+func TestRejectionHelpText(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func()
+		want   string
+		absent string
+	}{
+		{name: "count", want: "Your last 3 messages could not be saved."},
+		{name: "upload limit", want: "- Files can be at most 20.0 MB."},
+		{name: "configured size limit", setup: func() { config.MaxFileSizeBytes = 1024 }, want: "- Files can be at most 1.0 KB."},
+		{name: "no type limits", absent: "Limits by type"},
+		{name: "type limits", setup: func() { config.TypeSizeLimits = map[string]int64{"video": 2048, "audio": 1024} }, want: "- Limits by type: audio 1.0 KB, video 2.0 KB."},
+		{name: "command cooldowns", setup: func() { config.CommandCooldowns = map[string]int{"stats": 60} }, want: "/stats every 60 seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			if tt.setup != nil {
+				tt.setup()
+			}
+
+			text := rejectionHelpText(3)
+			if tt.want != "" && !strings.Contains(text, tt.want) {
+				t.Errorf("text %q does not contain %q", text, tt.want)
+			}
+			if tt.absent != "" && strings.Contains(text, tt.absent) {
+				t.Errorf("text %q contains %q", text, tt.absent)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchCategoryRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []RuleConfig
+		filename string
+		want     string
+	}{
+		{name: "no rules", filename: "invoice-2024.pdf", want: ""},
+		{name: "matching rule", rules: []RuleConfig{{Pattern: `^invoice.*\.pdf$`, Category: "invoices"}}, filename: "invoice-2024.pdf", want: "invoices"},
+		{name: "no match", rules: []RuleConfig{{Pattern: `^invoice.*\.pdf$`, Category: "invoices"}}, filename: "receipt.pdf", want: ""},
+		{
+			name:     "first matching rule wins",
+			rules:    []RuleConfig{{Pattern: `\.pdf$`, Category: "books"}, {Pattern: `^invoice`, Category: "invoices"}},
+			filename: "invoice-2024.pdf",
+			want:     "books",
+		},
+		{
+			name:     "later rule when earlier does not match",
+			rules:    []RuleConfig{{Pattern: `\.epub$`, Category: "books"}, {Pattern: `^invoice`, Category: "invoices"}},
+			filename: "invoice-2024.pdf",
+			want:     "invoices",
+		},
+		{
+			name:     "invalid pattern skipped",
+			rules:    []RuleConfig{{Pattern: `(invoice`, Category: "books"}, {Pattern: `^invoice`, Category: "invoices"}},
+			filename: "invoice-2024.pdf",
+			want:     "invoices",
+		},
+		{
+			name:     "unknown category skipped",
+			rules:    []RuleConfig{{Pattern: `^invoice`, Category: "taxes"}, {Pattern: `\.pdf$`, Category: "books"}},
+			filename: "invoice-2024.pdf",
+			want:     "books",
+		},
+		{name: "case sensitive by default", rules: []RuleConfig{{Pattern: `^invoice`, Category: "invoices"}}, filename: "INVOICE.pdf", want: ""},
+		{name: "case insensitive flag", rules: []RuleConfig{{Pattern: `(?i)^invoice`, Category: "invoices"}}, filename: "INVOICE.pdf", want: "invoices"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "books", "invoices")
+			config.Rules = tt.rules
+			compileCategoryRules()
+
+			if got := matchCategoryRule(tt.filename); got != tt.want {
+				t.Errorf("matchCategoryRule(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageRulePrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		caption   string
+		configure func()
+		want      string
+	}{
+		{name: "rule over file type", configure: func() {}, want: "invoices"},
+		{name: "caption over rule", caption: "/books", configure: func() {}, want: "books"},
+		{name: "pending category over rule", configure: func() { pendingCategories.Put(1, "books", time.Minute) }, want: "books"},
+		{name: "user default over rule", configure: func() { userDefaults[1] = "books" }, want: "books"},
+		{name: "chat default over rule", configure: func() { chatDefaults[1] = "books" }, want: "books"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "books", "invoices", "document")
+			config.Rules = []RuleConfig{{Pattern: `^invoice.*\.pdf$`, Category: "invoices"}}
+			compileCategoryRules()
+			tt.configure()
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "invoice-2024.pdf", tt.caption, 4))
+
+			if !fileExists(filepath.Join(dir, tt.want, "invoice-2024.pdf")) {
+				t.Errorf("file not saved to %s, replies %q", tt.want, fks.texts())
+			}
+		})
+	}
+}
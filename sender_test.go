@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeSender records what the bot sends and serves file downloads from a local HTTP server
+type fakeSender struct {
+	mu       sync.Mutex
+	sent     []tgbotapi.Chattable
+	requests []tgbotapi.Chattable
+	files    map[string][]byte   // Map of file ID to content served for it
+	onFetch  func(fileID string) // Called before a file is served, set before the first download
+	urls     map[string]string   // Map of file ID to a download link on another server
+	urlErrs  map[string]error    // Map of file ID to error returned instead of its download link
+	urlCalls int                 // Download links requested
+	editErrs []error             // Errors returned by successive message edits, nil entries succeed
+	sendErrs []error             // Errors returned by successive new messages, nil entries succeed
+	nextID   int
+	member   tgbotapi.ChatMember
+	server   *httptest.Server
+}
+
+// Start a fake sender, its file server is closed when the test ends
+func newFakeSender(t *testing.T) *fakeSender {
+	t.Helper()
+
+	fks := &fakeSender{files: make(map[string][]byte), urls: make(map[string]string), urlErrs: make(map[string]error), nextID: 100}
+	fks.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileID := strings.TrimPrefix(r.URL.Path, "/file/")
+		fks.mu.Lock()
+		content, ok := fks.files[fileID]
+		fks.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if fks.onFetch != nil {
+			fks.onFetch(fileID)
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(fks.server.Close)
+	return fks
+}
+
+// Serve content for a file ID
+func (f *fakeSender) addFile(fileID string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileID] = content
+}
+
+func (f *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs *[]error
+	switch c.(type) {
+	case tgbotapi.EditMessageTextConfig:
+		errs = &f.editErrs
+	case tgbotapi.MessageConfig:
+		errs = &f.sendErrs
+	}
+	if errs != nil && len(*errs) > 0 {
+		err := (*errs)[0]
+		*errs = (*errs)[1:]
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+
+	f.sent = append(f.sent, c)
+	f.nextID++
+	return tgbotapi.Message{MessageID: f.nextID, Chat: &tgbotapi.Chat{ID: chatIDOf(c)}}, nil
+}
+
+func (f *fakeSender) GetFileDirectURL(fileID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.urlCalls++
+	if err, ok := f.urlErrs[fileID]; ok {
+		return "", err
+	}
+	if url, ok := f.urls[fileID]; ok {
+		return url, nil
+	}
+	if _, ok := f.files[fileID]; !ok {
+		return "", &tgbotapi.Error{Code: 400, Message: "Bad Request: invalid file_id"}
+	}
+	return f.server.URL + "/file/" + fileID, nil
+}
+
+func (f *fakeSender) GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.member, nil
+}
+
+func (f *fakeSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, c)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// Texts of sent and edited messages, oldest first
+func (f *fakeSender) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var texts []string
+	for _, c := range f.sent {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			texts = append(texts, m.Text)
+		case tgbotapi.EditMessageTextConfig:
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+// Text of the most recent sent or edited message
+func (f *fakeSender) lastText() string {
+	texts := f.texts()
+	if len(texts) == 0 {
+		return ""
+	}
+	return texts[len(texts)-1]
+}
+
+// Check if any sent or edited message contains the text
+func (f *fakeSender) sentContaining(text string) bool {
+	for _, t := range f.texts() {
+		if strings.Contains(t, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chat a message is sent to, 0 when it has none
+func chatIDOf(c tgbotapi.Chattable) int64 {
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		return m.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return m.ChatID
+	case tgbotapi.DocumentConfig:
+		return m.ChatID
+	}
+	return 0
+}
+
+// Reset package state to a fresh bot with categories stored under a temporary directory
+func setupTestBot(t *testing.T, categories ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	config = Config{}
+	categoryMap = make(map[string]string)
+	userDefaults = make(map[int64]string)
+	chatDefaults = make(map[int64]string)
+	pendingCategories = newPendingStore[int64, string]()
+	pendingSaves = newPendingStore[string, pendingSave]()
+	forwardBatches = newPendingStore[int64, forwardBatch]()
+	lastCommandUse = make(map[commandUse]time.Time)
+	categoryRules = nil
+	bannedUsers = make(map[int64]bool)
+	banlistPath = filepath.Join(dir, "banlist.json")
+	settingsPath = filepath.Join(dir, "settings.json")
+	saveJobs = nil
+	fileURLCache = make(map[string]cachedFileURL)
+	consecutiveRejections = make(map[int64]int)
+	splitUploads = newPendingStore[string, *splitUpload]()
+	blockedHashes = make(map[string]bool)
+	userEcho = make(map[int64]bool)
+	botUsername = ""
+	globalDownloadLimiter = nil
+	categoryDownloadLimiters = make(map[string]*rateLimiter)
+	downloadSemaphore = nil
+	configSources = make(map[string]string)
+	envFileSources = make(map[string]string)
+
+	var cats []CategoryConfig
+	for _, name := range categories {
+		cats = append(cats, CategoryConfig{Name: name, Path: filepath.Join(dir, name)})
+	}
+	setupCategories(cats, "test")
+
+	if err := loadMetadata(filepath.Join(dir, "metadata.json")); err != nil {
+		t.Fatalf("loadMetadata: %v", err)
+	}
+	return dir
+}
+
+// Build a private chat message from user 1 carrying a document
+func documentMessage(fileID, name, caption string, size int) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: 1, UserName: "alice"},
+		Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Caption:   caption,
+		Document:  &tgbotapi.Document{FileID: fileID, FileName: name, FileSize: size},
+	}
+}
+
+// Build a private chat message from user 1 with a command
+func commandMessage(text string) *tgbotapi.Message {
+	command := strings.SplitN(text, " ", 2)[0]
+	return &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: 1, UserName: "alice"},
+		Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
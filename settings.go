@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultSettingsPath = "./files/settings.json" // Path to persisted user and chat preferences
+
+var settingsPath = defaultSettingsPath
+
+// savedSettings represents preferences persisted across restarts
+type savedSettings struct {
+	UserDefaults map[int64]string `json:"user_defaults,omitempty"`
+	ChatDefaults map[int64]string `json:"chat_defaults,omitempty"`
+	EchoFiles    map[int64]bool   `json:"echo_files,omitempty"`
+}
+
+// Load user and chat default categories from file, missing file means no preferences
+func loadSettings(path string) error {
+	settingsPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var settings savedSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	for userID, category := range settings.UserDefaults {
+		userDefaults[userID] = category
+	}
+	for chatID, category := range settings.ChatDefaults {
+		chatDefaults[chatID] = category
+	}
+	for userID, enabled := range settings.EchoFiles {
+		userEcho[userID] = enabled
+	}
+	log.Printf("Loaded settings of %d users and %d chats", len(settings.UserDefaults), len(settings.ChatDefaults))
+	return nil
+}
+
+// Persist user and chat default categories to file
+func saveSettings() error {
+	settings := savedSettings{UserDefaults: userDefaults, ChatDefaults: chatDefaults, EchoFiles: userEchoChoices()}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(settingsPath, data)
+}
+
+// Show user's preferences with buttons to change them
+func handleSettingsCommand(bot Sender, message *tgbotapi.Message) {
+	userDefault := "none"
+	if category, ok := userDefaults[message.From.ID]; ok {
+		userDefault = category
+	}
+	chatDefault := "none"
+	if category, ok := chatDefaults[message.Chat.ID]; ok {
+		chatDefault = category
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Change default category", callbackCommand+"setdefault")),
+	}
+	if _, ok := userDefaults[message.From.ID]; ok {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Clear default category", callbackSettings+"cleardefault")))
+	}
+
+	echo := "off"
+	echoButton := "Send saved files back"
+	if echoEnabled(message.From.ID) {
+		echo = "on"
+		echoButton = "Stop sending saved files back"
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(echoButton, callbackSettings+"echo")))
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Your settings:\nDefault category: %s\nThis chat's default category: %s\nSend saved files up to %s back: %s",
+		userDefault, chatDefault, formatBytes(echoMaxSize()), echo))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	bot.Send(msg)
+}
+
+// Handle button of the /settings message
+func handleSettingsCallback(bot Sender, message *tgbotapi.Message, action string) {
+	switch action {
+	case "cleardefault":
+		handleUnsetDefaultCommand(bot, message)
+	case "echo":
+		text := "Saved files will not be sent back."
+		if toggleEcho(message.From.ID) {
+			text = fmt.Sprintf("Saved files up to %s will be sent back.", formatBytes(echoMaxSize()))
+		}
+		if err := saveSettings(); err != nil {
+			log.Printf("Error saving settings: %v", err)
+		}
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, text))
+	default:
+		log.Printf("Unknown settings action %q", action)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestResolveSizeLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		global     int64
+		types      map[string]int64
+		category   int64
+		fileType   string
+		wantLimit  int64
+		wantSource string
+	}{
+		{name: "no limits", fileType: "photo", wantLimit: 0, wantSource: "global"},
+		{name: "global limit", global: 100, fileType: "photo", wantLimit: 100, wantSource: "global"},
+		{name: "type over global", global: 100, types: map[string]int64{"photo": 50}, fileType: "photo", wantLimit: 50, wantSource: "photo type"},
+		{name: "other type uses global", global: 100, types: map[string]int64{"photo": 50}, fileType: "video", wantLimit: 100, wantSource: "global"},
+		{name: "type limit above global", global: 100, types: map[string]int64{"video": 500}, fileType: "video", wantLimit: 500, wantSource: "video type"},
+		{name: "category over type", global: 100, types: map[string]int64{"photo": 50}, category: 20, fileType: "photo", wantLimit: 20, wantSource: "'docs' category"},
+		{name: "category above global", global: 100, category: 1000, fileType: "document", wantLimit: 1000, wantSource: "'docs' category"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.MaxFileSizeBytes = tt.global
+			config.TypeSizeLimits = tt.types
+			config.Categories[0].MaxFileSizeBytes = tt.category
+
+			limit, source := resolveSizeLimit("docs", tt.fileType)
+			if limit != tt.wantLimit || source != tt.wantSource {
+				t.Errorf("resolveSizeLimit() = %d, %q, want %d, %q", limit, source, tt.wantLimit, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageTypeSizeLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   *tgbotapi.Message
+		wantSaved bool
+	}{
+		{name: "document under its limit", message: documentMessage("file-1", "a.txt", "/docs", 100), wantSaved: true},
+		{name: "document over its limit", message: documentMessage("file-1", "a.txt", "/docs", 300)},
+		{name: "video under its limit", message: videoMessage(300), wantSaved: true},
+		{name: "video over its limit", message: videoMessage(1500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.TypeSizeLimits = map[string]int64{"document": 200, "video": 1000}
+			fks := newFakeSender(t)
+			fks.addFile("file-1", make([]byte, getFileSize(tt.message)))
+
+			handleFileMessage(fks, tt.message)
+
+			if saved := len(metadata.Records()) == 1; saved != tt.wantSaved {
+				t.Errorf("saved = %v, want %v, replies %q", saved, tt.wantSaved, fks.texts())
+			}
+			if !tt.wantSaved && !fks.sentContaining("type limit") {
+				t.Errorf("replies %q do not name the type limit", fks.texts())
+			}
+		})
+	}
+}
+
+// Build a private chat message from user 1 carrying a video of size bytes saved to docs
+func videoMessage(size int) *tgbotapi.Message {
+	message := documentMessage("", "", "/docs", 0)
+	message.Document = nil
+	message.Video = &tgbotapi.Video{FileID: "file-1", FileName: "clip.mp4", FileSize: size}
+	return message
+}
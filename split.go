@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Split files defaults
+const (
+	defaultSplitFilesWindow  = 2 * time.Minute
+	defaultSplitFilesMaxWait = 24 * time.Hour
+)
+
+// Part-numbered filename such as archive.7z.001
+var splitPartPattern = regexp.MustCompile(`^(.+)\.(\d{3,})$`)
+
+// SplitFilesConfig represents settings for joining files sent in numbered parts
+type SplitFilesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Window  int  `yaml:"window"`   // Seconds without a new part before the parts are joined
+	MaxWait int  `yaml:"max_wait"` // Hours an upload with missing parts waits for them, default 24
+}
+
+// splitUpload represents parts of one file received so far
+type splitUpload struct {
+	ChatID   int64
+	ChatType string
+	UserID   int64
+	Category string
+	Dir      string         // Where the joined file is written, next to the first part
+	Name     string         // Filename without the part number
+	Parts    map[int]string // Map of part number to saved path
+	Total    int            // Number of parts from a parts=N caption tag, 0 when unknown
+	Timer    *time.Timer
+}
+
+var (
+	splitMu      sync.Mutex                                // Held while an upload in splitUploads is changed
+	splitUploads = newPendingStore[string, *splitUpload]() // Map of "<user ID>:<name>" to parts received so far
+)
+
+// Get configured time to wait for further parts
+func splitFilesWindow() time.Duration {
+	if config.SplitFiles.Window > 0 {
+		return time.Duration(config.SplitFiles.Window) * time.Second
+	}
+	return defaultSplitFilesWindow
+}
+
+// Get configured time an incomplete upload is kept
+func splitFilesMaxWait() time.Duration {
+	if config.SplitFiles.MaxWait > 0 {
+		return time.Duration(config.SplitFiles.MaxWait) * time.Hour
+	}
+	return defaultSplitFilesMaxWait
+}
+
+// Remember saved file when its name is part-numbered, joining the parts once all arrived or no more arrive
+func trackSplitPart(bot Sender, message *tgbotapi.Message, category, filename string, tags map[string]string, saved savedFile) {
+	if !config.SplitFiles.Enabled || config.StorageLayout == storageLayoutContent {
+		return
+	}
+	match := splitPartPattern.FindStringSubmatch(filename)
+	if match == nil {
+		return
+	}
+	number, err := strconv.Atoi(match[2])
+	if err != nil || number < 1 {
+		return
+	}
+	name := match[1]
+	key := fmt.Sprintf("%d:%s", message.From.ID, name)
+	window := splitFilesWindow()
+
+	splitMu.Lock()
+	upload, ok := splitUploads.Peek(key)
+	if !ok {
+		upload = &splitUpload{
+			ChatID:   message.Chat.ID,
+			ChatType: message.Chat.Type,
+			UserID:   message.From.ID,
+			Category: category,
+			Dir:      filepath.Dir(saved.Path),
+			Name:     name,
+			Parts:    make(map[int]string),
+		}
+	}
+	upload.Parts[number] = saved.Path
+	if total, err := strconv.Atoi(tags["parts"]); err == nil && total > 0 {
+		upload.Total = total
+	}
+	received := len(upload.Parts)
+	complete := upload.Total > 0 && len(missingSplitParts(upload)) == 0
+	if upload.Timer != nil {
+		upload.Timer.Stop()
+	}
+	upload.Timer = nil
+	if !complete {
+		upload.Timer = time.AfterFunc(window, func() { joinSplitUpload(bot, key) })
+	}
+	splitUploads.Put(key, upload, splitFilesMaxWait())
+	splitMu.Unlock()
+
+	if complete {
+		joinSplitUpload(bot, key)
+		return
+	}
+
+	text := fmt.Sprintf("Received part %d of '%s' (%d parts so far). Parts are joined when none arrive for %s.", number, name, received, window)
+	if upload.Total > 0 {
+		text = fmt.Sprintf("Received part %d of '%s' (%d of %d parts). Parts are joined once all arrived.", number, name, received, upload.Total)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	bot.Send(msg)
+}
+
+// Get numbers of parts not received yet, up to the announced total or the highest part received
+func missingSplitParts(upload *splitUpload) []string {
+	last := upload.Total
+	for number := range upload.Parts {
+		last = max(last, number)
+	}
+
+	var missing []string
+	for number := 1; number <= last; number++ {
+		if _, ok := upload.Parts[number]; !ok {
+			missing = append(missing, strconv.Itoa(number))
+		}
+	}
+	return missing
+}
+
+// Join received parts in order into the original file, keeping the parts when some are missing.
+// Parts are removed only when a parts=N tag confirmed the upload is complete.
+func joinSplitUpload(bot Sender, key string) {
+	// Missing parts are checked while holding splitMu so a part arriving meanwhile joins the same upload
+	splitMu.Lock()
+	upload, ok := splitUploads.Peek(key)
+	if !ok {
+		splitMu.Unlock()
+		return
+	}
+	if upload.Timer != nil {
+		upload.Timer.Stop()
+		upload.Timer = nil
+	}
+	missing := missingSplitParts(upload)
+	if len(missing) > 0 {
+		// Keep collecting so late parts still complete the file, until the upload expires
+		splitUploads.Put(key, upload, splitFilesMaxWait())
+	} else {
+		splitUploads.Delete(key)
+	}
+	splitMu.Unlock()
+
+	if len(missing) > 0 {
+		msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf("Could not join '%s', missing parts: %s. The received parts were kept, send the missing ones within %s to join them.",
+			upload.Name, strings.Join(missing, ", "), splitFilesMaxWait()))
+		bot.Send(msg)
+		return
+	}
+
+	numbers := make([]int, 0, len(upload.Parts))
+	for number := range upload.Parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	paths := make([]string, len(numbers))
+	for i, number := range numbers {
+		paths[i] = upload.Parts[number]
+	}
+	unlockNames := lockStorageNames()
+	saved, err := joinFiles(buildFilePath(upload.Dir, upload.Name, upload.Category), paths)
+	unlockNames()
+	if err != nil {
+		log.Printf("Error joining parts of %s: %v", upload.Name, err)
+		msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf("Error joining parts of '%s': %v. The parts were kept.", upload.Name, err))
+		bot.Send(msg)
+		return
+	}
+
+	// Without a total the highest part may not be the last, so the parts stay
+	if upload.Total == 0 {
+		recordSavedFile(FileRecord{
+			Category: upload.Category,
+			UserID:   upload.UserID,
+			ChatID:   upload.ChatID,
+			ChatType: upload.ChatType,
+		}, upload.Name, saved)
+		msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf("Joined %d parts into '%s' (%s). The parts were kept as the number of parts is unknown, add parts=N to the caption of a part to have them replaced.",
+			len(paths), filepath.Base(saved.Path), formatBytes(saved.Size)))
+		bot.Send(msg)
+		return
+	}
+
+	// Parts are replaced by the joined file
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error removing part %s: %v", path, err)
+		}
+		if err := metadata.RemovePath(path); err != nil {
+			log.Printf("Error removing metadata of %s: %v", path, err)
+		}
+	}
+	recordSavedFile(FileRecord{
+		Category: upload.Category,
+		UserID:   upload.UserID,
+		ChatID:   upload.ChatID,
+		ChatType: upload.ChatType,
+	}, upload.Name, saved)
+
+	msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf("Joined %d parts into '%s' (%s).", len(paths), filepath.Base(saved.Path), formatBytes(saved.Size)))
+	bot.Send(msg)
+}
+
+// Stop waiting for uploads expired at now, their parts stay saved as separate files
+func expireSplitUploads(bot Sender, now time.Time) {
+	splitMu.Lock()
+	expired := splitUploads.Sweep(now)
+	for _, upload := range expired {
+		if upload.Timer != nil {
+			upload.Timer.Stop()
+		}
+	}
+	splitMu.Unlock()
+
+	for _, upload := range expired {
+		log.Printf("Stopped waiting for parts of %s from user %d", upload.Name, upload.UserID)
+		msg := tgbotapi.NewMessage(upload.ChatID, fmt.Sprintf("Stopped waiting for the missing parts of '%s', the %d received parts stay saved as separate files.", upload.Name, len(upload.Parts)))
+		bot.Send(msg)
+	}
+}
+
+// Concatenate files in order into path
+func joinFiles(path string, parts []string) (savedFile, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".join-*")
+	if err != nil {
+		return savedFile{}, err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hasher := sha256.New()
+	var size int64
+	for _, part := range parts {
+		in, err := os.Open(part)
+		if err != nil {
+			return savedFile{}, err
+		}
+		written, err := io.Copy(io.MultiWriter(tmpFile, hasher), in)
+		in.Close()
+		if err != nil {
+			return savedFile{}, storageError(err)
+		}
+		size += written
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return savedFile{}, storageError(err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return savedFile{}, err
+	}
+	return savedFile{Path: path, Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
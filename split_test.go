@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Send numbered parts of archive.7z from user 1, caption is added to each part
+func sendSplitParts(t *testing.T, fks *fakeSender, caption string, parts ...int) {
+	t.Helper()
+	for _, number := range parts {
+		fileID := fmt.Sprintf("part-%d", number)
+		content := fmt.Sprintf("[%d]", number)
+		fks.addFile(fileID, []byte(content))
+		message := documentMessage(fileID, fmt.Sprintf("archive.7z.%03d", number), caption, len(content))
+		message.MessageID = number
+		handleFileMessage(fks, message)
+	}
+}
+
+func TestSplitUploadJoin(t *testing.T) {
+	tests := []struct {
+		name       string
+		caption    string
+		parts      []int
+		join       bool // Quiet window passes after the parts
+		wantJoined string
+		wantParts  bool // Part files still saved
+		wantWait   bool // Upload still waits for parts
+	}{
+		{name: "all parts with total", caption: "/docs parts=3", parts: []int{1, 2, 3}, wantJoined: "[1][2][3]"},
+		{name: "parts out of order with total", caption: "/docs parts=3", parts: []int{3, 1, 2}, wantJoined: "[1][2][3]"},
+		{name: "missing middle part with total", caption: "/docs parts=3", parts: []int{1, 3}, join: true, wantParts: true, wantWait: true},
+		{name: "total not reached yet", caption: "/docs parts=3", parts: []int{1, 2}, wantParts: true, wantWait: true},
+		{name: "no total keeps parts", caption: "/docs", parts: []int{1, 2}, join: true, wantJoined: "[1][2]", wantParts: true},
+		{name: "missing part without total", caption: "/docs", parts: []int{2}, join: true, wantParts: true, wantWait: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.SplitFiles = SplitFilesConfig{Enabled: true, Window: 3600}
+			fks := newFakeSender(t)
+
+			sendSplitParts(t, fks, tt.caption, tt.parts...)
+			if tt.join {
+				joinSplitUpload(fks, "1:archive.7z")
+			}
+
+			joined := filepath.Join(dir, "docs", "archive.7z")
+			if tt.wantJoined == "" {
+				if fileExists(joined) {
+					t.Errorf("joined file written")
+				}
+			} else if data, err := os.ReadFile(joined); err != nil || string(data) != tt.wantJoined {
+				t.Errorf("joined file = %q, %v, want %q", data, err, tt.wantJoined)
+			}
+			for _, number := range tt.parts {
+				part := filepath.Join(dir, "docs", fmt.Sprintf("archive.7z.%03d", number))
+				if fileExists(part) != tt.wantParts {
+					t.Errorf("part %d kept = %v, want %v", number, !tt.wantParts, tt.wantParts)
+				}
+			}
+			upload, waiting := splitUploads.Peek("1:archive.7z")
+			if waiting != tt.wantWait {
+				t.Errorf("waiting for parts = %v, want %v", waiting, tt.wantWait)
+			}
+			if waiting && upload.Timer != nil {
+				upload.Timer.Stop()
+			}
+		})
+	}
+}
+
+func TestSplitUploadLatePartCompletes(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.SplitFiles = SplitFilesConfig{Enabled: true, Window: 3600}
+	fks := newFakeSender(t)
+
+	sendSplitParts(t, fks, "/docs parts=3", 1, 3)
+	joinSplitUpload(fks, "1:archive.7z")
+	if !fks.sentContaining("missing parts: 2") {
+		t.Errorf("reply does not name the missing part: %q", fks.lastText())
+	}
+
+	sendSplitParts(t, fks, "/docs", 2)
+	if data, err := os.ReadFile(filepath.Join(dir, "docs", "archive.7z")); err != nil || string(data) != "[1][2][3]" {
+		t.Errorf("joined file = %q, %v", data, err)
+	}
+	if fileExists(filepath.Join(dir, "docs", "archive.7z.001")) {
+		t.Errorf("parts kept after a complete join")
+	}
+}
+
+func TestSplitUploadPartDuringJoin(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		dir := setupTestBot(t, "docs")
+		config.SplitFiles = SplitFilesConfig{Enabled: true, Window: 3600}
+		fks := newFakeSender(t)
+
+		sendSplitParts(t, fks, "/docs parts=3", 1, 3)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			joinSplitUpload(fks, "1:archive.7z")
+		}()
+		sendSplitParts(t, fks, "/docs", 2)
+		<-done
+
+		if data, err := os.ReadFile(filepath.Join(dir, "docs", "archive.7z")); err != nil || string(data) != "[1][2][3]" {
+			t.Fatalf("run %d: joined file = %q, %v", i, data, err)
+		}
+		if upload, ok := splitUploads.Peek("1:archive.7z"); ok {
+			t.Fatalf("run %d: upload still waiting with parts %v", i, upload.Parts)
+		}
+	}
+}
+
+func TestSplitUploadExpiry(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.SplitFiles = SplitFilesConfig{Enabled: true, Window: 3600, MaxWait: 1}
+	fks := newFakeSender(t)
+
+	sendSplitParts(t, fks, "/docs parts=3", 1, 2)
+
+	sweepPendingState(fks, time.Now().Add(30*time.Minute))
+	if _, ok := splitUploads.Peek("1:archive.7z"); !ok {
+		t.Fatalf("upload expired before max_wait")
+	}
+
+	sweepPendingState(fks, time.Now().Add(2*time.Hour))
+	if _, ok := splitUploads.Peek("1:archive.7z"); ok {
+		t.Errorf("upload still waiting after max_wait")
+	}
+	if !fks.sentContaining("Stopped waiting for the missing parts of 'archive.7z'") {
+		t.Errorf("user not told about expiry: %q", fks.lastText())
+	}
+	for _, name := range []string{"archive.7z.001", "archive.7z.002"} {
+		if !fileExists(filepath.Join(dir, "docs", name)) {
+			t.Errorf("%s removed on expiry", name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "permission denied", err: &os.PathError{Op: "open", Path: "/srv/docs/a", Err: syscall.EACCES}, want: errStorageUnavailable},
+		{name: "directory gone", err: &os.PathError{Op: "open", Path: "/srv/docs/a", Err: syscall.ENOENT}, want: errStorageUnavailable},
+		{name: "read-only filesystem", err: &os.PathError{Op: "open", Path: "/srv/docs/a", Err: syscall.EROFS}, want: errStorageUnavailable},
+		{name: "file in place of directory", err: &os.PathError{Op: "mkdir", Path: "/srv/docs", Err: syscall.ENOTDIR}, want: errStorageUnavailable},
+		{name: "disk full", err: fmt.Errorf("error writing file: %w", syscall.ENOSPC), want: errDiskFull},
+		{name: "other failure", err: syscall.EIO, want: errStorageFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storageError(tt.err); !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+				t.Errorf("storageError(%v) = %v, want %v wrapping the cause", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageStorageUnavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  bool
+		broken func(t *testing.T, path string)
+	}{
+		{name: "file in place of directory", broken: replaceWithFile},
+		{name: "file in place of directory with probe", probe: true, broken: replaceWithFile},
+		{name: "read-only directory", broken: readOnlyDir},
+		{name: "read-only directory with probe", probe: true, broken: readOnlyDir},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.ProbeStorage = tt.probe
+			config.StorageUnavailableMessage = "Storage for {category} is offline."
+			tt.broken(t, filepath.Join(dir, "docs"))
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			handleFileMessage(fks, documentMessage("file-1", "report.txt", "/docs", 4))
+
+			if !fks.sentContaining("Storage for docs is offline.") {
+				t.Errorf("replies %q do not report unavailable storage", fks.texts())
+			}
+			if records := metadata.Records(); len(records) != 0 {
+				t.Errorf("metadata records = %+v, want none", records)
+			}
+		})
+	}
+}
+
+// Put a regular file where the category directory should be, as after a lost mount point
+func replaceWithFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Remove write permission from the category directory, root ignores it
+func readOnlyDir(t *testing.T, path string) {
+	t.Helper()
+	if os.Geteuid() == 0 {
+		t.Skip("permissions do not apply to root")
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(path, 0755) })
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	_, free, _, err := diskSpace(dir)
+	if err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		dir         string
+		globalMin   int64
+		categoryMin int64
+		size        int64
+		want        error
+	}{
+		{name: "guard disabled", dir: dir, size: free * 2},
+		{name: "plenty of space", dir: dir, globalMin: free / 4, size: 1024},
+		{name: "file would cross minimum", dir: dir, globalMin: free / 4, size: free, want: errDiskFull},
+		{name: "minimum above free space", dir: dir, globalMin: free * 2, size: 1, want: errDiskFull},
+		{name: "category minimum overrides global", dir: dir, globalMin: free * 2, categoryMin: free / 4, size: 1024},
+		{name: "category minimum without global", dir: dir, categoryMin: free * 2, size: 1, want: errDiskFull},
+		{name: "free space unknown", dir: filepath.Join(dir, "missing"), globalMin: free * 2, size: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.MinFreeSpaceBytes = tt.globalMin
+			config.Categories[0].MinFreeSpaceBytes = tt.categoryMin
+
+			err := checkFreeSpace(tt.dir, "docs", tt.size)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("checkFreeSpace() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageFreeSpaceGuard(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	if _, _, _, err := diskSpace(dir); err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+	config.MinFreeSpaceBytes = 1 << 62
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	handleFileMessage(fks, documentMessage("file-1", "new.txt", "/docs", 4))
+
+	if fileExists(filepath.Join(dir, "docs", "new.txt")) {
+		t.Errorf("file saved below the free space minimum")
+	}
+	if !fks.sentContaining("storage is full") {
+		t.Errorf("reply = %q, want storage full error", fks.lastText())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRenderSuccessMessage(t *testing.T) {
+	saved := savedFile{Path: "/srv/docs/a<b>_1.txt", Size: 2048, SHA256: "abc123"}
+	tests := []struct {
+		name      string
+		template  string
+		parseMode string
+		want      string
+	}{
+		{name: "default template", want: "File saved successfully!\nCategory: docs\nLocation: /srv/docs/a<b>_1.txt"},
+		{name: "all placeholders", template: "{filename} {category} {path} {size} {hash}", want: "a<b>_1.txt docs /srv/docs/a<b>_1.txt 2.0 KB abc123"},
+		{name: "unknown placeholder kept", template: "Saved {filename} by {user}", want: "Saved a<b>_1.txt by {user}"},
+		{name: "HTML escapes values", template: "<b>{filename}</b>", parseMode: "HTML", want: "<b>a&lt;b&gt;_1.txt</b>"},
+		{name: "MarkdownV2 escapes values", template: "*{filename}*", parseMode: "MarkdownV2", want: `*a<b\>\_1\.txt*`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.SuccessTemplate = tt.template
+			config.SuccessParseMode = tt.parseMode
+
+			if got := renderSuccessMessage("docs", saved); got != tt.want {
+				t.Errorf("renderSuccessMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageSuccessTemplate(t *testing.T) {
+	setupTestBot(t, "docs")
+	config.SuccessTemplate = "Saved <b>{filename}</b> ({size}) to {category}"
+	config.SuccessParseMode = "HTML"
+	fks := newFakeSender(t)
+	fks.addFile("file-1", []byte("data"))
+
+	handleFileMessage(fks, documentMessage("file-1", "a&b.txt", "/docs", 4))
+
+	fks.mu.Lock()
+	defer fks.mu.Unlock()
+	edit, ok := fks.sent[len(fks.sent)-1].(tgbotapi.EditMessageTextConfig)
+	if !ok {
+		t.Fatalf("last message %T, want the status message edited", fks.sent[len(fks.sent)-1])
+	}
+	if want := "Saved <b>a&amp;b.txt</b> (4 B) to docs"; edit.Text != want {
+		t.Errorf("confirmation = %q, want %q", edit.Text, want)
+	}
+	if edit.ParseMode != "HTML" {
+		t.Errorf("parse mode = %q, want HTML", edit.ParseMode)
+	}
+}
+
+func TestSendSaveResultFallback(t *testing.T) {
+	transient := &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}
+	permanent := &tgbotapi.Error{Code: 400, Message: "Bad Request: message to edit not found"}
+	tests := []struct {
+		name      string
+		retries   int
+		sendErrs  []error // First entry is the status message
+		editErrs  []error
+		wantEdit  bool // Outcome shown by editing the status message
+		wantNew   bool // Outcome sent as a new message
+		wantReply int  // Message the saved file's record points to, 0 for any
+	}{
+		{name: "edit succeeds", wantEdit: true, wantReply: 101},
+		{name: "edit fails", editErrs: []error{permanent}, wantNew: true, wantReply: 102},
+		{name: "transient edit error without retries", editErrs: []error{transient}, wantNew: true, wantReply: 102},
+		{name: "transient edit error retried", retries: 1, editErrs: []error{transient}, wantEdit: true, wantReply: 101},
+		{name: "status message never sent", sendErrs: []error{permanent}, wantNew: true},
+		{name: "nothing delivered", editErrs: []error{permanent}, sendErrs: []error{nil, permanent}, wantReply: 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.Download.Retries = tt.retries
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+			fks.sendErrs = tt.sendErrs
+			fks.editErrs = tt.editErrs
+
+			handleFileMessage(fks, documentMessage("file-1", "new.txt", "/docs", 4))
+
+			var edited, sent bool
+			for _, c := range fks.sent {
+				switch c := c.(type) {
+				case tgbotapi.EditMessageTextConfig:
+					edited = edited || strings.HasPrefix(c.Text, "File saved successfully!")
+				case tgbotapi.MessageConfig:
+					sent = sent || strings.HasPrefix(c.Text, "File saved successfully!")
+				}
+			}
+			if edited != tt.wantEdit || sent != tt.wantNew {
+				t.Errorf("edited = %v, new message = %v, want %v, %v", edited, sent, tt.wantEdit, tt.wantNew)
+			}
+			records := metadata.Records()
+			if len(records) != 1 {
+				t.Fatalf("%d files recorded", len(records))
+			}
+			if tt.wantReply != 0 && records[0].MessageID != tt.wantReply {
+				t.Errorf("record points to message %d, want %d", records[0].MessageID, tt.wantReply)
+			}
+		})
+	}
+}
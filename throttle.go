@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter spreads reads so their total stays within a number of bytes per second
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time // When bytes read so far are paid for
+}
+
+var (
+	downloadLimitersMu       sync.Mutex
+	globalDownloadLimiter    *rateLimiter
+	categoryDownloadLimiters = make(map[string]*rateLimiter) // Map of category name to its limiter
+)
+
+// Wait until n more bytes fit within the rate
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// throttledReader delays reads to stay within the rates of its limiters
+type throttledReader struct {
+	reader   io.Reader
+	limiters []*rateLimiter
+	chunk    int // Largest read, keeps the transfer smooth
+}
+
+// Read at most one chunk, waiting until the limiters allow the bytes read
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.reader.Read(p)
+	for _, limiter := range r.limiters {
+		limiter.wait(n)
+	}
+	return n, err
+}
+
+// Get limiters applying to downloads of category, shared by all its downloads
+func downloadLimiters(category string) []*rateLimiter {
+	downloadLimitersMu.Lock()
+	defer downloadLimitersMu.Unlock()
+
+	var limiters []*rateLimiter
+	if config.MaxDownloadBytesPerSec > 0 {
+		if globalDownloadLimiter == nil {
+			globalDownloadLimiter = &rateLimiter{rate: config.MaxDownloadBytesPerSec}
+		}
+		limiters = append(limiters, globalDownloadLimiter)
+	}
+
+	if rate := getCategoryConfig(category).MaxDownloadBytesPerSec; rate > 0 {
+		limiter, ok := categoryDownloadLimiters[category]
+		if !ok {
+			limiter = &rateLimiter{rate: rate}
+			categoryDownloadLimiters[category] = limiter
+		}
+		limiters = append(limiters, limiter)
+	}
+	return limiters
+}
+
+// Wrap reader to stay within the global and category download rates, zero rates mean unlimited
+func throttleDownload(reader io.Reader, category string) io.Reader {
+	limiters := downloadLimiters(category)
+	if len(limiters) == 0 {
+		return reader
+	}
+
+	// Read about a tenth of a second of the slowest rate at once
+	chunk := int64(32 * 1024)
+	for _, limiter := range limiters {
+		chunk = min(chunk, max(limiter.rate/10, 1))
+	}
+	return &throttledReader{reader: reader, limiters: limiters, chunk: int(chunk)}
+}
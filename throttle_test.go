@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottleDownload(t *testing.T) {
+	const size = 20 * 1024
+	tests := []struct {
+		name         string
+		globalRate   int64
+		categoryRate int64
+		want         time.Duration // Expected transfer time of size bytes
+	}{
+		{name: "unlimited", want: 0},
+		{name: "global rate", globalRate: 100 * 1024, want: 200 * time.Millisecond},
+		{name: "category rate", categoryRate: 50 * 1024, want: 400 * time.Millisecond},
+		{name: "slower category wins", globalRate: 200 * 1024, categoryRate: 50 * 1024, want: 400 * time.Millisecond},
+		{name: "slower global wins", globalRate: 50 * 1024, categoryRate: 200 * 1024, want: 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			config.MaxDownloadBytesPerSec = tt.globalRate
+			config.Categories[0].MaxDownloadBytesPerSec = tt.categoryRate
+
+			start := time.Now()
+			n, err := io.Copy(io.Discard, throttleDownload(bytes.NewReader(make([]byte, size)), "docs"))
+			elapsed := time.Since(start)
+			if err != nil || n != size {
+				t.Fatalf("copied %d bytes, %v", n, err)
+			}
+			if elapsed < tt.want*8/10 || elapsed > tt.want+300*time.Millisecond {
+				t.Errorf("transfer took %s, want about %s", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleDownloadSharedByCategory(t *testing.T) {
+	setupTestBot(t, "docs", "music")
+	config.Categories[0].MaxDownloadBytesPerSec = 50 * 1024
+	const size = 10 * 1024
+
+	// Two downloads to one category share its rate, other categories are not slowed
+	start := time.Now()
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			io.Copy(io.Discard, throttleDownload(bytes.NewReader(make([]byte, size)), "docs"))
+			done <- struct{}{}
+		}()
+	}
+	io.Copy(io.Discard, throttleDownload(bytes.NewReader(make([]byte, size)), "music"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unthrottled category took %s", elapsed)
+	}
+	<-done
+	<-done
+
+	if elapsed, want := time.Since(start), 400*time.Millisecond; elapsed < want*8/10 {
+		t.Errorf("two downloads took %s, want about %s", elapsed, want)
+	}
+}
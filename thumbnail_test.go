@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestSaveVideoThumbnails(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		thumbnail bool  // Video carries a thumbnail
+		thumbErr  error // Error getting the thumbnail's download link
+		wantThumb bool
+	}{
+		{name: "thumbnail saved next to the video", enabled: true, thumbnail: true, wantThumb: true},
+		{name: "video without thumbnail", enabled: true},
+		{name: "setting off", thumbnail: true},
+		{name: "thumbnail download fails", enabled: true, thumbnail: true, thumbErr: errors.New("file is gone")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.Categories[0].SaveVideoThumbnails = tt.enabled
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("video"))
+			fks.addFile("thumb-1", []byte("jpeg"))
+			if tt.thumbErr != nil {
+				fks.urlErrs["thumb-1"] = tt.thumbErr
+			}
+
+			message := videoMessage(5)
+			if tt.thumbnail {
+				message.Video.Thumbnail = &tgbotapi.PhotoSize{FileID: "thumb-1", FileUniqueID: "t1", FileSize: 4}
+			}
+			handleFileMessage(fks, message)
+
+			if data, err := os.ReadFile(filepath.Join(dir, "docs", "clip.mp4")); err != nil || string(data) != "video" {
+				t.Fatalf("video = %q, %v, replies %q", data, err, fks.texts())
+			}
+			thumbPath := filepath.Join(dir, "docs", "clip_thumb.jpg")
+			if fileExists(thumbPath) != tt.wantThumb {
+				t.Errorf("thumbnail saved = %v, want %v", !tt.wantThumb, tt.wantThumb)
+			}
+			if tt.wantThumb {
+				if data, err := os.ReadFile(thumbPath); err != nil || string(data) != "jpeg" {
+					t.Errorf("thumbnail = %q, %v", data, err)
+				}
+				if record := metadata.FindByPath(thumbPath); record.Name != "clip_thumb.jpg" || record.Category != "docs" {
+					t.Errorf("thumbnail record = %+v", record)
+				}
+			}
+			if !fks.sentContaining("File saved successfully!") {
+				t.Errorf("video save not confirmed, replies %q", fks.texts())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestIsFileTooBigError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "too big", err: &tgbotapi.Error{Code: 400, Message: "Bad Request: file is too big"}, want: true},
+		{name: "wrapped", err: fmt.Errorf("error getting file: %w", &tgbotapi.Error{Code: 400, Message: "Bad Request: File is too big"}), want: true},
+		{name: "other API error", err: &tgbotapi.Error{Code: 400, Message: "Bad Request: invalid file_id"}, want: false},
+		{name: "plain error with the text", err: errors.New("file is too big"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFileTooBigError(tt.err); got != tt.want {
+				t.Errorf("isFileTooBigError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFileMessageTooBig(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		apiErr     error
+		wantStatus bool // Status message sent before the error
+	}{
+		{name: "reported size over the limit", size: maxBotDownloadSize + 1},
+		{name: "refused by Telegram", size: 0, apiErr: &tgbotapi.Error{Code: 400, Message: "Bad Request: file is too big"}, wantStatus: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+			if tt.apiErr != nil {
+				fks.urlErrs["file-1"] = tt.apiErr
+			}
+
+			handleFileMessage(fks, documentMessage("file-1", "movie.mkv", "/docs", tt.size))
+
+			if !fks.sentContaining(errFileTooBig.Error()) {
+				t.Errorf("replies %q do not explain the file is too big", fks.texts())
+			}
+			if got := fks.sentContaining("Saving file"); got != tt.wantStatus {
+				t.Errorf("status message sent = %v, want %v", got, tt.wantStatus)
+			}
+			if records := metadata.Records(); len(records) != 0 {
+				t.Errorf("records = %+v, want none", records)
+			}
+		})
+	}
+}
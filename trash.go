@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Trash defaults
+const (
+	trashDirName              = ".trash" // Hidden directory in the category root
+	defaultTrashRetentionDays = 30
+	trashPurgeInterval        = time.Hour
+)
+
+// TrashConfig represents settings for keeping deleted files restorable
+type TrashConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	RetentionDays int  `yaml:"retention_days"` // Days before trashed files are removed for good
+}
+
+// Get configured trash retention
+func trashRetention() time.Duration {
+	days := config.Trash.RetentionDays
+	if days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Move saved file into its category's trash and mark it trashed in metadata
+func moveToTrash(record FileRecord) error {
+	dir := filepath.Join(categoryRoot(record.Category), trashDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating trash directory: %w", err)
+	}
+
+	// Prefix keeps files of the same name trashed at different times apart
+	now := time.Now()
+	trashPath := filepath.Join(dir, fmt.Sprintf("%d-%s", now.UnixNano(), filepath.Base(record.Path)))
+
+	// Content storage may share the file with other uploads, they keep it and trash gets a copy
+	if metadata.Shared(record) {
+		if _, err := os.Stat(record.Path); err != nil {
+			return err
+		}
+		if err := os.Link(record.Path, trashPath); err != nil {
+			if err := copyFile(record.Path, trashPath); err != nil {
+				return err
+			}
+		}
+	} else if err := os.Rename(record.Path, trashPath); err != nil {
+		return err
+	}
+	return metadata.Trash(record, trashPath, now)
+}
+
+// Start background job removing trashed files older than the retention window
+func startTrashPurge() {
+	if !config.Trash.Enabled {
+		return
+	}
+	go func() {
+		for {
+			waitForMaintenanceWindow()
+			purgeTrash(time.Now())
+			time.Sleep(trashPurgeInterval)
+		}
+	}()
+}
+
+// Remove trashed files and their metadata once retention has passed
+func purgeTrash(now time.Time) {
+	retention := trashRetention()
+	var expired []FileRecord
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil && now.Sub(*record.TrashedAt) >= retention {
+			expired = append(expired, record)
+		}
+	}
+
+	ctx, cancel := startMaintenanceJob()
+	defer cancel()
+
+	err := runMaintenance(ctx, expired, func(record FileRecord) {
+		if err := metadata.RemoveRecord(record); err != nil {
+			log.Printf("Error removing metadata for %s: %v", record.Path, err)
+			return
+		}
+		// Files trashed before records got their own trash path may still be listed by others
+		if metadata.Referenced(record.Path) {
+			log.Printf("Purged trashed record of %s, file kept for other records", record.Path)
+			return
+		}
+		if err := os.Remove(record.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error purging trashed file %s: %v", record.Path, err)
+			return
+		}
+		log.Printf("Purged trashed file %s", record.Path)
+	})
+	if err != nil {
+		log.Printf("Trash purge stopped early, remaining files are purged on the next run: %v", err)
+	}
+}
+
+// Handle restore command, bringing back the latest trashed file of that name
+func handleRestoreCommand(bot Sender, message *tgbotapi.Message, args string) {
+	// Trashed files the user may restore, latest last
+	var trashed []FileRecord
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil && (record.UserID == message.From.ID || isAdmin(message.From.ID)) {
+			trashed = append(trashed, record)
+		}
+	}
+
+	name := strings.TrimSpace(args)
+	if name == "" {
+		if len(trashed) == 0 {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Your trash is empty.")
+			bot.Send(msg)
+			return
+		}
+		text := "Files in trash, restore one with /restore [filename]:\n"
+		for _, record := range trashed {
+			text += fmt.Sprintf("%s (%s, deleted %s)\n", filepath.Base(record.OriginalPath), record.Category, record.TrashedAt.Format("2006-01-02"))
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		bot.Send(msg)
+		return
+	}
+
+	var found *FileRecord
+	for i := range trashed {
+		if filepath.Base(trashed[i].OriginalPath) == name || trashed[i].Name == name {
+			found = &trashed[i]
+		}
+	}
+	if found == nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("No file named '%s' in your trash. Send /restore to list it.", name))
+		bot.Send(msg)
+		return
+	}
+	if !checkCategoryWritable(bot, message, found.Category) {
+		return
+	}
+
+	// Another file may have taken the name in the meantime
+	if err := os.MkdirAll(filepath.Dir(found.OriginalPath), 0755); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error restoring file: %s", err.Error()))
+		bot.Send(msg)
+		return
+	}
+	path, shared, err := moveFromTrash(*found)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error restoring file: %s", err.Error()))
+		bot.Send(msg)
+		return
+	}
+	if err := metadata.Restore(*found, path); err != nil {
+		log.Printf("Error saving metadata for %s: %v", path, err)
+	}
+	if shared && !metadata.Referenced(found.Path) {
+		if err := os.Remove(found.Path); err != nil {
+			log.Printf("Error removing trashed copy %s: %v", found.Path, err)
+		}
+	}
+	log.Printf("File %s restored to %s by user %d", found.Path, path, message.From.ID)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Restored '%s' to category '%s' (path: %s).", name, found.Category, path))
+	bot.Send(msg)
+}
+
+// Move trashed file back under a free name, true when its content file is still in place for other uploads
+func moveFromTrash(record FileRecord) (string, bool, error) {
+	// Saves running meanwhile must not pick the same name
+	unlockNames := lockStorageNames()
+	defer unlockNames()
+
+	// Content file still used by other uploads holds the same bytes, the trashed copy is dropped
+	if config.StorageLayout == storageLayoutContent && fileExists(record.OriginalPath) {
+		return record.OriginalPath, true, nil
+	}
+	path := ensureUniqueFilename(record.OriginalPath)
+	if err := os.Rename(record.Path, path); err != nil {
+		return "", false, err
+	}
+	return path, false, nil
+}
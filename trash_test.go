@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Find the current record of an upload by its confirmation message
+func recordByMessage(t *testing.T, messageID int) FileRecord {
+	t.Helper()
+	records := metadata.FindByMessage(1, messageID)
+	if len(records) != 1 {
+		t.Fatalf("records of message %d = %+v", messageID, records)
+	}
+	return records[0]
+}
+
+func TestMoveToTrashAndPurge(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Trash.Enabled = true
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+
+	if err := moveToTrash(record); err != nil {
+		t.Fatalf("moveToTrash: %v", err)
+	}
+	trashed := recordByMessage(t, 10)
+	if fileExists(record.Path) || !fileExists(trashed.Path) || trashed.TrashedAt == nil {
+		t.Fatalf("after trash: original exists %v, trashed record %+v", fileExists(record.Path), trashed)
+	}
+
+	purgeTrash(time.Now().Add(trashRetention()))
+
+	if fileExists(trashed.Path) || len(metadata.Records()) != 0 {
+		t.Errorf("after purge: trashed file exists %v, records %+v", fileExists(trashed.Path), metadata.Records())
+	}
+}
+
+func TestMoveToTrashSharedBlob(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Trash.Enabled = true
+	config.StorageLayout = storageLayoutContent
+
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	mine := addSavedFile(t, blob, "docs", 1, 10)
+	addSavedFile(t, blob, "docs", 2, 11)
+
+	if err := moveToTrash(mine); err != nil {
+		t.Fatalf("moveToTrash: %v", err)
+	}
+	if !fileExists(blob) {
+		t.Fatal("blob used by another upload was moved to trash")
+	}
+	if theirs := recordByMessage(t, 11); theirs.TrashedAt != nil || theirs.Path != blob {
+		t.Errorf("other user's record changed: %+v", theirs)
+	}
+	trashed := recordByMessage(t, 10)
+	if trashed.TrashedAt == nil || !fileExists(trashed.Path) {
+		t.Fatalf("trashed record %+v has no file in trash", trashed)
+	}
+
+	purgeTrash(time.Now().Add(trashRetention()))
+
+	if !fileExists(blob) {
+		t.Error("purge removed the shared blob")
+	}
+	if fileExists(trashed.Path) {
+		t.Error("purge kept the trashed copy")
+	}
+	if records := metadata.Records(); len(records) != 1 || records[0].UserID != 2 {
+		t.Errorf("records after purge = %+v", records)
+	}
+}
+
+func TestHandleRestoreCommandSharedBlob(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Trash.Enabled = true
+	config.StorageLayout = storageLayoutContent
+	fks := newFakeSender(t)
+
+	blob := filepath.Join(dir, "docs", "ab", "abc123")
+	mine := addSavedFile(t, blob, "docs", 1, 10)
+	addSavedFile(t, blob, "docs", 2, 11)
+	if err := moveToTrash(mine); err != nil {
+		t.Fatalf("moveToTrash: %v", err)
+	}
+	trashed := recordByMessage(t, 10)
+
+	handleRestoreCommand(fks, commandMessage("/restore abc123"), "abc123")
+
+	restored := recordByMessage(t, 10)
+	if restored.TrashedAt != nil || restored.Path != blob {
+		t.Errorf("restored record = %+v, want active at %s; replies %q", restored, blob, fks.texts())
+	}
+	if fileExists(trashed.Path) {
+		t.Error("trashed copy left behind")
+	}
+}
+
+func TestMoveFromTrash(t *testing.T) {
+	tests := []struct {
+		name       string
+		layout     string
+		taken      bool // Another file has the original name
+		wantName   string
+		wantShared bool
+	}{
+		{name: "name free", wantName: "report.txt"},
+		{name: "name taken", taken: true, wantName: "report_1.txt"},
+		{name: "content still stored", layout: storageLayoutContent, taken: true, wantName: "report.txt", wantShared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			config.Trash.Enabled = true
+			record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+			if err := moveToTrash(record); err != nil {
+				t.Fatal(err)
+			}
+			if tt.taken {
+				os.WriteFile(record.Path, []byte("newer"), 0644)
+			}
+			config.StorageLayout = tt.layout
+
+			path, shared, err := moveFromTrash(recordByMessage(t, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Base(path) != tt.wantName || shared != tt.wantShared {
+				t.Errorf("moveFromTrash() = %s, %v, want %s, %v", path, shared, tt.wantName, tt.wantShared)
+			}
+			if tt.taken && !tt.wantShared {
+				if data, _ := os.ReadFile(record.Path); string(data) != "newer" {
+					t.Errorf("file holding the name was overwritten: %q", data)
+				}
+			}
+		})
+	}
+}
+
+// Run with -race: saves pick names while a restore puts the trashed file back
+func TestRestoreDuringSaves(t *testing.T) {
+	dir := setupTestBot(t, "docs")
+	config.Trash.Enabled = true
+	record := addSavedFile(t, filepath.Join(dir, "docs", "report.txt"), "docs", 1, 10)
+	if err := moveToTrash(record); err != nil {
+		t.Fatal(err)
+	}
+	fks := newFakeSender(t)
+
+	const saves = 10
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		fileID := fmt.Sprintf("file-%d", i)
+		fks.addFile(fileID, []byte(fileID))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := documentMessage(fileID, "report.txt", "/docs", len(fileID))
+			message.MessageID = 100 + i
+			handleFileMessage(fks, message)
+		}()
+	}
+	handleRestoreCommand(fks, commandMessage("/restore report.txt"), "report.txt")
+	wg.Wait()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "docs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files int
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			files++
+		}
+	}
+	if files != saves+1 {
+		t.Errorf("%d files in category, want %d", files, saves+1)
+	}
+	for _, record := range metadata.Records() {
+		data, err := os.ReadFile(record.Path)
+		if err != nil {
+			t.Errorf("%s: %v", record.Path, err)
+			continue
+		}
+		if record.MessageID == 10 && string(data) != "same content" {
+			t.Errorf("restored file %s holds %q", record.Path, data)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Trends defaults
+const (
+	defaultTrendsPath        = "./files/trends.json"
+	defaultTrendsHistoryDays = 90
+	trendsSnapshotInterval   = time.Hour
+	trendsReportDays         = 7
+	trendsDateLayout         = "2006-01-02"
+)
+
+// TrendsConfig represents settings for usage snapshots
+type TrendsConfig struct {
+	Path        string `yaml:"path"`
+	HistoryDays int    `yaml:"history_days"` // Number of daily snapshots to keep
+}
+
+// usageTotals represents number and size of stored files
+type usageTotals struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// usageSnapshot represents storage usage totals at the end of a day
+type usageSnapshot struct {
+	Date       string                 `json:"date"`
+	Categories map[string]usageTotals `json:"categories"`
+	Users      map[int64]usageTotals  `json:"users"`
+}
+
+var (
+	trendsMu        sync.Mutex
+	usageSnapshots  []usageSnapshot // Daily snapshots, oldest first
+	trendsStorePath = defaultTrendsPath
+)
+
+// Load snapshots and keep updating today's snapshot in background
+func startTrendsSnapshots() {
+	if config.Trends.Path != "" {
+		trendsStorePath = config.Trends.Path
+	}
+
+	data, err := os.ReadFile(trendsStorePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading trends from %s: %v", trendsStorePath, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &usageSnapshots); err != nil {
+			log.Printf("Error parsing trends from %s: %v", trendsStorePath, err)
+		}
+	}
+
+	go func() {
+		for {
+			takeUsageSnapshot(time.Now())
+			time.Sleep(trendsSnapshotInterval)
+		}
+	}()
+}
+
+// Aggregate metadata into snapshot for the given day and persist history
+func takeUsageSnapshot(now time.Time) {
+	snapshot := usageSnapshot{
+		Date:       now.Format(trendsDateLayout),
+		Categories: make(map[string]usageTotals),
+		Users:      make(map[int64]usageTotals),
+	}
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil {
+			continue
+		}
+		addUsage(snapshot.Categories, record.Category, record.Size)
+		addUsage(snapshot.Users, record.UserID, record.Size)
+	}
+
+	trendsMu.Lock()
+	defer trendsMu.Unlock()
+
+	// Replace today's snapshot or append a new day
+	if n := len(usageSnapshots); n > 0 && usageSnapshots[n-1].Date == snapshot.Date {
+		usageSnapshots[n-1] = snapshot
+	} else {
+		usageSnapshots = append(usageSnapshots, snapshot)
+	}
+
+	historyDays := config.Trends.HistoryDays
+	if historyDays <= 0 {
+		historyDays = defaultTrendsHistoryDays
+	}
+	if len(usageSnapshots) > historyDays {
+		usageSnapshots = usageSnapshots[len(usageSnapshots)-historyDays:]
+	}
+
+	data, err := json.Marshal(usageSnapshots)
+	if err == nil {
+		err = writeFileAtomic(trendsStorePath, data)
+	}
+	if err != nil {
+		log.Printf("Error saving trends to %s: %v", trendsStorePath, err)
+	}
+}
+
+// Add file to totals under key
+func addUsage[K comparable](totals map[K]usageTotals, key K, size int64) {
+	entry := totals[key]
+	entry.Count++
+	entry.Bytes += size
+	totals[key] = entry
+}
+
+// Get copy of recorded snapshots
+func getUsageSnapshots() []usageSnapshot {
+	trendsMu.Lock()
+	defer trendsMu.Unlock()
+
+	snapshots := make([]usageSnapshot, len(usageSnapshots))
+	copy(snapshots, usageSnapshots)
+	return snapshots
+}
+
+// Send usage growth per category over the last days
+func sendTrendsMessage(bot Sender, message *tgbotapi.Message) {
+	snapshots := getUsageSnapshots()
+	if len(snapshots) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "No usage history recorded yet.")
+		bot.Send(msg)
+		return
+	}
+
+	if len(snapshots) > trendsReportDays {
+		snapshots = snapshots[len(snapshots)-trendsReportDays:]
+	}
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+
+	categories := make([]string, 0, len(last.Categories))
+	for name := range last.Categories {
+		categories = append(categories, name)
+	}
+	sort.Strings(categories)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Usage trends %s to %s:\n", first.Date, last.Date)
+	for _, name := range categories {
+		current, previous := last.Categories[name], first.Categories[name]
+		fmt.Fprintf(&text, "%s: %d files (%s), %+d files (%s)\n",
+			name, current.Count, formatBytes(current.Bytes),
+			current.Count-previous.Count, formatBytesChange(current.Bytes-previous.Bytes))
+	}
+
+	text.WriteString(uploadSourceReport())
+	text.WriteString(diskSpaceReport())
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text.String())
+	bot.Send(msg)
+}
+
+// Describe how many stored files were sent in each type of chat
+func uploadSourceReport() string {
+	sources := make(map[string]usageTotals)
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil {
+			continue
+		}
+		source := record.ChatType
+		if source == "" {
+			source = "unknown" // Saved before chat types were recorded
+		}
+		addUsage(sources, source, record.Size)
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	text.WriteString("\nFiles by source:\n")
+	for _, name := range names {
+		fmt.Fprintf(&text, "%s: %d files (%s)\n", name, sources[name].Count, formatBytes(sources[name].Bytes))
+	}
+	return text.String()
+}
+
+// Describe free space of each filesystem holding category paths, once per device
+func diskSpaceReport() string {
+	var devices []uint64
+	categories := make(map[uint64][]string)
+	space := make(map[uint64][2]int64)
+	for _, cat := range getCategories() {
+		device, free, total, err := diskSpace(categoryRoot(cat.Name))
+		if err != nil {
+			// Paths that are missing or not local are left out
+			continue
+		}
+		if _, seen := space[device]; !seen {
+			devices = append(devices, device)
+			space[device] = [2]int64{free, total}
+		}
+		categories[device] = append(categories[device], cat.Name)
+	}
+	if len(devices) == 0 {
+		return ""
+	}
+
+	var text strings.Builder
+	text.WriteString("\nFree disk space:\n")
+	for _, device := range devices {
+		free, total := space[device][0], space[device][1]
+		fmt.Fprintf(&text, "%s: %s free of %s\n", strings.Join(categories[device], ", "), formatBytes(free), formatBytes(total))
+	}
+	return text.String()
+}
+
+// Format byte count in human-readable units
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit && bytes > -unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	units := []string{"KB", "MB", "GB", "TB"}
+	i := -1
+	for (value >= unit || value <= -unit) && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// Format change of a byte count with its sign, e.g. +1.5 MB or -200 B
+func formatBytesChange(delta int64) string {
+	if delta < 0 {
+		return formatBytes(delta)
+	}
+	return "+" + formatBytes(delta)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFormatBytesChange(t *testing.T) {
+	tests := []struct {
+		delta int64
+		want  string
+	}{
+		{delta: 0, want: "+0 B"},
+		{delta: 200, want: "+200 B"},
+		{delta: -200, want: "-200 B"},
+		{delta: 1536, want: "+1.5 KB"},
+		{delta: -3 * 1024 * 1024, want: "-3.0 MB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytesChange(tt.delta); got != tt.want {
+			t.Errorf("formatBytesChange(%d) = %q, want %q", tt.delta, got, tt.want)
+		}
+	}
+}
+
+func TestSendTrendsMessageSigns(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous usageTotals
+		current  usageTotals
+		want     string
+	}{
+		{name: "growth", previous: usageTotals{Count: 2, Bytes: 100}, current: usageTotals{Count: 5, Bytes: 400}, want: "docs: 5 files (400 B), +3 files (+300 B)"},
+		{name: "shrink", previous: usageTotals{Count: 5, Bytes: 400}, current: usageTotals{Count: 2, Bytes: 100}, want: "docs: 2 files (100 B), -3 files (-300 B)"},
+		{name: "unchanged", previous: usageTotals{Count: 2, Bytes: 100}, current: usageTotals{Count: 2, Bytes: 100}, want: "docs: 2 files (100 B), +0 files (+0 B)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t, "docs")
+			trendsMu.Lock()
+			usageSnapshots = []usageSnapshot{
+				{Date: "2024-06-01", Categories: map[string]usageTotals{"docs": tt.previous}},
+				{Date: "2024-06-02", Categories: map[string]usageTotals{"docs": tt.current}},
+			}
+			trendsMu.Unlock()
+			t.Cleanup(func() { usageSnapshots = nil })
+			fks := newFakeSender(t)
+
+			sendTrendsMessage(fks, commandMessage("/trends"))
+
+			if !fks.sentContaining(tt.want) {
+				t.Errorf("trends reply %q does not contain %q", fks.lastText(), tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadSourceReport(t *testing.T) {
+	trashed := time.Now()
+	tests := []struct {
+		name    string
+		records []FileRecord
+		want    string
+	}{
+		{name: "no files", want: ""},
+		{
+			name: "grouped by chat type",
+			records: []FileRecord{
+				{Name: "a.txt", ChatType: "private", Size: 100},
+				{Name: "b.txt", ChatType: "group", Size: 200},
+				{Name: "c.txt", ChatType: "private", Size: 300},
+			},
+			want: "\nFiles by source:\ngroup: 1 files (200 B)\nprivate: 2 files (400 B)\n",
+		},
+		{
+			name:    "saved before chat types were recorded",
+			records: []FileRecord{{Name: "a.txt", Size: 100}},
+			want:    "\nFiles by source:\nunknown: 1 files (100 B)\n",
+		},
+		{
+			name: "trashed files left out",
+			records: []FileRecord{
+				{Name: "a.txt", ChatType: "channel", Size: 100},
+				{Name: "b.txt", ChatType: "group", Size: 200, TrashedAt: &trashed},
+			},
+			want: "\nFiles by source:\nchannel: 1 files (100 B)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			for _, record := range tt.records {
+				record.Path = filepath.Join(dir, "docs", record.Name)
+				record.Category = "docs"
+				if err := metadata.Add(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := uploadSourceReport(); got != tt.want {
+				t.Errorf("uploadSourceReport() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadSourceRecorded(t *testing.T) {
+	tests := []struct {
+		chatType string
+	}{
+		{chatType: "private"},
+		{chatType: "group"},
+		{chatType: "supergroup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chatType, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+			message := documentMessage("file-1", "new.txt", "/docs", 4)
+			message.Chat.Type = tt.chatType
+
+			handleFileMessage(fks, message)
+
+			record := metadata.FindByPath(filepath.Join(dir, "docs", "new.txt"))
+			if record.Path == "" {
+				t.Fatalf("file not recorded, replies %q", fks.texts())
+			}
+			if record.ChatType != tt.chatType {
+				t.Errorf("chat type = %q, want %q", record.ChatType, tt.chatType)
+			}
+		})
+	}
+}
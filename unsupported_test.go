@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUnsupportedMessageType(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *tgbotapi.Message
+		want    string
+	}{
+		{name: "text", message: &tgbotapi.Message{Text: "hello"}, want: "text"},
+		{name: "sticker", message: &tgbotapi.Message{Sticker: &tgbotapi.Sticker{}}, want: "sticker"},
+		{name: "animation", message: &tgbotapi.Message{Animation: &tgbotapi.Animation{}}, want: "animation"},
+		{name: "poll", message: &tgbotapi.Message{Poll: &tgbotapi.Poll{}}, want: "poll"},
+		{name: "dice", message: &tgbotapi.Message{Dice: &tgbotapi.Dice{}}, want: "dice"},
+		{name: "venue before location", message: &tgbotapi.Message{Venue: &tgbotapi.Venue{}, Location: &tgbotapi.Location{}}, want: "venue"},
+		{name: "location", message: &tgbotapi.Message{Location: &tgbotapi.Location{}}, want: "location"},
+		{name: "contact", message: &tgbotapi.Message{Contact: &tgbotapi.Contact{}}, want: "contact"},
+		{name: "service message", message: &tgbotapi.Message{NewChatMembers: []tgbotapi.User{{ID: 2}}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unsupportedMessageType(tt.message); got != tt.want {
+				t.Errorf("unsupportedMessageType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleUnsupportedMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		chatType  string
+		sticker   bool
+		wantReply string // Empty when no reply is expected
+	}{
+		{name: "reply to text", mode: "", chatType: "private", wantReply: "Please send a file"},
+		{name: "reply names the type", mode: unsupportedReply, chatType: "group", sticker: true, wantReply: "Messages of type sticker are not supported."},
+		{name: "ignore", mode: unsupportedIgnore, chatType: "private"},
+		{name: "private mode in private chat", mode: unsupportedPrivate, chatType: "private", wantReply: "Please send a file"},
+		{name: "private mode in group", mode: unsupportedPrivate, chatType: "group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.UnsupportedMessages = tt.mode
+			fks := newFakeSender(t)
+
+			message := commandMessage("hello")
+			message.Entities = nil
+			message.Chat.Type = tt.chatType
+			if tt.sticker {
+				message.Text = ""
+				message.Sticker = &tgbotapi.Sticker{}
+			}
+			handleUnsupportedMessage(fks, message)
+
+			if tt.wantReply == "" {
+				if texts := fks.texts(); len(texts) != 0 {
+					t.Errorf("replies %q, want none", texts)
+				}
+				return
+			}
+			if !fks.sentContaining(tt.wantReply) {
+				t.Errorf("replies %q do not contain %q", fks.texts(), tt.wantReply)
+			}
+		})
+	}
+}
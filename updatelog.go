@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Update log levels
+const (
+	updateLogOff     = "off"
+	updateLogBasic   = "basic"   // IDs, kind and duration only
+	updateLogContent = "content" // Also message text, captions and callback data
+)
+
+// Log start and end of handling an update, with its processing duration
+func logUpdate(update tgbotapi.Update, handle func()) {
+	level := config.UpdateLog
+	if level == "" {
+		level = updateLogBasic
+	}
+	if level == updateLogOff {
+		handle()
+		return
+	}
+
+	userID, chatID, kind, content := describeUpdate(update)
+	if level == updateLogContent && content != "" {
+		log.Printf("update=%d user=%s chat=%s type=%s start content=%q", update.UpdateID, userID, chatID, kind, content)
+	} else {
+		log.Printf("update=%d user=%s chat=%s type=%s start", update.UpdateID, userID, chatID, kind)
+	}
+
+	started := time.Now()
+	defer func() {
+		log.Printf("update=%d user=%s chat=%s type=%s end duration=%s", update.UpdateID, userID, chatID, kind, time.Since(started).Round(time.Millisecond))
+	}()
+	handle()
+}
+
+// Get sender, chat, kind and text content of update, IDs are "-" when absent
+func describeUpdate(update tgbotapi.Update) (userID, chatID, kind, content string) {
+	userID, chatID = "-", "-"
+
+	if query := update.CallbackQuery; query != nil {
+		if query.From != nil {
+			userID = strconv.FormatInt(query.From.ID, 10)
+		}
+		if query.Message != nil {
+			chatID = strconv.FormatInt(query.Message.Chat.ID, 10)
+		}
+		return userID, chatID, "callback", query.Data
+	}
+
+	message := update.Message
+	if message == nil {
+		return userID, chatID, "other", ""
+	}
+	if message.From != nil {
+		userID = strconv.FormatInt(message.From.ID, 10)
+	}
+	if message.Chat != nil {
+		chatID = strconv.FormatInt(message.Chat.ID, 10)
+	}
+
+	switch {
+	case message.IsCommand():
+		kind = "command"
+	case hasAttachment(message):
+		kind = attachmentType(message)
+	default:
+		kind = unsupportedMessageType(message)
+		if kind == "" {
+			kind = "other"
+		}
+	}
+
+	content = message.Text
+	if content == "" {
+		content = message.Caption
+	}
+	return userID, chatID, kind, content
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Telegram keeps download links valid for at least an hour
+const defaultURLCacheTTL = 50 * time.Minute
+
+// cachedFileURL represents a download link and when it stops being reused
+type cachedFileURL struct {
+	URL     string
+	Expires time.Time
+}
+
+var (
+	fileURLCacheMu sync.Mutex
+	fileURLCache   = make(map[string]cachedFileURL) // Map of file ID to download link
+)
+
+// Get configured time a download link is reused, 0 when caching is disabled
+func urlCacheTTL() time.Duration {
+	switch {
+	case config.Download.URLCacheTTL < 0:
+		return 0
+	case config.Download.URLCacheTTL > 0:
+		return time.Duration(config.Download.URLCacheTTL) * time.Second
+	}
+	return defaultURLCacheTTL
+}
+
+// Get download link of file, reusing a recent one instead of asking Telegram again
+func getFileURL(bot Sender, fileID string) (string, error) {
+	ttl := urlCacheTTL()
+	now := time.Now()
+
+	fileURLCacheMu.Lock()
+	cached, ok := fileURLCache[fileID]
+	fileURLCacheMu.Unlock()
+	if ok && now.Before(cached.Expires) {
+		return cached.URL, nil
+	}
+
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil || ttl == 0 {
+		return fileURL, err
+	}
+
+	fileURLCacheMu.Lock()
+	defer fileURLCacheMu.Unlock()
+
+	// Drop expired links so the cache only holds files in use
+	for id, entry := range fileURLCache {
+		if now.After(entry.Expires) {
+			delete(fileURLCache, id)
+		}
+	}
+	fileURLCache[fileID] = cachedFileURL{URL: fileURL, Expires: now.Add(ttl)}
+	return fileURL, nil
+}
+
+// Drop cached download link of file, e.g. after the server refused it
+func forgetFileURL(fileID string) {
+	fileURLCacheMu.Lock()
+	defer fileURLCacheMu.Unlock()
+	delete(fileURLCache, fileID)
+}
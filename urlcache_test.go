@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestGetFileURLCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       int
+		between   func()
+		wantCalls int
+	}{
+		{name: "reused within the default TTL", between: func() {}, wantCalls: 1},
+		{name: "reused within a configured TTL", ttl: 60, between: func() {}, wantCalls: 1},
+		{name: "caching disabled", ttl: -1, between: func() {}, wantCalls: 2},
+		{name: "forgotten link", between: func() { forgetFileURL("file-1") }, wantCalls: 2},
+		{name: "expired link", between: func() {
+			fileURLCacheMu.Lock()
+			entry := fileURLCache["file-1"]
+			entry.Expires = time.Now().Add(-time.Second)
+			fileURLCache["file-1"] = entry
+			fileURLCacheMu.Unlock()
+		}, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestBot(t)
+			config.Download.URLCacheTTL = tt.ttl
+			fks := newFakeSender(t)
+			fks.addFile("file-1", []byte("data"))
+
+			first, err := getFileURL(fks, "file-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.between()
+			second, err := getFileURL(fks, "file-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if first != second {
+				t.Errorf("links differ: %q, %q", first, second)
+			}
+			if fks.urlCalls != tt.wantCalls {
+				t.Errorf("requested link %d times, want %d", fks.urlCalls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGetFileURLErrorNotCached(t *testing.T) {
+	setupTestBot(t)
+	fks := newFakeSender(t)
+	fks.urlErrs["file-1"] = &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}
+
+	if _, err := getFileURL(fks, "file-1"); err == nil {
+		t.Fatal("error not returned")
+	}
+	delete(fks.urlErrs, "file-1")
+	fks.addFile("file-1", []byte("data"))
+	if _, err := getFileURL(fks, "file-1"); err != nil {
+		t.Errorf("failed request was cached: %v", err)
+	}
+}
+
+// Run with -race: saves of different files share the cache
+func TestGetFileURLConcurrent(t *testing.T) {
+	setupTestBot(t)
+	fks := newFakeSender(t)
+	for i := 0; i < 10; i++ {
+		fks.addFile(fmt.Sprintf("file-%d", i), []byte("data"))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileID := fmt.Sprintf("file-%d", i%10)
+			if _, err := getFileURL(fks, fileID); err != nil {
+				t.Error(err)
+			}
+			if i%7 == 0 {
+				forgetFileURL(fileID)
+			}
+		}()
+	}
+	wg.Wait()
+}
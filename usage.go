@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	usagePageSize    = 10   // Users listed per /usage page
+	maxMessageLength = 4096 // Telegram limit of characters in a message
+)
+
+// userUsage represents storage used by one user
+type userUsage struct {
+	UserID int64
+	usageTotals
+}
+
+// Sum stored files per user from metadata, largest first
+func usageByUser() []userUsage {
+	totals := make(map[int64]usageTotals)
+	for _, record := range metadata.Records() {
+		if record.TrashedAt != nil {
+			continue
+		}
+		addUsage(totals, record.UserID, record.Size)
+	}
+
+	users := make([]userUsage, 0, len(totals))
+	for userID, total := range totals {
+		users = append(users, userUsage{UserID: userID, usageTotals: total})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Bytes != users[j].Bytes {
+			return users[i].Bytes > users[j].Bytes
+		}
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+// Send storage used per user, admins only; accepts a page number or "all"
+func handleUsageCommand(bot Sender, message *tgbotapi.Message, args string) {
+	if !isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Only bot administrators can view usage per user.")
+		bot.Send(msg)
+		return
+	}
+
+	users := usageByUser()
+	if len(users) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "No files stored yet.")
+		bot.Send(msg)
+		return
+	}
+
+	pages := (len(users) + usagePageSize - 1) / usagePageSize
+	page := 1
+	args = strings.TrimSpace(args)
+	switch {
+	case args == "all":
+		page = 0
+	case args != "":
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > pages {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Usage: /usage [page 1-%d | all]", pages))
+			bot.Send(msg)
+			return
+		}
+		page = n
+	}
+
+	// Long lists go out as several messages to stay within the message size limit
+	for _, text := range usageReport(users, page, pages) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		bot.Send(msg)
+	}
+}
+
+// Format usage of one page of users, or all users when page is 0, as message texts
+func usageReport(users []userUsage, page, pages int) []string {
+	start, end := 0, len(users)
+	header := fmt.Sprintf("Storage by user, all %d users:\n", len(users))
+	if page > 0 {
+		start = (page - 1) * usagePageSize
+		end = min(start+usagePageSize, len(users))
+		header = fmt.Sprintf("Storage by user, page %d of %d:\n", page, pages)
+	}
+
+	var texts []string
+	var text strings.Builder
+	text.WriteString(header)
+	for i := start; i < end; i++ {
+		line := fmt.Sprintf("%d. %d: %d files (%s)\n", i+1, users[i].UserID, users[i].Count, formatBytes(users[i].Bytes))
+		if text.Len()+len(line) > maxMessageLength {
+			texts = append(texts, text.String())
+			text.Reset()
+		}
+		text.WriteString(line)
+	}
+	if page > 0 && page < pages {
+		fmt.Fprintf(&text, "\nNext: /usage %d, or /usage all", page+1)
+	}
+	return append(texts, text.String())
+}
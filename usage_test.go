@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageByUser(t *testing.T) {
+	trashed := time.Now()
+	tests := []struct {
+		name    string
+		records []FileRecord
+		want    []userUsage
+	}{
+		{name: "no files"},
+		{
+			name:    "sums per user",
+			records: []FileRecord{{UserID: 1, Size: 100}, {UserID: 1, Size: 50}, {UserID: 2, Size: 10}},
+			want:    []userUsage{{UserID: 1, usageTotals: usageTotals{Count: 2, Bytes: 150}}, {UserID: 2, usageTotals: usageTotals{Count: 1, Bytes: 10}}},
+		},
+		{
+			name:    "largest first",
+			records: []FileRecord{{UserID: 1, Size: 10}, {UserID: 2, Size: 500}},
+			want:    []userUsage{{UserID: 2, usageTotals: usageTotals{Count: 1, Bytes: 500}}, {UserID: 1, usageTotals: usageTotals{Count: 1, Bytes: 10}}},
+		},
+		{
+			name:    "ties by user ID",
+			records: []FileRecord{{UserID: 3, Size: 10}, {UserID: 2, Size: 10}},
+			want:    []userUsage{{UserID: 2, usageTotals: usageTotals{Count: 1, Bytes: 10}}, {UserID: 3, usageTotals: usageTotals{Count: 1, Bytes: 10}}},
+		},
+		{
+			name:    "trashed files left out",
+			records: []FileRecord{{UserID: 1, Size: 10}, {UserID: 1, Size: 90, TrashedAt: &trashed}, {UserID: 2, Size: 5, TrashedAt: &trashed}},
+			want:    []userUsage{{UserID: 1, usageTotals: usageTotals{Count: 1, Bytes: 10}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			addUsageRecords(t, dir, tt.records)
+
+			if got := usageByUser(); !slices.Equal(got, tt.want) {
+				t.Errorf("usageByUser() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleUsageCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		admin    bool
+		users    int // Users with one file each
+		args     string
+		want     []string // Texts the replies contain
+		wantSent int
+	}{
+		{name: "not an admin", users: 1, want: []string{"Only bot administrators"}, wantSent: 1},
+		{name: "no files", admin: true, want: []string{"No files stored yet."}, wantSent: 1},
+		{name: "first page", admin: true, users: 25, want: []string{"page 1 of 3", "10. ", "Next: /usage 2"}, wantSent: 1},
+		{name: "last page", admin: true, users: 25, args: "3", want: []string{"page 3 of 3", "25. "}, wantSent: 1},
+		{name: "all users", admin: true, users: 25, args: "all", want: []string{"all 25 users", "1. ", "25. "}, wantSent: 1},
+		{name: "page past the end", admin: true, users: 25, args: "4", want: []string{"Usage: /usage [page 1-3 | all]"}, wantSent: 1},
+		{name: "invalid page", admin: true, users: 25, args: "next", want: []string{"Usage: /usage"}, wantSent: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupTestBot(t, "docs")
+			if tt.admin {
+				config.Admins = []int64{1}
+			}
+			var records []FileRecord
+			for i := 0; i < tt.users; i++ {
+				records = append(records, FileRecord{UserID: int64(100 + i), Size: int64(1000 - i)})
+			}
+			addUsageRecords(t, dir, records)
+			fks := newFakeSender(t)
+
+			handleUsageCommand(fks, commandMessage("/usage "+tt.args), tt.args)
+
+			if len(fks.sent) != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", len(fks.sent), tt.wantSent)
+			}
+			for _, want := range tt.want {
+				if !fks.sentContaining(want) {
+					t.Errorf("replies %q do not contain %q", fks.texts(), want)
+				}
+			}
+			if tt.args == "3" && fks.sentContaining("Next:") {
+				t.Errorf("last page links to a next page")
+			}
+		})
+	}
+}
+
+func TestUsageReportSplitsLongLists(t *testing.T) {
+	var users []userUsage
+	for i := 0; i < 300; i++ {
+		users = append(users, userUsage{UserID: int64(1000000000 + i), usageTotals: usageTotals{Count: 1, Bytes: 1}})
+	}
+
+	texts := usageReport(users, 0, 30)
+	if len(texts) < 2 {
+		t.Fatalf("%d messages, want the list split", len(texts))
+	}
+	var lines int
+	for _, text := range texts {
+		if len(text) > maxMessageLength {
+			t.Errorf("message of %d characters exceeds the limit", len(text))
+		}
+		lines += strings.Count(text, ": 1 files")
+	}
+	if lines != len(users) {
+		t.Errorf("%d users listed, want %d", lines, len(users))
+	}
+}
+
+// Record stored files for usage reports, paths are made unique under the docs category
+func addUsageRecords(t *testing.T, dir string, records []FileRecord) {
+	t.Helper()
+	for i, record := range records {
+		record.Path = filepath.Join(dir, "docs", fmt.Sprintf("file-%d.txt", i))
+		record.Category = "docs"
+		if err := metadata.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+}